@@ -0,0 +1,50 @@
+// operator-gen generates go types and typed handler wrapper from operator yaml.
+//
+//	operator-gen -f ./operator.yaml -o ./operator_generated.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/querycap/pipeline/pipeline"
+	"github.com/querycap/pipeline/pkg/operatorgen"
+)
+
+func main() {
+	filename := flag.String("f", "operator.yaml", "operator yaml")
+	output := flag.String("o", "operator_generated.go", "output go file")
+	pkg := flag.String("p", "", "package name, defaults to name of directory of output")
+
+	flag.Parse()
+
+	if err := generate(*filename, *output, *pkg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func generate(filename string, output string, pkg string) error {
+	op, err := pipeline.OperatorFromYAML(filename)
+	if err != nil {
+		return err
+	}
+
+	if pkg == "" {
+		dir, err := filepath.Abs(filepath.Dir(output))
+		if err != nil {
+			return err
+		}
+		pkg = filepath.Base(dir)
+	}
+
+	src, err := operatorgen.Generate(op, pkg)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(output, src, 0644)
+}
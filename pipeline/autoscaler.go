@@ -0,0 +1,135 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/querycap/pipeline/spec"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultReplicas of stage without replicas declared
+var DefaultReplicas int32 = 3
+
+const (
+	// DefaultAutoscaleInterval of autoscaler started with pipeline
+	DefaultAutoscaleInterval = 10 * time.Second
+	// DefaultTasksPerReplica of autoscaler started with pipeline
+	DefaultTasksPerReplica = 1
+)
+
+// StageReplicas returns the replicas of stage when started.
+func StageReplicas(stage spec.Stage) int32 {
+	if stage.Replicas > 0 {
+		return stage.Replicas
+	}
+	if stage.MinReplicas > 0 {
+		return stage.MinReplicas
+	}
+	if stage.MaxReplicas > 0 && stage.MaxReplicas < DefaultReplicas {
+		return stage.MaxReplicas
+	}
+	return DefaultReplicas
+}
+
+func NewAutoscaler(p *Pipeline, tasksPerReplica int) *Autoscaler {
+	if tasksPerReplica <= 0 {
+		tasksPerReplica = 1
+	}
+
+	replicas := map[string]int32{}
+
+	for name, stage := range p.spec.Stages {
+		if stage.Autoscaling() {
+			replicas[name] = StageReplicas(stage)
+		}
+	}
+
+	return &Autoscaler{
+		pipeline:        p,
+		tasksPerReplica: tasksPerReplica,
+		replicas:        replicas,
+	}
+}
+
+// Autoscaler scales stages with replicas range,
+// by the queue depth of stage topics in event bus.
+type Autoscaler struct {
+	pipeline        *Pipeline
+	tasksPerReplica int
+	rw              sync.Mutex
+	replicas        map[string]int32
+}
+
+// Run scales stages every interval until ctx done.
+func (a *Autoscaler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.Scale(ctx); err != nil {
+				logrus.WithContext(ctx).Warnf("autoscale %s failed: %s", a.pipeline.taskMeta.Scope, err)
+			}
+		}
+	}
+}
+
+// Scale applies desired replicas of each autoscaling stage.
+// Stages scale up to the desired replicas at once,
+// but scale down one replica per call to avoid killing busy replicas too fast.
+func (a *Autoscaler) Scale(ctx context.Context) error {
+	a.rw.Lock()
+	defer a.rw.Unlock()
+
+	for name, current := range a.replicas {
+		stage := a.pipeline.spec.Stages[name]
+
+		depth, err := a.pipeline.mgr.pipelineController.QueueDepth(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		desired := a.desired(stage, depth)
+
+		if desired < current {
+			desired = current - 1
+		}
+
+		if desired == current {
+			continue
+		}
+
+		logrus.WithContext(ctx).Debugf("scale %s/%s from %d to %d, queue depth %d", a.pipeline.taskMeta.Scope, name, current, desired, depth)
+
+		if err := a.pipeline.mgr.operatorMgr.Up(a.pipeline.taskMeta.Scope, name, stage, desired); err != nil {
+			return err
+		}
+
+		a.replicas[name] = desired
+	}
+
+	return nil
+}
+
+func (a *Autoscaler) desired(stage spec.Stage, depth int) int32 {
+	// at least one replica to consume the queue
+	min := stage.MinReplicas
+	if min < 1 {
+		min = 1
+	}
+
+	desired := int32((depth + a.tasksPerReplica - 1) / a.tasksPerReplica)
+
+	if desired < min {
+		return min
+	}
+	if desired > stage.MaxReplicas {
+		return stage.MaxReplicas
+	}
+	return desired
+}
@@ -0,0 +1,95 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/querycap/pipeline/spec"
+)
+
+var ErrSchemaViolation = errors.New("schema violation")
+
+// validateInputs checks content type of inputs of task, and json body against schema.
+// Input with unknown content type is treated as the declared one.
+func validateInputs(pipelineController PipelineController, ctx context.Context, task *Task, dataSchema spec.DataSchema) error {
+	if !dataSchema.Declared() {
+		return nil
+	}
+
+	for _, input := range task.Inputs {
+		info, err := pipelineController.Stat(ctx, input)
+		if err != nil {
+			return err
+		}
+
+		if info.ContentType != "" && !dataSchema.MatchContentType(info.ContentType) {
+			return fmt.Errorf("%w: input %s should be %s, but got %s", ErrSchemaViolation, input, dataSchema.ContentType, info.ContentType)
+		}
+
+		if !dataSchema.IsJSON() {
+			continue
+		}
+
+		f, err := pipelineController.Read(ctx, input)
+		if err != nil {
+			return err
+		}
+
+		data, err := ioutil.ReadAll(f)
+		_ = f.Close()
+		if err != nil {
+			return err
+		}
+
+		if err := validateJSON(data, dataSchema.Schema); err != nil {
+			return fmt.Errorf("%w: input %s: %s", ErrSchemaViolation, input, err)
+		}
+	}
+
+	return nil
+}
+
+// validateOutput checks content type of output, and json body against schema,
+// returns the buffered output to put instead, since json body consumed when validating.
+func validateOutput(writerTo io.WriterTo, dataSchema spec.DataSchema) (io.WriterTo, error) {
+	contentType := ""
+	if contentTypeDescriber, ok := writerTo.(ContentTypeDescriber); ok {
+		contentType = contentTypeDescriber.ContentType()
+	}
+
+	if contentType != "" && !dataSchema.MatchContentType(contentType) {
+		return nil, fmt.Errorf("%w: output should be %s, but got %s", ErrSchemaViolation, dataSchema.ContentType, contentType)
+	}
+
+	if !dataSchema.IsJSON() {
+		return writerTo, nil
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if _, err := writerTo.WriteTo(buf); err != nil {
+		return nil, err
+	}
+
+	if err := validateJSON(buf.Bytes(), dataSchema.Schema); err != nil {
+		return nil, fmt.Errorf("%w: output: %s", ErrSchemaViolation, err)
+	}
+
+	if contentType == "" {
+		contentType = dataSchema.ContentType
+	}
+
+	return WithContentType(contentType)(buf), nil
+}
+
+func validateJSON(data []byte, schema spec.Schema) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("$: invalid json: %s", err)
+	}
+	return schema.Validate(v)
+}
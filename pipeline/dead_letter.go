@@ -0,0 +1,85 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetterStore keeps tasks failed in stage or undeliverable to stage, keyed by pipeline scope.
+type DeadLetterStore interface {
+	Put(ctx context.Context, letter *DeadLetter) error
+	// List returns dead letters of scope, latest first
+	List(ctx context.Context, scope string) ([]*DeadLetter, error)
+	Get(ctx context.Context, scope string, id string) (*DeadLetter, error)
+	Del(ctx context.Context, scope string, id string) error
+}
+
+type DeadLetterReason string
+
+const (
+	// task failed in stage after all attempts
+	DeadLetterFailed DeadLetterReason = "failed"
+	// no subscriptions for the stage task sent to
+	DeadLetterUndeliverable DeadLetterReason = "undeliverable"
+)
+
+type DeadLetter struct {
+	// <taskID>/<stage>
+	ID     string
+	Scope  string
+	Stage  string
+	Reason DeadLetterReason
+	ErrMsg string
+	// errors of all attempts in order
+	Errors []string `json:",omitempty"`
+	// Task to handle in Stage
+	Task *Task
+	// exact inputs of Stage and stages upstream which the task went through, for requeue to them
+	Inputs    map[string][]string `json:",omitempty"`
+	CreatedAt time.Time
+}
+
+func NewDeadLetter(task *Task, reason DeadLetterReason, err error) *DeadLetter {
+	letter := &DeadLetter{
+		ID:        DeadLetterID(task.ID, task.Stage),
+		Scope:     task.Scope,
+		Stage:     task.Stage,
+		Reason:    reason,
+		ErrMsg:    err.Error(),
+		Task:      task,
+		Inputs:    map[string][]string{},
+		CreatedAt: time.Now(),
+	}
+
+	for s := task.TaskStage; s != nil; s = s.Upstream {
+		if _, ok := letter.Inputs[s.Stage]; !ok {
+			letter.Inputs[s.Stage] = s.Inputs
+		}
+	}
+
+	letter.Errors = append(append(letter.Errors, task.Errors...), letter.ErrMsg)
+
+	return letter
+}
+
+func DeadLetterID(taskID uint64, stage string) string {
+	return strings.Join([]string{strconv.FormatUint(taskID, 10), stage}, "/")
+}
+
+func putDeadLetter(pipelineController PipelineController, ctx context.Context, letter *DeadLetter) {
+	deadLetterStore := pipelineController.DeadLetterStore()
+	if deadLetterStore == nil {
+		return
+	}
+
+	if err := deadLetterStore.Put(ctx, letter); err != nil {
+		logrus.WithContext(ctx).Warnf("put dead letter %s failed: %s", letter.ID, err)
+	}
+}
@@ -0,0 +1,70 @@
+package mem
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/querycap/pipeline/pipeline"
+)
+
+func NewMemDeadLetterStore() pipeline.DeadLetterStore {
+	return &MemDeadLetterStore{
+		letters: map[string]map[string]*pipeline.DeadLetter{},
+	}
+}
+
+type MemDeadLetterStore struct {
+	rw      sync.RWMutex
+	letters map[string]map[string]*pipeline.DeadLetter
+}
+
+func (m *MemDeadLetterStore) Put(ctx context.Context, letter *pipeline.DeadLetter) error {
+	m.rw.Lock()
+	defer m.rw.Unlock()
+
+	if m.letters[letter.Scope] == nil {
+		m.letters[letter.Scope] = map[string]*pipeline.DeadLetter{}
+	}
+
+	m.letters[letter.Scope][letter.ID] = letter
+
+	return nil
+}
+
+func (m *MemDeadLetterStore) List(ctx context.Context, scope string) ([]*pipeline.DeadLetter, error) {
+	m.rw.RLock()
+	defer m.rw.RUnlock()
+
+	list := make([]*pipeline.DeadLetter, 0, len(m.letters[scope]))
+	for _, letter := range m.letters[scope] {
+		list = append(list, letter)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+
+	return list, nil
+}
+
+func (m *MemDeadLetterStore) Get(ctx context.Context, scope string, id string) (*pipeline.DeadLetter, error) {
+	m.rw.RLock()
+	defer m.rw.RUnlock()
+
+	letter, ok := m.letters[scope][id]
+	if !ok {
+		return nil, pipeline.ErrDeadLetterNotFound
+	}
+
+	return letter, nil
+}
+
+func (m *MemDeadLetterStore) Del(ctx context.Context, scope string, id string) error {
+	m.rw.Lock()
+	defer m.rw.Unlock()
+
+	delete(m.letters[scope], id)
+
+	return nil
+}
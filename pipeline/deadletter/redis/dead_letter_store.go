@@ -0,0 +1,126 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/querycap/pipeline/pipeline"
+)
+
+type RedisPool interface {
+	Get() redis.Conn
+}
+
+// NewRedisDeadLetterStore creates DeadLetterStore on redis,
+// dead letters of scope are kept in hash <scope>:dead_letters until deleted,
+// and indexed by created time in sorted set <scope>:dead_letters:index.
+func NewRedisDeadLetterStore(pool RedisPool) pipeline.DeadLetterStore {
+	return &RedisDeadLetterStore{pool: pool}
+}
+
+type RedisDeadLetterStore struct {
+	pool RedisPool
+}
+
+func (r *RedisDeadLetterStore) Put(ctx context.Context, letter *pipeline.DeadLetter) error {
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	if err := conn.Send("MULTI"); err != nil {
+		return err
+	}
+
+	_ = conn.Send("HSET", lettersKey(letter.Scope), letter.ID, data)
+	_ = conn.Send("ZADD", indexKey(letter.Scope), letter.CreatedAt.UnixNano(), letter.ID)
+
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+func (r *RedisDeadLetterStore) List(ctx context.Context, scope string) ([]*pipeline.DeadLetter, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	ids, err := redis.Strings(conn.Do("ZREVRANGE", indexKey(scope), 0, -1))
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*pipeline.DeadLetter, 0, len(ids))
+
+	if len(ids) == 0 {
+		return list, nil
+	}
+
+	args := redis.Args{}.Add(lettersKey(scope)).AddFlat(ids)
+
+	values, err := redis.ByteSlices(conn.Do("HMGET", args...))
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range values {
+		// deleted between
+		if values[i] == nil {
+			continue
+		}
+
+		letter := &pipeline.DeadLetter{}
+		if err := json.Unmarshal(values[i], letter); err != nil {
+			return nil, err
+		}
+		list = append(list, letter)
+	}
+
+	return list, nil
+}
+
+func (r *RedisDeadLetterStore) Get(ctx context.Context, scope string, id string) (*pipeline.DeadLetter, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("HGET", lettersKey(scope), id))
+	if err != nil {
+		if err == redis.ErrNil {
+			return nil, pipeline.ErrDeadLetterNotFound
+		}
+		return nil, err
+	}
+
+	letter := &pipeline.DeadLetter{}
+	if err := json.Unmarshal(data, letter); err != nil {
+		return nil, err
+	}
+
+	return letter, nil
+}
+
+func (r *RedisDeadLetterStore) Del(ctx context.Context, scope string, id string) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	if err := conn.Send("MULTI"); err != nil {
+		return err
+	}
+
+	_ = conn.Send("HDEL", lettersKey(scope), id)
+	_ = conn.Send("ZREM", indexKey(scope), id)
+
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+func lettersKey(scope string) string {
+	return strings.Join([]string{scope, "dead_letters"}, ":")
+}
+
+func indexKey(scope string) string {
+	return strings.Join([]string{scope, "dead_letters", "index"}, ":")
+}
@@ -0,0 +1,56 @@
+package redis_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/querycap/pipeline/pipeline"
+	"github.com/querycap/pipeline/pipeline/deadletter/redis"
+	"github.com/querycap/pipeline/pkg/redisutil"
+)
+
+var pool, _ = redisutil.NewPool("tcp://127.0.0.1:6379")
+
+func TestRedisDeadLetterStore(t *testing.T) {
+	s := redis.NewRedisDeadLetterStore(pool)
+
+	ctx := context.Background()
+	scope := fmt.Sprintf("p/test:1.0.0/%d", time.Now().UnixNano())
+
+	task := &pipeline.Task{
+		TaskContext: pipeline.TaskContext{ID: 1, TaskMeta: pipeline.TaskMeta{Scope: scope}},
+		TaskStage:   pipeline.NewTaskStage("a", []string{"input"}),
+	}
+
+	task = task.Err(errors.New("first")).Retry()
+
+	letter := pipeline.NewDeadLetter(task, pipeline.DeadLetterFailed, errors.New("second"))
+	NewWithT(t).Expect(letter.ID).To(Equal("1/a"))
+	NewWithT(t).Expect(letter.Errors).To(Equal([]string{"first", "second"}))
+
+	NewWithT(t).Expect(s.Put(ctx, letter)).To(BeNil())
+	NewWithT(t).Expect(s.Put(ctx, pipeline.NewDeadLetter(task.Next("b", nil), pipeline.DeadLetterUndeliverable, pipeline.ErrNoSubscriptionsForTopic))).To(BeNil())
+
+	list, err := s.List(ctx, scope)
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(list).To(HaveLen(2))
+	NewWithT(t).Expect(list[0].ID).To(Equal("1/b"))
+
+	got, err := s.Get(ctx, scope, "1/a")
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(got.Task.Inputs).To(Equal([]string{"input"}))
+	NewWithT(t).Expect(got.Task.Attempt).To(Equal(1))
+
+	NewWithT(t).Expect(s.Del(ctx, scope, "1/a")).To(BeNil())
+
+	_, err = s.Get(ctx, scope, "1/a")
+	NewWithT(t).Expect(err).To(Equal(pipeline.ErrDeadLetterNotFound))
+
+	list, err = s.List(ctx, scope)
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(list).To(HaveLen(1))
+}
@@ -3,6 +3,7 @@ package pipeline
 import (
 	"context"
 	"errors"
+	"time"
 )
 
 var ErrNoSubscriptionsForTopic = errors.New("no subscriptions for topic")
@@ -11,9 +12,53 @@ type Subscription interface {
 	Unsubscribe()
 }
 
-type Handler = func(ctx context.Context, data []byte)
+// Handler acks the event by returning nil,
+// or nacks it by returning an error, then the event will be redelivered.
+// Events not acked, like when the process crashed, will be redelivered too.
+type Handler = func(ctx context.Context, data []byte) error
+
+// NackWithDelay wraps err returned by Handler,
+// to redeliver the event after delay, but not sooner than the default redelivery delay of event bus,
+// which keeps events nacked with tiny delay from redelivered in busy loop.
+func NackWithDelay(err error, delay time.Duration) error {
+	return &delayedNack{error: err, delay: delay}
+}
+
+// NackDelay returns delay of err set by NackWithDelay when longer than defaultDelay, otherwise defaultDelay.
+func NackDelay(err error, defaultDelay time.Duration) time.Duration {
+	d := &delayedNack{}
+	if errors.As(err, &d) && d.delay > defaultDelay {
+		return d.delay
+	}
+	return defaultDelay
+}
+
+type delayedNack struct {
+	error
+	delay time.Duration
+}
+
+func (e *delayedNack) Unwrap() error {
+	return e.error
+}
 
 type EventBus interface {
 	Publish(ctx context.Context, topic string, data []byte) error
 	Subscribe(topic string, callback Handler) Subscription
+	Joiner
+	QueueDepthReader
+}
+
+// Joiner keeps fan-in state shared by all replicas of a stage.
+//
+// Join stores data as the part of key.
+// Once n distinct parts are collected, the key is cleared and all the parts are returned,
+// to the caller who completes the key only; others got nil.
+type Joiner interface {
+	Join(ctx context.Context, key string, part string, data []byte, n int) (map[string][]byte, error)
+}
+
+// QueueDepthReader reports how many events of topic are waiting for handling.
+type QueueDepthReader interface {
+	QueueDepth(ctx context.Context, topic string) (int, error)
 }
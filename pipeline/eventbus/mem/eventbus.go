@@ -3,65 +3,154 @@ package mem
 import (
 	"context"
 	"sync"
+	"time"
 
 	"github.com/querycap/pipeline/pipeline"
 )
 
 func NewMemEventBus() pipeline.EventBus {
 	return &MemEventBus{
-		handlers: map[string]map[int]pipeline.Handler{},
+		topics: map[string]*memTopic{},
+		joins:  map[string]map[string][]byte{},
 	}
 }
 
+// nacked events redelivered after RedeliveryDelay, or longer delay set by pipeline.NackWithDelay
+var RedeliveryDelay = 100 * time.Millisecond
+
+// MemEventBus queues events of each topic,
+// every event is handled by one of subscriptions, and each subscription handles one event at a time,
+// like replicas consuming a queue.
+//
+// Notice it is not broadcast any more: subscriptions of same topic compete for events as the redis buses do,
+// instead of each handling every event, so callers wanting all events of a topic should subscribe it only once.
 type MemEventBus struct {
-	rw       sync.RWMutex
-	handlers map[string]map[int]pipeline.Handler
-	incr     int
+	mu     sync.Mutex
+	topics map[string]*memTopic
+
+	joinsRw sync.Mutex
+	joins   map[string]map[string][]byte
 }
 
-func (m *MemEventBus) Publish(ctx context.Context, topic string, data []byte) error {
-	m.rw.Lock()
-	defer m.rw.Unlock()
+type memTopic struct {
+	// waked when events queued or subscriptions stopped
+	cond          *sync.Cond
+	queue         [][]byte
+	subscriptions int
+	// nacked, waiting for redelivery
+	delayed int
+}
 
-	handlers, ok := m.handlers[topic]
+// topic should be called with lock
+func (m *MemEventBus) topic(name string) *memTopic {
+	t, ok := m.topics[name]
 	if !ok {
-		return pipeline.ErrNoSubscriptionsForTopic
+		t = &memTopic{cond: sync.NewCond(&m.mu)}
+		m.topics[name] = t
 	}
+	return t
+}
+
+func (m *MemEventBus) Publish(ctx context.Context, topic string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	if len(handlers) == 0 {
+	t, ok := m.topics[topic]
+	if !ok || t.subscriptions == 0 {
 		return pipeline.ErrNoSubscriptionsForTopic
 	}
 
-	for i := range handlers {
-		handler := handlers[i]
-		go func() {
-			handler(ctx, data)
-		}()
-	}
+	t.queue = append(t.queue, data)
+	t.cond.Signal()
 
 	return nil
 }
 
 func (m *MemEventBus) Subscribe(topic string, handler pipeline.Handler) pipeline.Subscription {
-	m.rw.Lock()
-	defer m.rw.Unlock()
+	m.mu.Lock()
+	t := m.topic(topic)
+	t.subscriptions++
+	m.mu.Unlock()
 
-	if m.handlers[topic] == nil {
-		m.handlers[topic] = map[int]pipeline.Handler{}
-	}
+	stopped := false
 
-	i := m.incr
+	go func() {
+		for {
+			m.mu.Lock()
+			for len(t.queue) == 0 && !stopped {
+				t.cond.Wait()
+			}
+			if stopped {
+				m.mu.Unlock()
+				return
+			}
+			data := t.queue[0]
+			t.queue = t.queue[1:]
+			m.mu.Unlock()
 
-	m.handlers[topic][i] = handler
-	m.incr++
+			if err := handler(context.Background(), data); err != nil {
+				m.redeliver(t, data, pipeline.NackDelay(err, RedeliveryDelay))
+			}
+		}
+	}()
 
 	return pipeline.NewSubscription(func() {
-		m.rw.Lock()
-		defer m.rw.Unlock()
+		m.mu.Lock()
+		defer m.mu.Unlock()
 
-		handlers, ok := m.handlers[topic]
-		if ok {
-			delete(handlers, i)
+		if !stopped {
+			stopped = true
+			t.subscriptions--
+			// the event handling is kept until done
+			t.cond.Broadcast()
 		}
 	})
 }
+
+func (m *MemEventBus) redeliver(t *memTopic, data []byte, delay time.Duration) {
+	m.mu.Lock()
+	t.delayed++
+	m.mu.Unlock()
+
+	time.AfterFunc(delay, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		t.delayed--
+		t.queue = append(t.queue, data)
+		t.cond.Signal()
+	})
+}
+
+func (m *MemEventBus) Join(ctx context.Context, key string, part string, data []byte, n int) (map[string][]byte, error) {
+	m.joinsRw.Lock()
+	defer m.joinsRw.Unlock()
+
+	parts, ok := m.joins[key]
+	if !ok {
+		parts = map[string][]byte{}
+		m.joins[key] = parts
+	}
+
+	parts[part] = data
+
+	if len(parts) < n {
+		return nil, nil
+	}
+
+	delete(m.joins, key)
+
+	return parts, nil
+}
+
+// QueueDepth returns count of events not picked by subscriptions, including the ones waiting for redelivery.
+func (m *MemEventBus) QueueDepth(ctx context.Context, topic string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.topics[topic]
+	if !ok {
+		return 0, nil
+	}
+	return len(t.queue) + t.delayed, nil
+}
@@ -2,17 +2,23 @@ package mem
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/querycap/pipeline/pipeline"
 )
 
 func TestMemEventBus(t *testing.T) {
 	s := NewMemEventBus()
 
-	sub := s.Subscribe("test", func(ctx context.Context, data []byte) {
+	sub := s.Subscribe("test", func(ctx context.Context, data []byte) error {
 		fmt.Println(string(data))
+		return nil
 	})
 
 	for i := 0; i < 10; i++ {
@@ -24,3 +30,69 @@ func TestMemEventBus(t *testing.T) {
 }
 
 func catch(err error) {}
+
+func TestMemEventBusRedelivery(t *testing.T) {
+	s := NewMemEventBus()
+
+	attempts := int32(0)
+
+	sub := s.Subscribe("test", func(ctx context.Context, data []byte) error {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			return errors.New("nack")
+		}
+		return nil
+	})
+	defer sub.Unsubscribe()
+
+	NewWithT(t).Expect(s.Publish(context.Background(), "test", []byte("1"))).To(BeNil())
+
+	NewWithT(t).Eventually(func() int32 {
+		return atomic.LoadInt32(&attempts)
+	}).Should(Equal(int32(3)))
+
+	NewWithT(t).Eventually(func() int {
+		n, _ := s.QueueDepth(context.Background(), "test")
+		return n
+	}).Should(Equal(0))
+}
+
+func TestMemEventBusNackWithDelay(t *testing.T) {
+	s := NewMemEventBus()
+
+	handledAt := make(chan time.Time, 2)
+	attempts := int32(0)
+
+	sub := s.Subscribe("test", func(ctx context.Context, data []byte) error {
+		handledAt <- time.Now()
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			return pipeline.NackWithDelay(errors.New("not due"), 300*time.Millisecond)
+		}
+		return nil
+	})
+	defer sub.Unsubscribe()
+
+	NewWithT(t).Expect(s.Publish(context.Background(), "test", []byte("1"))).To(BeNil())
+
+	first, second := <-handledAt, <-handledAt
+	NewWithT(t).Expect(second.Sub(first) >= 300*time.Millisecond).To(BeTrue())
+}
+
+func TestMemEventBusJoin(t *testing.T) {
+	s := NewMemEventBus()
+
+	parts, err := s.Join(context.Background(), "join", "a", []byte("a"), 2)
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(parts).To(BeNil())
+
+	parts, err = s.Join(context.Background(), "join", "a", []byte("a"), 2)
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(parts).To(BeNil())
+
+	parts, err = s.Join(context.Background(), "join", "b", []byte("b"), 2)
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(parts).To(Equal(map[string][]byte{"a": []byte("a"), "b": []byte("b")}))
+
+	parts, err = s.Join(context.Background(), "join", "b", []byte("b"), 2)
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(parts).To(BeNil())
+}
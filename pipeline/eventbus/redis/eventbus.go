@@ -2,11 +2,14 @@ package redis
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"strings"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
 	"github.com/querycap/pipeline/pipeline"
+	"github.com/querycap/pipeline/pkg/redisutil"
 	"github.com/sirupsen/logrus"
 )
 
@@ -16,133 +19,505 @@ type RedisPool interface {
 	Get() Conn
 }
 
-func NewRedisEventBus(pool RedisPool) pipeline.EventBus {
-	return &RedisEventBus{pool: pool}
+const (
+	// DefaultVisibilityTimeout is how long events picked by a dead consumer
+	// stay invisible before redelivered to others.
+	DefaultVisibilityTimeout = 30 * time.Second
+	// DefaultRedeliveryDelay is how long nacked events wait before redelivered,
+	// unless longer delay set by pipeline.NackWithDelay.
+	DefaultRedeliveryDelay = 100 * time.Millisecond
+)
+
+// consumers poll when not notified, since notifications are lost when the pubsub connection dropped
+var pollInterval = time.Second
+
+// keys of topic expire once no consumers refreshing for twice visibility timeout, but not less than minTopicTTL
+var minTopicTTL = time.Minute
+
+// backoff of consumers when connection dropped, doubled from minBackoff up to maxBackoff until recovered.
+var (
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 10 * time.Second
+)
+
+type RedisEventBusOption = func(r *RedisEventBus)
+
+func WithVisibilityTimeout(visibilityTimeout time.Duration) RedisEventBusOption {
+	return func(r *RedisEventBus) {
+		r.visibilityTimeout = visibilityTimeout
+	}
+}
+
+func WithRedeliveryDelay(redeliveryDelay time.Duration) RedisEventBusOption {
+	return func(r *RedisEventBus) {
+		r.redeliveryDelay = redeliveryDelay
+	}
 }
 
+func NewRedisEventBus(pool RedisPool, options ...RedisEventBusOption) pipeline.EventBus {
+	r := &RedisEventBus{
+		pool:              pool,
+		multiplexer:       newMultiplexer(pool),
+		visibilityTimeout: DefaultVisibilityTimeout,
+		redeliveryDelay:   DefaultRedeliveryDelay,
+	}
+
+	for i := range options {
+		options[i](r)
+	}
+
+	return r
+}
+
+// RedisEventBus delivers events at least once with reliable queue.
+//
+// Events are moved from list <topic> to list <topic>:processing:<consumer> when picked,
+// and removed when acked, or moved to sorted set <topic>:delayed scored by due time when nacked,
+// which moved back to <topic> by consumers once due.
+// Each consumer holds a lease <topic>:consumers:<consumer> refreshed until unsubscribed,
+// once a lease expired, events left in the processing list of the consumer will be moved back for redelivery.
+// Consumers wait for notifications over one pubsub connection shared in the bus, instead of blocking connections of pool.
+// Keys of topic expire once no consumers refreshing, and are cleaned up when the last consumer unsubscribed.
 type RedisEventBus struct {
-	pool RedisPool
+	pool              RedisPool
+	multiplexer       *multiplexer
+	visibilityTimeout time.Duration
+	redeliveryDelay   time.Duration
 }
 
 func (r *RedisEventBus) Publish(ctx context.Context, topic string, data []byte) error {
 	conn := r.pool.Get()
 	defer conn.Close()
 
-	ret, err := redis.Values(conn.Do("PUBSUB", "NUMSUB", subscription(topic)))
+	pushed, err := redis.Bool(pushScript.Do(conn, topic, consumersKey(topic), data))
 	if err != nil {
+		logrus.WithContext(ctx).Error(err)
 		return err
 	}
 
-	if ret[1].(int64) == 0 {
+	if !pushed {
 		return pipeline.ErrNoSubscriptionsForTopic
 	}
-
-	if _, err := conn.Do("RPUSH", topic, data); err != nil {
-		logrus.WithContext(ctx).Error(err)
-		return err
-	}
 	return nil
 }
 
-func (r *RedisEventBus) Subscribe(topic string, callback func(ctx context.Context, data []byte)) pipeline.Subscription {
+// push and notify consumers waiting, when any consumer holds the lease
+var pushScript = redis.NewScript(2, `
+for _, id in ipairs(redis.call("SMEMBERS", KEYS[2])) do
+	if redis.call("EXISTS", KEYS[2] .. ":" .. id) == 1 then
+		redis.call("LPUSH", KEYS[1], ARGV[1])
+		redis.call("PUBLISH", KEYS[1] .. ":subscription", "")
+		return 1
+	end
+end
+return 0
+`)
+
+func (r *RedisEventBus) Subscribe(topic string, callback pipeline.Handler) pipeline.Subscription {
 	chStop := make(chan interface{})
+	heartbeatStopped := make(chan struct{})
 
-	sub, err := subscribe(r.pool, topic)
-	if err != nil {
+	c := &consumer{
+		pool:              r.pool,
+		topic:             topic,
+		id:                consumerID(),
+		visibilityTimeout: r.visibilityTimeout,
+	}
+
+	if err := c.heartbeat(); err != nil {
 		panic(err)
 	}
 
 	go func() {
+		defer close(heartbeatStopped)
+
+		ticker := time.NewTicker(r.visibilityTimeout / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-chStop:
+				return
+			case <-ticker.C:
+				if err := c.heartbeat(); err != nil {
+					logrus.Error(err)
+				}
+			}
+		}
+	}()
+
+	go func() {
+		b := &redisutil.Backoff{Min: minBackoff, Max: maxBackoff}
+
+		// nil until waiting for notifications, redialed with backoff
+		var notified chan struct{}
+		defer func() {
+			if notified != nil {
+				r.multiplexer.stopWaiting(topic, notified)
+			}
+		}()
+
 		for {
 			select {
 			case <-chStop:
 				return
 			default:
-				data, err := r.pick(topic)
-				if err != nil {
-					if err != redis.ErrNil {
-						logrus.Error(err)
-						// waiting for connection recovered
-						time.Sleep(500 * time.Millisecond)
-
-						s, err := subscribe(r.pool, topic)
-						if err != nil {
-							logrus.Error(err)
-						}
-						sub = s
-					}
-					continue
+			}
+
+			var err error
+
+			if notified == nil {
+				notified, err = r.multiplexer.wait(topic)
+			}
+
+			var nextDue time.Duration
+			var data []byte
+
+			if err == nil {
+				nextDue, err = c.promote()
+			}
+
+			if err == nil {
+				data, err = c.pick()
+			}
+
+			if err != nil {
+				logrus.Error(err)
+
+				// waiting for connection recovered
+				select {
+				case <-chStop:
+					return
+				case <-time.After(b.Next()):
 				}
+				continue
+			}
+
+			b.Reset()
+
+			if data == nil {
+				wait := pollInterval
+				if nextDue >= 0 && nextDue < wait {
+					wait = nextDue
+				}
+
+				// blocked until events pushed or delayed events due
+				timer := time.NewTimer(wait)
 
-				if data != nil {
-					callback(context.Background(), data)
+				select {
+				case <-chStop:
+					timer.Stop()
+					return
+				case <-notified:
+				case <-timer.C:
 				}
+
+				timer.Stop()
+				continue
+			}
+
+			select {
+			case <-chStop:
+				// picked after unsubscribed, redelivered to others at once
+				if err := c.nack(data, 0); err != nil {
+					logrus.Error(err)
+				}
+				return
+			default:
+			}
+
+			if err := callback(context.Background(), data); err != nil {
+				logrus.Warnf("nack event of %s: %s", topic, err)
+
+				if err := c.nack(data, pipeline.NackDelay(err, r.redeliveryDelay)); err != nil {
+					logrus.Error(err)
+				}
+				continue
+			}
+
+			if err := c.ack(data); err != nil {
+				logrus.Error(err)
 			}
 		}
 	}()
 
 	return pipeline.NewSubscription(func() {
-		sub.Unsubscribe()
 		close(chStop)
+		// lease not refreshed after left
+		<-heartbeatStopped
+		if err := c.leave(); err != nil {
+			logrus.Error(err)
+		}
 	})
 }
 
-func (r *RedisEventBus) pick(topic string) ([]byte, error) {
+// join parts are kept until all collected, or dropped after joinTTL
+var joinTTL = 24 * time.Hour
+
+var joinScript = redis.NewScript(1, `
+redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
+redis.call("EXPIRE", KEYS[1], ARGV[4])
+if redis.call("HLEN", KEYS[1]) < tonumber(ARGV[3]) then
+	return false
+end
+local parts = redis.call("HGETALL", KEYS[1])
+redis.call("DEL", KEYS[1])
+return parts
+`)
+
+func (r *RedisEventBus) Join(ctx context.Context, key string, part string, data []byte, n int) (map[string][]byte, error) {
 	conn := r.pool.Get()
 	defer conn.Close()
 
-	return redis.Bytes(conn.Do("LPOP", topic))
+	values, err := redis.Values(joinScript.Do(conn, key, part, data, n, int64(joinTTL/time.Second)))
+	if err != nil {
+		if err == redis.ErrNil {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	parts := map[string][]byte{}
+
+	for i := 0; i+1 < len(values); i += 2 {
+		name, err := redis.String(values[i], nil)
+		if err != nil {
+			return nil, err
+		}
+		parts[name], err = redis.Bytes(values[i+1], nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return parts, nil
 }
 
-func subscribe(pool RedisPool, topic string) (pipeline.Subscription, error) {
-	psc := redis.PubSubConn{Conn: pool.Get()}
-	channel := subscription(topic)
-	chClose := make(chan struct{})
+// QueueDepth returns count of events not picked by consumers, including the ones delayed for redelivery.
+func (r *RedisEventBus) QueueDepth(ctx context.Context, topic string) (int, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
 
-	if err := psc.Subscribe(channel); err != nil {
-		return nil, err
+	queued, err := redis.Int(conn.Do("LLEN", topic))
+	if err != nil {
+		return 0, err
 	}
 
-	go func() {
-		ticker := time.NewTicker(5 * time.Second)
+	delayed, err := redis.Int(conn.Do("ZCARD", delayedKey(topic)))
+	if err != nil {
+		return 0, err
+	}
 
-		for {
-			select {
-			case <-ticker.C:
-				if err := psc.Ping(""); err != nil {
-					ticker.Stop()
-					return
-				}
+	return queued + delayed, nil
+}
 
-				catch(expire(pool, topic))
+type consumer struct {
+	pool              RedisPool
+	topic             string
+	id                string
+	visibilityTimeout time.Duration
+}
 
-			case <-chClose:
-				ticker.Stop()
-				return
-			}
-		}
-	}()
+// pick returns nil when no events
+func (c *consumer) pick() ([]byte, error) {
+	conn := c.pool.Get()
+	defer conn.Close()
 
-	logrus.Debugf("subscribed %s", topic)
+	data, err := redis.Bytes(conn.Do("RPOPLPUSH", c.topic, processing(c.topic, c.id)))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	return data, err
+}
 
-	return pipeline.NewSubscription(func() {
-		chClose <- struct{}{}
-		catch(psc.Close())
-	}), nil
+var ackScript = redis.NewScript(1, `
+return redis.call("LREM", KEYS[1], -1, ARGV[1])
+`)
+
+func (c *consumer) ack(data []byte) error {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	_, err := ackScript.Do(conn, processing(c.topic, c.id), data)
+	return err
 }
 
-func subscription(topic string) string {
-	return strings.Join([]string{topic, "subscription"}, ":")
+// delayed members prefixed with sequence, so same data nacked twice not merged
+var nackScript = redis.NewScript(4, `
+if redis.call("LREM", KEYS[1], -1, ARGV[1]) > 0 then
+	if tonumber(ARGV[2]) <= 0 then
+		redis.call("LPUSH", KEYS[2], ARGV[1])
+		redis.call("PUBLISH", KEYS[2] .. ":subscription", "")
+	else
+		local seq = redis.call("INCR", KEYS[4])
+		redis.call("ZADD", KEYS[3], ARGV[3], seq .. ":" .. ARGV[1])
+	end
+end
+return true
+`)
+
+// nack moves the event to delayed, redelivered after delay
+func (c *consumer) nack(data []byte, delay time.Duration) error {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	dueAt := time.Now().Add(delay).UnixNano() / int64(time.Millisecond)
+
+	_, err := nackScript.Do(conn, processing(c.topic, c.id), c.topic, delayedKey(c.topic), delayedSeqKey(c.topic), data, int64(delay/time.Millisecond), dueAt)
+	return err
+}
+
+// promote moves delayed events due back to topic, returns ms until the next due, or -1 when none
+var promoteScript = redis.NewScript(2, `
+local due = redis.call("ZRANGEBYSCORE", KEYS[2], "-inf", ARGV[1], "LIMIT", 0, 100)
+for _, member in ipairs(due) do
+	redis.call("ZREM", KEYS[2], member)
+	local i = string.find(member, ":", 1, true)
+	redis.call("LPUSH", KEYS[1], string.sub(member, i + 1))
+end
+if #due > 0 then
+	redis.call("PUBLISH", KEYS[1] .. ":subscription", "")
+end
+local next = redis.call("ZRANGE", KEYS[2], 0, 0, "WITHSCORES")
+if #next == 0 then
+	return -1
+end
+return math.max(tonumber(next[2]) - tonumber(ARGV[1]), 0)
+`)
+
+// promote returns duration until the next delayed event due, or negative when none
+func (c *consumer) promote() (time.Duration, error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	ms, err := redis.Int64(promoteScript.Do(conn, c.topic, delayedKey(c.topic), time.Now().UnixNano()/int64(time.Millisecond)))
+	if err != nil {
+		return 0, err
+	}
+	if ms < 0 {
+		return -1, nil
+	}
+	return time.Duration(ms) * time.Millisecond, nil
 }
 
-func expire(pool RedisPool, topic string) error {
-	conn := pool.Get()
+// requeue moves events picked by consumers without lease back to topic
+var requeueScript = redis.NewScript(1, `
+local n = 0
+for _, id in ipairs(redis.call("SMEMBERS", KEYS[1])) do
+	if redis.call("EXISTS", KEYS[1] .. ":" .. id) == 0 then
+		local key = ARGV[1] .. ":processing:" .. id
+		while redis.call("RPOPLPUSH", key, ARGV[1]) do
+			n = n + 1
+		end
+		redis.call("SREM", KEYS[1], id)
+	end
+end
+if n > 0 then
+	redis.call("PUBLISH", ARGV[1] .. ":subscription", "")
+end
+return n
+`)
+
+// heartbeat refreshes lease of consumer and expiration of topic, and requeues events of dead consumers
+func (c *consumer) heartbeat() error {
+	conn := c.pool.Get()
 	defer conn.Close()
 
-	_, err := conn.Do("EXPIRE", topic, 10)
+	consumers := consumersKey(c.topic)
+
+	if _, err := conn.Do("SET", consumers+":"+c.id, 1, "PX", int64(c.visibilityTimeout/time.Millisecond)); err != nil {
+		return err
+	}
+
+	if _, err := conn.Do("SADD", consumers, c.id); err != nil {
+		return err
+	}
+
+	n, err := redis.Int(requeueScript.Do(conn, consumers, c.topic))
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		logrus.Warnf("%d events of %s requeued from dead consumers", n, c.topic)
+	}
+
+	// events left and keys of topic expire once no consumers refreshing
+	for _, key := range []string{c.topic, delayedKey(c.topic), delayedSeqKey(c.topic), consumers, processing(c.topic, c.id)} {
+		if _, err := conn.Do("PEXPIRE", key, int64(topicTTL(c.visibilityTimeout)/time.Millisecond)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// leave moves events still in processing back to topic, and drops the lease,
+// keys of topic not needed by others deleted when the last consumer left.
+var leaveScript = redis.NewScript(6, `
+redis.call("DEL", KEYS[2])
+local n = 0
+while redis.call("RPOPLPUSH", KEYS[3], KEYS[4]) do
+	n = n + 1
+end
+if n > 0 then
+	redis.call("PUBLISH", KEYS[4] .. ":subscription", "")
+end
+redis.call("SREM", KEYS[1], ARGV[1])
+if redis.call("SCARD", KEYS[1]) == 0 and redis.call("ZCARD", KEYS[5]) == 0 then
+	redis.call("DEL", KEYS[6])
+end
+return n
+`)
+
+// leave drops the lease, events still in processing are redelivered to others.
+func (c *consumer) leave() error {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	consumers := consumersKey(c.topic)
+
+	_, err := leaveScript.Do(conn, consumers, consumers+":"+c.id, processing(c.topic, c.id), c.topic, delayedKey(c.topic), delayedSeqKey(c.topic), c.id)
 	return err
 }
 
+func topicTTL(visibilityTimeout time.Duration) time.Duration {
+	if ttl := 2 * visibilityTimeout; ttl > minTopicTTL {
+		return ttl
+	}
+	return minTopicTTL
+}
+
+func consumerID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func processing(topic string, consumerID string) string {
+	return strings.Join([]string{topic, "processing", consumerID}, ":")
+}
+
+func consumersKey(topic string) string {
+	return strings.Join([]string{topic, "consumers"}, ":")
+}
+
+func delayedKey(topic string) string {
+	return strings.Join([]string{topic, "delayed"}, ":")
+}
+
+func delayedSeqKey(topic string) string {
+	return strings.Join([]string{topic, "delayed", "seq"}, ":")
+}
+
+func subscription(topic string) string {
+	return strings.Join([]string{topic, "subscription"}, ":")
+}
+
+func topicOfSubscription(channel string) string {
+	return strings.TrimSuffix(channel, ":subscription")
+}
+
 func catch(err error) {
 
 }
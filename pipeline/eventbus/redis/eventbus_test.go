@@ -2,11 +2,15 @@ package redis_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	. "github.com/onsi/gomega"
+	"github.com/querycap/pipeline/pipeline"
 	"github.com/querycap/pipeline/pipeline/eventbus/redis"
 	"github.com/querycap/pipeline/pkg/redisutil"
 	"github.com/sirupsen/logrus"
@@ -21,9 +25,10 @@ func init() {
 func TestRedisEventBus(t *testing.T) {
 	s := redis.NewRedisEventBus(pool)
 
-	sub := s.Subscribe("test", func(ctx context.Context, data []byte) {
+	sub := s.Subscribe("test", func(ctx context.Context, data []byte) error {
 		time.Sleep(80 * time.Millisecond)
 		fmt.Println(string(data))
+		return nil
 	})
 
 	for i := 0; i < 10; i++ {
@@ -34,6 +39,187 @@ func TestRedisEventBus(t *testing.T) {
 	sub.Unsubscribe()
 }
 
+func TestRedisEventBusNoSubscriptions(t *testing.T) {
+	s := redis.NewRedisEventBus(pool)
+
+	topic := fmt.Sprintf("test:%d", time.Now().UnixNano())
+
+	NewWithT(t).Expect(s.Publish(context.Background(), topic, []byte("0"))).To(Equal(pipeline.ErrNoSubscriptionsForTopic))
+
+	sub := s.Subscribe(topic, func(ctx context.Context, data []byte) error {
+		return nil
+	})
+
+	NewWithT(t).Expect(s.Publish(context.Background(), topic, []byte("1"))).To(BeNil())
+
+	sub.Unsubscribe()
+
+	NewWithT(t).Expect(s.Publish(context.Background(), topic, []byte("2"))).To(Equal(pipeline.ErrNoSubscriptionsForTopic))
+}
+
+func TestRedisEventBusJoin(t *testing.T) {
+	s := redis.NewRedisEventBus(pool)
+
+	key := fmt.Sprintf("join:%d", time.Now().UnixNano())
+
+	parts, err := s.Join(context.Background(), key, "a", []byte("a"), 2)
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(parts).To(BeNil())
+
+	parts, err = s.Join(context.Background(), key, "a", []byte("a"), 2)
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(parts).To(BeNil())
+
+	parts, err = s.Join(context.Background(), key, "b", []byte("b"), 2)
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(parts).To(Equal(map[string][]byte{"a": []byte("a"), "b": []byte("b")}))
+}
+
+func TestRedisEventBusRedelivery(t *testing.T) {
+	topic := fmt.Sprintf("test:%d", time.Now().UnixNano())
+
+	t.Run("nack", func(t *testing.T) {
+		s := redis.NewRedisEventBus(pool)
+
+		attempts := int32(0)
+
+		sub := s.Subscribe(topic, func(ctx context.Context, data []byte) error {
+			if atomic.AddInt32(&attempts, 1) < 3 {
+				return errors.New("nack")
+			}
+			return nil
+		})
+		defer sub.Unsubscribe()
+
+		NewWithT(t).Expect(s.Publish(context.Background(), topic, []byte("1"))).To(BeNil())
+
+		NewWithT(t).Eventually(func() int32 {
+			return atomic.LoadInt32(&attempts)
+		}).Should(Equal(int32(3)))
+	})
+
+	t.Run("nack with delay", func(t *testing.T) {
+		s := redis.NewRedisEventBus(pool)
+
+		handledAt := make(chan time.Time, 2)
+		attempts := int32(0)
+
+		sub := s.Subscribe(topic, func(ctx context.Context, data []byte) error {
+			handledAt <- time.Now()
+			if atomic.AddInt32(&attempts, 1) == 1 {
+				return pipeline.NackWithDelay(errors.New("not due"), 300*time.Millisecond)
+			}
+			return nil
+		})
+		defer sub.Unsubscribe()
+
+		NewWithT(t).Expect(s.Publish(context.Background(), topic, []byte("1"))).To(BeNil())
+
+		first := <-handledAt
+
+		// delayed counted
+		NewWithT(t).Eventually(func() int {
+			n, _ := s.QueueDepth(context.Background(), topic)
+			return n
+		}).Should(Equal(1))
+
+		second := <-handledAt
+		NewWithT(t).Expect(second.Sub(first) >= 300*time.Millisecond).To(BeTrue())
+	})
+
+	t.Run("dead consumer", func(t *testing.T) {
+		s := redis.NewRedisEventBus(pool, redis.WithVisibilityTimeout(300*time.Millisecond))
+
+		picked := make(chan struct{})
+		blocked := make(chan struct{})
+
+		dead := s.Subscribe(topic, func(ctx context.Context, data []byte) error {
+			close(picked)
+			// never acked
+			<-blocked
+			return nil
+		})
+
+		NewWithT(t).Expect(s.Publish(context.Background(), topic, []byte("1"))).To(BeNil())
+		<-picked
+		dead.Unsubscribe()
+
+		redelivered := make(chan []byte, 1)
+
+		sub := s.Subscribe(topic, func(ctx context.Context, data []byte) error {
+			redelivered <- data
+			return nil
+		})
+		defer sub.Unsubscribe()
+
+		NewWithT(t).Eventually(redelivered, 2*time.Second).Should(Receive(Equal([]byte("1"))))
+		close(blocked)
+	})
+}
+
+func TestRedisEventBusIdle(t *testing.T) {
+	// consumers more than connections of pool, since waiting with the shared pubsub connection
+	pool, err := redisutil.NewPool("tcp://127.0.0.1:6379?maxActive=4")
+	NewWithT(t).Expect(err).To(BeNil())
+
+	s := redis.NewRedisEventBus(pool)
+
+	received := make(chan string, 20)
+
+	for i := 0; i < 20; i++ {
+		topic := fmt.Sprintf("test:idle:%d", i)
+
+		sub := s.Subscribe(topic, func(ctx context.Context, data []byte) error {
+			received <- string(data)
+			return nil
+		})
+		defer sub.Unsubscribe()
+	}
+
+	time.Sleep(500 * time.Millisecond)
+
+	for i := 0; i < 20; i++ {
+		NewWithT(t).Expect(s.Publish(context.Background(), fmt.Sprintf("test:idle:%d", i), []byte(strconv.Itoa(i)))).To(BeNil())
+	}
+
+	// picked once notified, not after poll interval
+	for i := 0; i < 20; i++ {
+		NewWithT(t).Eventually(received, 500*time.Millisecond).Should(Receive())
+	}
+}
+
+func TestRedisEventBusCleanup(t *testing.T) {
+	s := redis.NewRedisEventBus(pool)
+
+	topic := fmt.Sprintf("test:%d", time.Now().UnixNano())
+
+	attempts := int32(0)
+
+	sub := s.Subscribe(topic, func(ctx context.Context, data []byte) error {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			return errors.New("nack")
+		}
+		return nil
+	})
+
+	NewWithT(t).Expect(s.Publish(context.Background(), topic, []byte("1"))).To(BeNil())
+
+	NewWithT(t).Eventually(func() int32 {
+		return atomic.LoadInt32(&attempts)
+	}).Should(Equal(int32(2)))
+
+	sub.Unsubscribe()
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	for _, key := range []string{topic, topic + ":consumers", topic + ":delayed", topic + ":delayed:seq"} {
+		exists, err := conn.Do("EXISTS", key)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(exists).To(Equal(int64(0)), key)
+	}
+}
+
 func catch(err error) {
 
 }
@@ -0,0 +1,216 @@
+package redis
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/sirupsen/logrus"
+)
+
+func newMultiplexer(pool RedisPool) *multiplexer {
+	return &multiplexer{
+		pool:    pool,
+		waiters: map[string]map[chan struct{}]bool{},
+	}
+}
+
+// multiplexer shares one pubsub connection for all subscriptions of the bus.
+//
+// Channel <topic>:subscription marks the topic has subscribers,
+// and notifies waiters of topic when events pushed.
+// The connection is redialed and channels are resubscribed when dropped.
+type multiplexer struct {
+	pool RedisPool
+
+	rw      sync.Mutex
+	psc     *redis.PubSubConn
+	waiters map[string]map[chan struct{}]bool
+}
+
+// wait returns a chan notified when events pushed to topic, or when the connection recovered.
+func (m *multiplexer) wait(topic string) (chan struct{}, error) {
+	m.rw.Lock()
+	defer m.rw.Unlock()
+
+	if m.psc == nil {
+		psc, err := m.dial()
+		if err != nil {
+			return nil, err
+		}
+		m.psc = psc
+		go m.serve(psc)
+	}
+
+	if len(m.waiters[topic]) == 0 {
+		if err := m.psc.Subscribe(subscription(topic)); err != nil {
+			return nil, err
+		}
+		m.waiters[topic] = map[chan struct{}]bool{}
+		logrus.Debugf("subscribed %s", topic)
+	}
+
+	ch := make(chan struct{}, 1)
+	m.waiters[topic][ch] = true
+
+	return ch, nil
+}
+
+func (m *multiplexer) stopWaiting(topic string, ch chan struct{}) {
+	m.rw.Lock()
+	defer m.rw.Unlock()
+
+	waiters, ok := m.waiters[topic]
+	if !ok {
+		return
+	}
+
+	delete(waiters, ch)
+
+	if len(waiters) > 0 {
+		return
+	}
+
+	delete(m.waiters, topic)
+
+	if m.psc == nil {
+		return
+	}
+
+	catch(m.psc.Unsubscribe(subscription(topic)))
+
+	if len(m.waiters) == 0 {
+		// closed in serve when all unsubscribed,
+		// since closing cleans up pubsub state by receiving, which conflicts with serve.
+		m.psc = nil
+	}
+}
+
+// dial connects and subscribes channels of all waiting topics
+func (m *multiplexer) dial() (*redis.PubSubConn, error) {
+	psc := &redis.PubSubConn{Conn: m.pool.Get()}
+
+	channels := make([]interface{}, 0, len(m.waiters))
+	for topic := range m.waiters {
+		channels = append(channels, subscription(topic))
+	}
+
+	if len(channels) > 0 {
+		if err := psc.Subscribe(channels...); err != nil {
+			catch(psc.Close())
+			return nil, err
+		}
+	}
+
+	return psc, nil
+}
+
+func (m *multiplexer) serve(psc *redis.PubSubConn) {
+	chClose := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				// kept alive within read timeout
+				if err := m.ping(psc); err != nil {
+					return
+				}
+			case <-chClose:
+				return
+			}
+		}
+	}()
+
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Message:
+			m.notify(topicOfSubscription(v.Channel))
+		case redis.Subscription:
+			if v.Count == 0 && m.dropped(psc) {
+				close(chClose)
+				catch(psc.Close())
+				return
+			}
+		case error:
+			close(chClose)
+			m.reconnect(psc, v)
+			return
+		}
+	}
+}
+
+// reconnect redials until succeed, unless no topics waiting any more.
+func (m *multiplexer) reconnect(dropped *redis.PubSubConn, err error) {
+	for {
+		m.rw.Lock()
+
+		if m.psc != dropped {
+			// closed when all stopped waiting
+			m.rw.Unlock()
+			return
+		}
+
+		logrus.Warnf("pubsub connection dropped: %s", err)
+
+		psc, e := m.dial()
+		if e == nil {
+			m.psc = psc
+			go m.serve(psc)
+			m.rw.Unlock()
+
+			// events pushed during reconnecting are not notified
+			for _, topic := range m.topics() {
+				m.notify(topic)
+			}
+			return
+		}
+
+		err = e
+		m.rw.Unlock()
+
+		// waiting for connection recovered
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+func (m *multiplexer) dropped(psc *redis.PubSubConn) bool {
+	m.rw.Lock()
+	defer m.rw.Unlock()
+
+	return m.psc != psc
+}
+
+// ping under lock, since only one concurrent caller of Send allowed
+func (m *multiplexer) ping(psc *redis.PubSubConn) error {
+	m.rw.Lock()
+	defer m.rw.Unlock()
+
+	return psc.Ping("")
+}
+
+func (m *multiplexer) notify(topic string) {
+	m.rw.Lock()
+	defer m.rw.Unlock()
+
+	for ch := range m.waiters[topic] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (m *multiplexer) topics() []string {
+	m.rw.Lock()
+	defer m.rw.Unlock()
+
+	topics := make([]string, 0, len(m.waiters))
+	for topic := range m.waiters {
+		topics = append(topics, topic)
+	}
+	return topics
+}
@@ -0,0 +1,558 @@
+package redisstream
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/querycap/pipeline/pipeline"
+	eventbusredis "github.com/querycap/pipeline/pipeline/eventbus/redis"
+	"github.com/querycap/pipeline/pkg/redisutil"
+	"github.com/sirupsen/logrus"
+)
+
+type RedisPool interface {
+	Get() redis.Conn
+}
+
+const (
+	// DefaultVisibilityTimeout is how long entries pending in a dead consumer
+	// stay invisible before claimed by others.
+	DefaultVisibilityTimeout = 30 * time.Second
+	// DefaultRedeliveryDelay is how long nacked entries wait before re-added,
+	// unless longer delay set by pipeline.NackWithDelay.
+	DefaultRedeliveryDelay = 100 * time.Millisecond
+)
+
+// block timeout of XREADGROUP, to check unsubscribed and claim pending entries
+var blockTimeout = time.Second
+
+// keys of topic expire once no consumers refreshing for twice visibility timeout, but not less than minTopicTTL
+var minTopicTTL = time.Minute
+
+// backoff of consumers when connection dropped, doubled from minBackoff up to maxBackoff until recovered.
+var (
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 10 * time.Second
+)
+
+type RedisStreamEventBusOption = func(r *RedisStreamEventBus)
+
+func WithVisibilityTimeout(visibilityTimeout time.Duration) RedisStreamEventBusOption {
+	return func(r *RedisStreamEventBus) {
+		r.visibilityTimeout = visibilityTimeout
+	}
+}
+
+func WithRedeliveryDelay(redeliveryDelay time.Duration) RedisStreamEventBusOption {
+	return func(r *RedisStreamEventBus) {
+		r.redeliveryDelay = redeliveryDelay
+	}
+}
+
+func NewRedisStreamEventBus(pool RedisPool, options ...RedisStreamEventBusOption) pipeline.EventBus {
+	r := &RedisStreamEventBus{
+		Joiner:            eventbusredis.NewRedisEventBus(pool),
+		pool:              pool,
+		visibilityTimeout: DefaultVisibilityTimeout,
+		redeliveryDelay:   DefaultRedeliveryDelay,
+	}
+
+	for i := range options {
+		options[i](r)
+	}
+
+	return r
+}
+
+// RedisStreamEventBus delivers events at least once with redis streams.
+//
+// Each topic is a stream with one consumer group named as the topic,
+// so replicas of a stage share events of the stage.
+// Streams are never trimmed, since entries not handled yet would be dropped,
+// instead entries are acked and deleted when handled, or deleted and kept in sorted set <topic>:delayed scored by due time when nacked,
+// which re-added by consumers once due.
+// Entries pending longer than the visibility timeout, like picked by dead consumers, are claimed by others.
+// Consumers alive are kept in sorted set <topic>:consumers scored by lease deadline.
+// Keys of topic expire once no consumers refreshing, and are deleted when the last consumer left with nothing to handle,
+// so streams of topics like results of tasks not kept.
+type RedisStreamEventBus struct {
+	// join parts are kept as the redis list bus
+	pipeline.Joiner
+	pool              RedisPool
+	visibilityTimeout time.Duration
+	redeliveryDelay   time.Duration
+}
+
+func (r *RedisStreamEventBus) Publish(ctx context.Context, topic string, data []byte) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	added, err := redis.Bool(addScript.Do(conn, topic, consumersKey(topic), now(), data))
+	if err != nil {
+		logrus.WithContext(ctx).Error(err)
+		return err
+	}
+
+	if !added {
+		return pipeline.ErrNoSubscriptionsForTopic
+	}
+	return nil
+}
+
+// add when any consumer holds the lease, checked in script, so not added to stream deleted by the last consumer left
+var addScript = redis.NewScript(2, `
+if redis.call("ZCOUNT", KEYS[2], ARGV[1], "+inf") == 0 then
+	return 0
+end
+redis.call("XADD", KEYS[1], "*", "data", ARGV[2])
+return 1
+`)
+
+func (r *RedisStreamEventBus) Subscribe(topic string, callback pipeline.Handler) pipeline.Subscription {
+	c := &consumer{
+		pool:              r.pool,
+		topic:             topic,
+		id:                consumerID(),
+		visibilityTimeout: r.visibilityTimeout,
+		redeliveryDelay:   r.redeliveryDelay,
+	}
+
+	if err := c.join(); err != nil {
+		panic(err)
+	}
+
+	chStop := make(chan interface{})
+	heartbeatStopped := make(chan struct{})
+
+	go func() {
+		defer close(heartbeatStopped)
+
+		ticker := time.NewTicker(r.visibilityTimeout / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-chStop:
+				return
+			case <-ticker.C:
+				if err := c.heartbeat(); err != nil {
+					logrus.Error(err)
+				}
+			}
+		}
+	}()
+
+	go func() {
+		claimedAt := time.Time{}
+		b := &redisutil.Backoff{Min: minBackoff, Max: maxBackoff}
+
+		for {
+			select {
+			case <-chStop:
+				return
+			default:
+				var entries []*entry
+				var err error
+
+				if time.Since(claimedAt) > r.visibilityTimeout/3 {
+					entries, err = c.claim()
+					claimedAt = time.Now()
+				}
+
+				if err == nil && len(entries) == 0 {
+					var nextDue time.Duration
+					nextDue, err = c.promote()
+
+					if err == nil {
+						block := blockTimeout
+						if nextDue >= 0 && nextDue < block {
+							// BLOCK 0 blocks forever
+							block = nextDue + time.Millisecond
+						}
+						entries, err = c.read(block)
+					}
+				}
+
+				if err != nil {
+					logrus.Error(err)
+
+					// waiting for connection recovered
+					select {
+					case <-chStop:
+						return
+					case <-time.After(b.Next()):
+					}
+					continue
+				}
+
+				b.Reset()
+
+				for _, e := range entries {
+					select {
+					case <-chStop:
+						// picked after unsubscribed, redelivered to others at once
+						if err := c.nack(e, 0); err != nil {
+							logrus.Error(err)
+						}
+					default:
+						c.handle(e, callback)
+					}
+				}
+			}
+		}
+	}()
+
+	return pipeline.NewSubscription(func() {
+		close(chStop)
+		// lease not refreshed after left
+		<-heartbeatStopped
+		if err := c.leave(); err != nil {
+			logrus.Error(err)
+		}
+	})
+}
+
+// QueueDepth returns count of entries in stream, including the ones delayed for redelivery.
+func (r *RedisStreamEventBus) QueueDepth(ctx context.Context, topic string) (int, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	queued, err := redis.Int(conn.Do("XLEN", topic))
+	if err != nil {
+		return 0, err
+	}
+
+	delayed, err := redis.Int(conn.Do("ZCARD", delayedKey(topic)))
+	if err != nil {
+		return 0, err
+	}
+
+	return queued + delayed, nil
+}
+
+type entry struct {
+	ID   string
+	Data []byte
+}
+
+type consumer struct {
+	pool              RedisPool
+	topic             string
+	id                string
+	visibilityTimeout time.Duration
+	redeliveryDelay   time.Duration
+
+	rw         sync.RWMutex
+	handlingID string
+}
+
+// join creates consumer group of topic if not exists, and takes the lease
+func (c *consumer) join() error {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("XGROUP", "CREATE", c.topic, c.topic, "0", "MKSTREAM"); err != nil {
+		if !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return err
+		}
+	}
+
+	return c.heartbeat()
+}
+
+// heartbeat refreshes lease of consumer, and keeps the entry in handling from claimed by others.
+func (c *consumer) heartbeat() error {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	consumers := consumersKey(c.topic)
+	t := now()
+
+	if _, err := conn.Do("ZADD", consumers, t+int64(c.visibilityTimeout/time.Millisecond), c.id); err != nil {
+		return err
+	}
+
+	if _, err := conn.Do("ZREMRANGEBYSCORE", consumers, "-inf", t); err != nil {
+		return err
+	}
+
+	if id := c.handling(); id != "" {
+		// idle of pending entry reset when claimed
+		if _, err := conn.Do("XCLAIM", c.topic, c.topic, c.id, 0, id, "JUSTID"); err != nil {
+			return err
+		}
+	}
+
+	// entries left and keys of topic expire once no consumers refreshing
+	for _, key := range []string{c.topic, consumers, delayedKey(c.topic), delayedSeqKey(c.topic)} {
+		if _, err := conn.Do("PEXPIRE", key, int64(topicTTL(c.visibilityTimeout)/time.Millisecond)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// leave drops the lease, and deletes stream with the group when no consumers alive and nothing left to handle,
+// otherwise drops the consumer from group once nothing pending, entries pending are claimed by others.
+var leaveScript = redis.NewScript(4, `
+redis.call("ZREM", KEYS[2], ARGV[1])
+if redis.call("ZCOUNT", KEYS[2], ARGV[2], "+inf") == 0 and redis.call("XLEN", KEYS[1]) == 0 and redis.call("ZCARD", KEYS[3]) == 0 then
+	redis.call("DEL", KEYS[1], KEYS[2], KEYS[4])
+	return 1
+end
+if #redis.call("XPENDING", KEYS[1], KEYS[1], "-", "+", 1, ARGV[1]) == 0 then
+	redis.call("XGROUP", "DELCONSUMER", KEYS[1], KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+func (c *consumer) leave() error {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	_, err := leaveScript.Do(conn, c.topic, consumersKey(c.topic), delayedKey(c.topic), delayedSeqKey(c.topic), c.id, now())
+	return err
+}
+
+func (c *consumer) read(block time.Duration) ([]*entry, error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	values, err := redis.Values(conn.Do(
+		"XREADGROUP", "GROUP", c.topic, c.id,
+		"COUNT", 1,
+		"BLOCK", int64(block/time.Millisecond),
+		"STREAMS", c.topic, ">",
+	))
+	if err != nil {
+		if err == redis.ErrNil {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	// [[topic, entries]]
+	for i := range values {
+		stream, err := redis.Values(values[i], nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(stream) == 2 {
+			return entries(stream[1])
+		}
+	}
+
+	return nil, nil
+}
+
+// claim takes entries pending longer than visibility timeout
+func (c *consumer) claim() ([]*entry, error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	values, err := redis.Values(conn.Do(
+		"XAUTOCLAIM", c.topic, c.topic, c.id,
+		int64(c.visibilityTimeout/time.Millisecond), "0-0",
+		"COUNT", 10,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	// [cursor, entries, deleted ids]
+	if len(values) < 2 {
+		return nil, nil
+	}
+
+	claimed, err := entries(values[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(claimed) > 0 {
+		logrus.Warnf("%d events of %s claimed from dead consumers", len(claimed), c.topic)
+	}
+
+	return claimed, nil
+}
+
+func (c *consumer) handle(e *entry, callback pipeline.Handler) {
+	c.rw.Lock()
+	c.handlingID = e.ID
+	c.rw.Unlock()
+
+	defer func() {
+		c.rw.Lock()
+		c.handlingID = ""
+		c.rw.Unlock()
+	}()
+
+	if err := callback(context.Background(), e.Data); err != nil {
+		logrus.Warnf("nack event of %s: %s", c.topic, err)
+
+		if err := c.nack(e, pipeline.NackDelay(err, c.redeliveryDelay)); err != nil {
+			logrus.Error(err)
+		}
+		return
+	}
+
+	if err := c.ack(e); err != nil {
+		logrus.Error(err)
+	}
+}
+
+func (c *consumer) handling() string {
+	c.rw.RLock()
+	defer c.rw.RUnlock()
+
+	return c.handlingID
+}
+
+var ackScript = redis.NewScript(1, `
+if redis.call("XACK", KEYS[1], KEYS[1], ARGV[1]) > 0 then
+	redis.call("XDEL", KEYS[1], ARGV[1])
+end
+return true
+`)
+
+func (c *consumer) ack(e *entry) error {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	_, err := ackScript.Do(conn, c.topic, e.ID)
+	return err
+}
+
+// delayed members prefixed with sequence, so same data nacked twice not merged
+var nackScript = redis.NewScript(3, `
+if redis.call("XACK", KEYS[1], KEYS[1], ARGV[1]) > 0 then
+	redis.call("XDEL", KEYS[1], ARGV[1])
+	if tonumber(ARGV[3]) <= 0 then
+		redis.call("XADD", KEYS[1], "*", "data", ARGV[2])
+	else
+		local seq = redis.call("INCR", KEYS[3])
+		redis.call("ZADD", KEYS[2], ARGV[4], seq .. ":" .. ARGV[2])
+	end
+end
+return true
+`)
+
+// nack re-adds the entry to the end of stream after delay, for redelivering after the others
+func (c *consumer) nack(e *entry, delay time.Duration) error {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	dueAt := time.Now().Add(delay).UnixNano() / int64(time.Millisecond)
+
+	_, err := nackScript.Do(conn, c.topic, delayedKey(c.topic), delayedSeqKey(c.topic), e.ID, e.Data, int64(delay/time.Millisecond), dueAt)
+	return err
+}
+
+// promote re-adds delayed entries due to stream, returns ms until the next due, or -1 when none
+var promoteScript = redis.NewScript(2, `
+local due = redis.call("ZRANGEBYSCORE", KEYS[2], "-inf", ARGV[1], "LIMIT", 0, 100)
+for _, member in ipairs(due) do
+	redis.call("ZREM", KEYS[2], member)
+	local i = string.find(member, ":", 1, true)
+	redis.call("XADD", KEYS[1], "*", "data", string.sub(member, i + 1))
+end
+local next = redis.call("ZRANGE", KEYS[2], 0, 0, "WITHSCORES")
+if #next == 0 then
+	return -1
+end
+return math.max(tonumber(next[2]) - tonumber(ARGV[1]), 0)
+`)
+
+// promote returns duration until the next delayed entry due, or negative when none
+func (c *consumer) promote() (time.Duration, error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	ms, err := redis.Int64(promoteScript.Do(conn, c.topic, delayedKey(c.topic), now()))
+	if err != nil {
+		return 0, err
+	}
+	if ms < 0 {
+		return -1, nil
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// entries parses [[id, [field, value, ...]], ...]
+func entries(reply interface{}) ([]*entry, error) {
+	values, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*entry, 0, len(values))
+
+	for i := range values {
+		v, err := redis.Values(values[i], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		// entry deleted but still pending, not expected since deleted after acked
+		if len(v) < 2 || v[1] == nil {
+			continue
+		}
+
+		id, err := redis.String(v[0], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		fields, err := redis.ByteSlices(v[1], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		e := &entry{ID: id}
+
+		for j := 0; j+1 < len(fields); j += 2 {
+			if string(fields[j]) == "data" {
+				e.Data = fields[j+1]
+			}
+		}
+
+		list = append(list, e)
+	}
+
+	return list, nil
+}
+
+func topicTTL(visibilityTimeout time.Duration) time.Duration {
+	if ttl := 2 * visibilityTimeout; ttl > minTopicTTL {
+		return ttl
+	}
+	return minTopicTTL
+}
+
+func consumerID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func delayedKey(topic string) string {
+	return strings.Join([]string{topic, "delayed"}, ":")
+}
+
+func delayedSeqKey(topic string) string {
+	return strings.Join([]string{topic, "delayed", "seq"}, ":")
+}
+
+func consumersKey(topic string) string {
+	return strings.Join([]string{topic, "consumers"}, ":")
+}
+
+func now() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
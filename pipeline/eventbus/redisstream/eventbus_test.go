@@ -0,0 +1,200 @@
+package redisstream_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/querycap/pipeline/pipeline"
+	"github.com/querycap/pipeline/pipeline/eventbus/redisstream"
+	"github.com/querycap/pipeline/pkg/redisutil"
+)
+
+var pool, _ = redisutil.NewPool("tcp://127.0.0.1:6379")
+
+func TestRedisStreamEventBus(t *testing.T) {
+	topic := fmt.Sprintf("test:%d", time.Now().UnixNano())
+
+	t.Run("no subscriptions", func(t *testing.T) {
+		s := redisstream.NewRedisStreamEventBus(pool)
+
+		err := s.Publish(context.Background(), topic, []byte("0"))
+		NewWithT(t).Expect(err).To(Equal(pipeline.ErrNoSubscriptionsForTopic))
+	})
+
+	t.Run("shared by consumers in group", func(t *testing.T) {
+		s := redisstream.NewRedisStreamEventBus(pool)
+
+		received := int32(0)
+
+		for i := 0; i < 2; i++ {
+			sub := s.Subscribe(topic, func(ctx context.Context, data []byte) error {
+				atomic.AddInt32(&received, 1)
+				return nil
+			})
+			defer sub.Unsubscribe()
+		}
+
+		for i := 0; i < 10; i++ {
+			NewWithT(t).Expect(s.Publish(context.Background(), topic, []byte(strconv.Itoa(i)))).To(BeNil())
+		}
+
+		NewWithT(t).Eventually(func() int32 {
+			return atomic.LoadInt32(&received)
+		}, 3*time.Second).Should(Equal(int32(10)))
+
+		NewWithT(t).Eventually(func() int {
+			n, _ := s.QueueDepth(context.Background(), topic)
+			return n
+		}).Should(Equal(0))
+	})
+
+	t.Run("backlog not trimmed", func(t *testing.T) {
+		s := redisstream.NewRedisStreamEventBus(pool)
+
+		// more than 10000, which the stream trimmed to before
+		n := 10010
+
+		published := make(chan struct{})
+		received := map[string]bool{}
+		mu := sync.Mutex{}
+
+		sub := s.Subscribe(topic, func(ctx context.Context, data []byte) error {
+			<-published
+			mu.Lock()
+			received[string(data)] = true
+			mu.Unlock()
+			return nil
+		})
+		defer sub.Unsubscribe()
+
+		for i := 0; i < n; i++ {
+			NewWithT(t).Expect(s.Publish(context.Background(), topic, []byte(strconv.Itoa(i)))).To(BeNil())
+		}
+		close(published)
+
+		NewWithT(t).Eventually(func() int {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(received)
+		}, 30*time.Second).Should(Equal(n))
+	})
+
+	t.Run("nack", func(t *testing.T) {
+		s := redisstream.NewRedisStreamEventBus(pool)
+
+		attempts := int32(0)
+
+		sub := s.Subscribe(topic, func(ctx context.Context, data []byte) error {
+			if atomic.AddInt32(&attempts, 1) < 3 {
+				return errors.New("nack")
+			}
+			return nil
+		})
+		defer sub.Unsubscribe()
+
+		NewWithT(t).Expect(s.Publish(context.Background(), topic, []byte("1"))).To(BeNil())
+
+		NewWithT(t).Eventually(func() int32 {
+			return atomic.LoadInt32(&attempts)
+		}, 3*time.Second).Should(Equal(int32(3)))
+	})
+
+	t.Run("nack with delay", func(t *testing.T) {
+		s := redisstream.NewRedisStreamEventBus(pool)
+
+		handledAt := make(chan time.Time, 2)
+		attempts := int32(0)
+
+		sub := s.Subscribe(topic, func(ctx context.Context, data []byte) error {
+			handledAt <- time.Now()
+			if atomic.AddInt32(&attempts, 1) == 1 {
+				return pipeline.NackWithDelay(errors.New("not due"), 300*time.Millisecond)
+			}
+			return nil
+		})
+		defer sub.Unsubscribe()
+
+		NewWithT(t).Expect(s.Publish(context.Background(), topic, []byte("1"))).To(BeNil())
+
+		first := <-handledAt
+
+		// delayed counted
+		NewWithT(t).Eventually(func() int {
+			n, _ := s.QueueDepth(context.Background(), topic)
+			return n
+		}).Should(Equal(1))
+
+		second := <-handledAt
+		NewWithT(t).Expect(second.Sub(first) >= 300*time.Millisecond).To(BeTrue())
+	})
+
+	t.Run("claimed from dead consumer", func(t *testing.T) {
+		s := redisstream.NewRedisStreamEventBus(pool, redisstream.WithVisibilityTimeout(300*time.Millisecond))
+
+		picked := make(chan struct{})
+		blocked := make(chan struct{})
+
+		dead := s.Subscribe(topic, func(ctx context.Context, data []byte) error {
+			close(picked)
+			// never acked
+			<-blocked
+			return nil
+		})
+
+		NewWithT(t).Expect(s.Publish(context.Background(), topic, []byte("1"))).To(BeNil())
+		<-picked
+		dead.Unsubscribe()
+
+		redelivered := make(chan []byte, 1)
+
+		sub := s.Subscribe(topic, func(ctx context.Context, data []byte) error {
+			redelivered <- data
+			return nil
+		})
+		defer sub.Unsubscribe()
+
+		NewWithT(t).Eventually(redelivered, 3*time.Second).Should(Receive(Equal([]byte("1"))))
+		close(blocked)
+	})
+}
+
+func TestRedisStreamEventBusCleanup(t *testing.T) {
+	s := redisstream.NewRedisStreamEventBus(pool)
+
+	topic := fmt.Sprintf("test:%d", time.Now().UnixNano())
+
+	attempts := int32(0)
+
+	sub := s.Subscribe(topic, func(ctx context.Context, data []byte) error {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			return errors.New("nack")
+		}
+		return nil
+	})
+
+	NewWithT(t).Expect(s.Publish(context.Background(), topic, []byte("1"))).To(BeNil())
+
+	NewWithT(t).Eventually(func() int32 {
+		return atomic.LoadInt32(&attempts)
+	}).Should(Equal(int32(2)))
+
+	sub.Unsubscribe()
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	for _, key := range []string{topic, topic + ":consumers", topic + ":delayed", topic + ":delayed:seq"} {
+		exists, err := conn.Do("EXISTS", key)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(exists).To(Equal(int64(0)), key)
+	}
+
+	NewWithT(t).Expect(s.Publish(context.Background(), topic, []byte("2"))).To(Equal(pipeline.ErrNoSubscriptionsForTopic))
+}
@@ -0,0 +1,33 @@
+package redis
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"github.com/querycap/pipeline/pipeline"
+)
+
+type RedisPool interface {
+	Get() redis.Conn
+}
+
+// DefaultKey of the counter
+const DefaultKey = "pipeline:id"
+
+// NewRedisIDGen creates IDGen on counter of key, shared by all generators on the same redis.
+func NewRedisIDGen(pool RedisPool, key string) pipeline.IDGen {
+	if key == "" {
+		key = DefaultKey
+	}
+	return &RedisIDGen{pool: pool, key: key}
+}
+
+type RedisIDGen struct {
+	pool RedisPool
+	key  string
+}
+
+func (g *RedisIDGen) ID() (uint64, error) {
+	conn := g.pool.Get()
+	defer conn.Close()
+
+	return redis.Uint64(conn.Do("INCR", g.key))
+}
@@ -0,0 +1,27 @@
+package redis_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/querycap/pipeline/pipeline/idgen/redis"
+	"github.com/querycap/pipeline/pkg/redisutil"
+)
+
+var pool, _ = redisutil.NewPool("tcp://127.0.0.1:6379")
+
+func TestRedisIDGen(t *testing.T) {
+	key := fmt.Sprintf("test:id:%d", time.Now().UnixNano())
+
+	g1, g2 := redis.NewRedisIDGen(pool, key), redis.NewRedisIDGen(pool, key)
+
+	id, err := g1.ID()
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(id).To(Equal(uint64(1)))
+
+	id, err = g2.ID()
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(id).To(Equal(uint64(2)))
+}
@@ -0,0 +1,80 @@
+package snowflake
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/querycap/pipeline/pipeline"
+)
+
+const (
+	workerIDBits = 10
+	sequenceBits = 12
+
+	MaxWorkerID = 1<<workerIDBits - 1
+	maxSequence = 1<<sequenceBits - 1
+)
+
+// Epoch of timestamps in ids
+var Epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// NewSnowflakeIDGen creates IDGen without coordination,
+// ids are composed of milliseconds since Epoch, workerID and sequence in the millisecond,
+// so workerID should be unique among all generators.
+func NewSnowflakeIDGen(workerID uint32) (pipeline.IDGen, error) {
+	if workerID > MaxWorkerID {
+		return nil, fmt.Errorf("worker id should be less than %d, but got %d", MaxWorkerID+1, workerID)
+	}
+	return &SnowflakeIDGen{workerID: uint64(workerID)}, nil
+}
+
+type SnowflakeIDGen struct {
+	workerID uint64
+
+	mu       sync.Mutex
+	lastTime uint64
+	sequence uint64
+}
+
+func (g *SnowflakeIDGen) ID() (uint64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := millisSinceEpoch()
+
+	// clock moved backwards
+	if now < g.lastTime {
+		if g.lastTime-now > 1000 {
+			return 0, fmt.Errorf("clock moved backwards %dms", g.lastTime-now)
+		}
+		now = g.waitUntil(g.lastTime)
+	}
+
+	if now == g.lastTime {
+		g.sequence = (g.sequence + 1) & maxSequence
+		// sequence exhausted in the millisecond
+		if g.sequence == 0 {
+			now = g.waitUntil(g.lastTime + 1)
+		}
+	} else {
+		g.sequence = 0
+	}
+
+	g.lastTime = now
+
+	return now<<(workerIDBits+sequenceBits) | g.workerID<<sequenceBits | g.sequence, nil
+}
+
+func (g *SnowflakeIDGen) waitUntil(t uint64) uint64 {
+	now := millisSinceEpoch()
+	for now < t {
+		time.Sleep(time.Duration(t-now) * time.Millisecond)
+		now = millisSinceEpoch()
+	}
+	return now
+}
+
+func millisSinceEpoch() uint64 {
+	return uint64(time.Since(Epoch) / time.Millisecond)
+}
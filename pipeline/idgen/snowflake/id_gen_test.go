@@ -0,0 +1,42 @@
+package snowflake
+
+import (
+	"sync"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestSnowflakeIDGen(t *testing.T) {
+	_, err := NewSnowflakeIDGen(MaxWorkerID + 1)
+	NewWithT(t).Expect(err).NotTo(BeNil())
+
+	g1, _ := NewSnowflakeIDGen(1)
+	g2, _ := NewSnowflakeIDGen(2)
+
+	ids := sync.Map{}
+	wg := sync.WaitGroup{}
+
+	for _, g := range []interface{ ID() (uint64, error) }{g1, g2} {
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(g interface{ ID() (uint64, error) }) {
+				defer wg.Done()
+
+				last := uint64(0)
+				for i := 0; i < 5000; i++ {
+					id, err := g.ID()
+					NewWithT(t).Expect(err).To(BeNil())
+
+					_, loaded := ids.LoadOrStore(id, true)
+					NewWithT(t).Expect(loaded).To(BeFalse())
+
+					NewWithT(t).Expect(id > last).To(BeTrue())
+					last = id
+				}
+			}(g)
+		}
+	}
+
+	wg.Wait()
+}
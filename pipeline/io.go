@@ -1,7 +1,9 @@
 package pipeline
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"io"
 	"time"
 
@@ -68,6 +70,22 @@ func ReadNext(r Receiver, readFrom ReadFrom) error {
 	return nil
 }
 
+// ReadNextJSON decodes next input as json into v
+func ReadNextJSON(r Receiver, v interface{}) error {
+	return ReadNext(r, func(r io.Reader) error {
+		return json.NewDecoder(r).Decode(v)
+	})
+}
+
+// PutJSON puts v encoded as json with content type application/json
+func PutJSON(s Sender, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.Put(WithContentType("application/json")(bytes.NewBuffer(data)))
+}
+
 func SendByReader(s Sender, input io.Reader) error {
 	if err := s.Put(AsWriterTo(input)); err != nil {
 		return err
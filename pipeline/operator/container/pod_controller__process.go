@@ -0,0 +1,389 @@
+//go:build !windows
+// +build !windows
+
+package container
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultGracePeriod = 10 * time.Second
+
+	minRestartDelay = 100 * time.Millisecond
+	maxRestartDelay = 10 * time.Second
+	// restart delay reset when replica ran longer than it
+	stableDuration = 10 * time.Second
+)
+
+type ProcessPodControllerOption = func(c *ProcessPodController)
+
+// WithOutput to capture stdout and stderr of replicas, lines prefixed with name of replica
+func WithOutput(stdout io.Writer, stderr io.Writer) ProcessPodControllerOption {
+	return func(c *ProcessPodController) {
+		c.stdout, c.stderr = stdout, stderr
+	}
+}
+
+// WithGracePeriod to wait after SIGTERM before SIGKILL when killing replicas
+func WithGracePeriod(gracePeriod time.Duration) ProcessPodControllerOption {
+	return func(c *ProcessPodController) {
+		c.gracePeriod = gracePeriod
+	}
+}
+
+// NewProcessPodController runs containers as local subprocesses,
+// image like group/name:1.0.0 resolved as binary at <binaryRegistry>/group/name/1.0.0.
+func NewProcessPodController(binaryRegistry string, options ...ProcessPodControllerOption) *ProcessPodController {
+	c := &ProcessPodController{
+		binaryRegistry: binaryRegistry,
+		gracePeriod:    DefaultGracePeriod,
+		stdout:         os.Stdout,
+		stderr:         os.Stderr,
+		pods:           map[string]*processPod{},
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	return c
+}
+
+type ProcessPodController struct {
+	binaryRegistry string
+	gracePeriod    time.Duration
+	stdout         io.Writer
+	stderr         io.Writer
+
+	// guards writes to stdout and stderr
+	outputMu sync.Mutex
+
+	mu   sync.Mutex
+	pods map[string]*processPod
+}
+
+type processPod struct {
+	replicas []*processReplica
+	// for naming of replicas
+	seq int
+}
+
+// BinaryPath returns path of binary of image in registry
+func (c *ProcessPodController) BinaryPath(image string) string {
+	name, version := image, ""
+	if i := strings.LastIndex(image, ":"); i > 0 {
+		name, version = image[:i], image[i+1:]
+	}
+	return filepath.Join(c.binaryRegistry, filepath.FromSlash(name), version)
+}
+
+func (c *ProcessPodController) Apply(ctx context.Context, name string, container *Container) error {
+	cmd, err := c.command(container)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+
+	pod, ok := c.pods[name]
+	if !ok {
+		pod = &processPod{}
+		c.pods[name] = pod
+	}
+
+	offset := len(pod.replicas) - int(container.Replicas)
+
+	// scale up
+	for i := 0; i < -offset; i++ {
+		pod.seq++
+		replica := newProcessReplica(c, fmt.Sprintf("%s-%d", name, pod.seq), cmd)
+		pod.replicas = append(pod.replicas, replica)
+		go replica.run()
+	}
+
+	// scale down
+	killing := make([]*processReplica, 0)
+	if offset > 0 {
+		killing = append(killing, pod.replicas[len(pod.replicas)-offset:]...)
+		pod.replicas = pod.replicas[:len(pod.replicas)-offset]
+	}
+
+	if len(pod.replicas) == 0 {
+		delete(c.pods, name)
+	}
+
+	c.mu.Unlock()
+
+	c.stopAll(ctx, killing)
+
+	return nil
+}
+
+func (c *ProcessPodController) Kill(ctx context.Context, name string) error {
+	c.mu.Lock()
+	pod, ok := c.pods[name]
+	delete(c.pods, name)
+	c.mu.Unlock()
+
+	if ok {
+		c.stopAll(ctx, pod.replicas)
+	}
+
+	return nil
+}
+
+func (c *ProcessPodController) stopAll(ctx context.Context, replicas []*processReplica) {
+	wg := sync.WaitGroup{}
+
+	for i := range replicas {
+		wg.Add(1)
+		go func(r *processReplica) {
+			defer wg.Done()
+			r.stop(ctx)
+		}(replicas[i])
+	}
+
+	wg.Wait()
+}
+
+type processCommand struct {
+	path string
+	args []string
+	dir  string
+	env  []string
+}
+
+// command of container, like entrypoint of docker,
+// Command overrides binary of image, with Command[0] relative to directory of binary when not absolute.
+func (c *ProcessPodController) command(container *Container) (*processCommand, error) {
+	binaryPath, err := filepath.Abs(c.BinaryPath(container.Image))
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(binaryPath)
+	if err != nil {
+		return nil, fmt.Errorf("binary of %s not found: %w", container.Image, err)
+	}
+
+	dir := binaryPath
+	if !info.IsDir() {
+		dir = filepath.Dir(binaryPath)
+	}
+
+	cmd := &processCommand{path: binaryPath, args: container.Args, dir: dir}
+
+	if len(container.Command) > 0 {
+		cmd.path = container.Command[0]
+		if !filepath.IsAbs(cmd.path) {
+			cmd.path = filepath.Join(dir, cmd.path)
+		}
+		cmd.args = append(append([]string{}, container.Command[1:]...), container.Args...)
+	} else if info.IsDir() {
+		return nil, fmt.Errorf("binary of %s is a directory, command required", container.Image)
+	}
+
+	// PATH kept for scripts
+	cmd.env = []string{"PATH=" + os.Getenv("PATH")}
+	for k := range container.Envs {
+		cmd.env = append(cmd.env, k+"="+container.Envs[k])
+	}
+
+	return cmd, nil
+}
+
+func newProcessReplica(c *ProcessPodController, name string, cmd *processCommand) *processReplica {
+	return &processReplica{
+		c:       c,
+		name:    name,
+		command: cmd,
+		stopped: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// processReplica keeps a process running, restarted when crashed until stopped
+type processReplica struct {
+	c       *ProcessPodController
+	name    string
+	command *processCommand
+
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+	stopped chan struct{}
+	done    chan struct{}
+}
+
+func (r *processReplica) run() {
+	defer close(r.done)
+
+	restartDelay := minRestartDelay
+
+	for {
+		startedAt := time.Now()
+
+		err := r.runOnce()
+
+		select {
+		case <-r.stopped:
+			return
+		default:
+		}
+
+		if time.Since(startedAt) > stableDuration {
+			restartDelay = minRestartDelay
+		}
+
+		logrus.Warnf("replica %s exited: %v, restarting in %s", r.name, err, restartDelay)
+
+		select {
+		case <-r.stopped:
+			return
+		case <-time.After(restartDelay):
+		}
+
+		if restartDelay *= 2; restartDelay > maxRestartDelay {
+			restartDelay = maxRestartDelay
+		}
+	}
+}
+
+func (r *processReplica) runOnce() error {
+	stdout := r.c.lineWriter(r.c.stdout, r.name)
+	stderr := r.c.lineWriter(r.c.stderr, r.name)
+	defer stdout.Flush()
+	defer stderr.Flush()
+
+	cmd := exec.Command(r.command.path, r.command.args...)
+	cmd.Dir = r.command.dir
+	cmd.Env = r.command.env
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// own process group, to signal children too
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	r.mu.Lock()
+	select {
+	case <-r.stopped:
+		r.mu.Unlock()
+		return nil
+	default:
+	}
+	if err := cmd.Start(); err != nil {
+		r.mu.Unlock()
+		return err
+	}
+	r.cmd = cmd
+	r.mu.Unlock()
+
+	logrus.Debugf("replica %s started, pid %d", r.name, cmd.Process.Pid)
+
+	return cmd.Wait()
+}
+
+func (r *processReplica) pid() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cmd == nil || r.cmd.Process == nil {
+		return 0
+	}
+	return r.cmd.Process.Pid
+}
+
+func (r *processReplica) signal(sig syscall.Signal) {
+	if pid := r.pid(); pid > 0 {
+		_ = syscall.Kill(-pid, sig)
+	}
+}
+
+// stop sends SIGTERM, and SIGKILL after grace period or ctx done
+func (r *processReplica) stop(ctx context.Context) {
+	r.mu.Lock()
+	select {
+	case <-r.stopped:
+	default:
+		close(r.stopped)
+	}
+	r.mu.Unlock()
+
+	logrus.Debugf("killing replica %s", r.name)
+
+	r.signal(syscall.SIGTERM)
+
+	select {
+	case <-r.done:
+		return
+	case <-ctx.Done():
+	case <-time.After(r.c.gracePeriod):
+	}
+
+	r.signal(syscall.SIGKILL)
+
+	<-r.done
+}
+
+func (c *ProcessPodController) lineWriter(w io.Writer, prefix string) *lineWriter {
+	return &lineWriter{mu: &c.outputMu, w: w, prefix: []byte("[" + prefix + "] ")}
+}
+
+// lineWriter writes whole lines with prefix, so lines of replicas not interleaved
+type lineWriter struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	prefix []byte
+	buf    []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+
+	i := bytes.LastIndexByte(w.buf, '\n')
+	if i < 0 {
+		return len(p), nil
+	}
+
+	if err := w.write(w.buf[:i+1]); err != nil {
+		return 0, err
+	}
+
+	w.buf = append(w.buf[:0], w.buf[i+1:]...)
+
+	return len(p), nil
+}
+
+func (w *lineWriter) Flush() {
+	if len(w.buf) > 0 {
+		_ = w.write(append(w.buf, '\n'))
+		w.buf = nil
+	}
+}
+
+func (w *lineWriter) write(lines []byte) error {
+	out := bytes.NewBuffer(nil)
+	for _, line := range bytes.SplitAfter(lines, []byte("\n")) {
+		if len(line) > 0 {
+			out.Write(w.prefix)
+			out.Write(line)
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, err := w.w.Write(out.Bytes())
+	return err
+}
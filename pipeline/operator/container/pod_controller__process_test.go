@@ -0,0 +1,123 @@
+//go:build !windows
+// +build !windows
+
+package container
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/querycap/pipeline/spec"
+)
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestProcessPodController(t *testing.T) {
+	binaryRegistry, err := ioutil.TempDir("", "binaries")
+	NewWithT(t).Expect(err).To(BeNil())
+	defer os.RemoveAll(binaryRegistry)
+
+	c := NewProcessPodController(binaryRegistry, WithGracePeriod(time.Second))
+
+	binary := c.BinaryPath("querycap/sleep:1.0.0")
+	NewWithT(t).Expect(binary).To(Equal(filepath.Join(binaryRegistry, "querycap", "sleep", "1.0.0")))
+
+	NewWithT(t).Expect(os.MkdirAll(filepath.Dir(binary), os.ModePerm)).To(BeNil())
+	NewWithT(t).Expect(ioutil.WriteFile(binary, []byte("#!/bin/sh\necho \"$GREETING $1\"\necho oops >&2\nexec sleep 60\n"), 0755)).To(BeNil())
+
+	stdout, stderr := &syncBuffer{}, &syncBuffer{}
+	WithOutput(stdout, stderr)(c)
+
+	name := PodNameByScopeAndStage("xxx", "sleep")
+
+	container := &Container{
+		Container: spec.Container{Args: []string{"world"}, Envs: spec.Envs{"GREETING": "hello"}},
+		Image:     "querycap/sleep:1.0.0",
+	}
+
+	apply := func(replicas int32) {
+		container.Replicas = replicas
+		NewWithT(t).Expect(c.Apply(context.Background(), name, container)).To(BeNil())
+	}
+
+	pids := func() []int {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		pids := make([]int, 0)
+		if pod, ok := c.pods[name]; ok {
+			for _, r := range pod.replicas {
+				pids = append(pids, r.pid())
+			}
+		}
+		return pids
+	}
+
+	running := func() int {
+		n := 0
+		for _, pid := range pids() {
+			if pid > 0 && syscall.Kill(pid, 0) == nil {
+				n++
+			}
+		}
+		return n
+	}
+
+	t.Run("scale up", func(t *testing.T) {
+		apply(3)
+		NewWithT(t).Eventually(running, 5*time.Second).Should(Equal(3))
+		NewWithT(t).Eventually(stdout.String, 5*time.Second).Should(ContainSubstring("[" + name + "-3] hello world\n"))
+		NewWithT(t).Eventually(stderr.String, 5*time.Second).Should(ContainSubstring("[" + name + "-1] oops\n"))
+	})
+
+	t.Run("scale down", func(t *testing.T) {
+		apply(1)
+		NewWithT(t).Expect(running()).To(Equal(1))
+	})
+
+	t.Run("restart crashed", func(t *testing.T) {
+		pid := pids()[0]
+		NewWithT(t).Expect(syscall.Kill(pid, syscall.SIGKILL)).To(BeNil())
+
+		NewWithT(t).Eventually(func() bool {
+			pids := pids()
+			return len(pids) == 1 && pids[0] != pid && running() == 1
+		}, 5*time.Second).Should(BeTrue())
+	})
+
+	t.Run("kill", func(t *testing.T) {
+		pid := pids()[0]
+
+		NewWithT(t).Expect(c.Kill(context.Background(), name)).To(BeNil())
+		NewWithT(t).Expect(pids()).To(BeEmpty())
+		NewWithT(t).Expect(syscall.Kill(pid, 0)).NotTo(BeNil())
+	})
+
+	t.Run("binary not found", func(t *testing.T) {
+		err := c.Apply(context.Background(), name, &Container{Image: "querycap/missing:1.0.0", Replicas: 1})
+		NewWithT(t).Expect(err).NotTo(BeNil())
+	})
+}
@@ -0,0 +1,338 @@
+package exec
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/querycap/pipeline/pipeline"
+)
+
+// max bytes of tail of stderr kept in ExitError
+const stderrTailSize = 4 * 1024
+
+// ExitError of command exited with non-zero code, with tail of stderr
+type ExitError struct {
+	ExitCode int
+	Stderr   string
+}
+
+func (e *ExitError) Error() string {
+	if e.Stderr == "" {
+		return fmt.Sprintf("exit status %d", e.ExitCode)
+	}
+	return fmt.Sprintf("exit status %d: %s", e.ExitCode, e.Stderr)
+}
+
+type Option = func(c *command)
+
+// WithInputFiles to write inputs to files in PIPELINE_INPUT_DIR, with paths appended to args,
+// instead of streaming them to stdin.
+func WithInputFiles() Option {
+	return func(c *command) {
+		c.inputFiles = true
+	}
+}
+
+// WithOutputDir to put files written to PIPELINE_OUTPUT_DIR in order of names as outputs, instead of stdout,
+// content types detected by extensions or contents.
+func WithOutputDir() Option {
+	return func(c *command) {
+		c.outputDir = true
+	}
+}
+
+// WithStdoutContentType of output from stdout, detected by contents when not set.
+func WithStdoutContentType(contentType string) Option {
+	return func(c *command) {
+		c.stdoutContentType = contentType
+	}
+}
+
+// WithEnvs to set envs of command besides the ones of current process
+func WithEnvs(envs map[string]string) Option {
+	return func(c *command) {
+		for k := range envs {
+			c.envs = append(c.envs, k+"="+envs[k])
+		}
+	}
+}
+
+// WithDir to set working dir of command
+func WithDir(dir string) Option {
+	return func(c *command) {
+		c.dir = dir
+	}
+}
+
+// NewOperatorHandlerFunc runs name with args for each task, killed when task context done.
+//
+// All inputs of task are streamed to stdin in order, and stdout is put as the output by default.
+// Non-zero exit code returned as ExitError with tail of stderr.
+func NewOperatorHandlerFunc(name string, args []string, options ...Option) pipeline.OperatorHandlerFunc {
+	c := &command{name: name, args: args}
+	for _, option := range options {
+		option(c)
+	}
+	return c.handle
+}
+
+type command struct {
+	name              string
+	args              []string
+	dir               string
+	envs              []string
+	inputFiles        bool
+	outputDir         bool
+	stdoutContentType string
+}
+
+func (c *command) handle(t pipeline.Transfer) (err error) {
+	tmp, err := ioutil.TempDir("", "pipeline-exec")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	cmd := osexec.CommandContext(t.Context(), c.name, c.args...)
+	cmd.Dir = c.dir
+	cmd.Env = append(os.Environ(), c.envs...)
+
+	stderr := &tailWriter{size: stderrTailSize}
+	cmd.Stderr = io.MultiWriter(os.Stderr, stderr)
+
+	if c.inputFiles {
+		inputDir := filepath.Join(tmp, "inputs")
+
+		files, err := writeInputs(t, inputDir)
+		if err != nil {
+			return err
+		}
+
+		cmd.Args = append(cmd.Args, files...)
+		cmd.Env = append(cmd.Env, "PIPELINE_INPUT_DIR="+inputDir)
+	} else {
+		stdin, e := cmd.StdinPipe()
+		if e != nil {
+			return e
+		}
+
+		chInputErr := make(chan error, 1)
+
+		go func() {
+			defer stdin.Close()
+			chInputErr <- streamInputs(t, stdin)
+		}()
+
+		// inputs failed to read reported first, since command may fail for the broken stdin
+		defer func() {
+			if inputErr := <-chInputErr; inputErr != nil {
+				err = inputErr
+			}
+		}()
+	}
+
+	if c.outputDir {
+		outputDir := filepath.Join(tmp, "outputs")
+		if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
+			return err
+		}
+
+		cmd.Env = append(cmd.Env, "PIPELINE_OUTPUT_DIR="+outputDir)
+		cmd.Stdout = os.Stdout
+
+		if err := c.run(cmd, stderr); err != nil {
+			return err
+		}
+
+		return putOutputDir(t, outputDir)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	putErr := c.putStdout(t, stdout)
+	// drained for exiting
+	_, _ = io.Copy(ioutil.Discard, stdout)
+
+	if err := c.wait(cmd, stderr); err != nil {
+		return err
+	}
+
+	return putErr
+}
+
+func (c *command) run(cmd *osexec.Cmd, stderr *tailWriter) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return c.wait(cmd, stderr)
+}
+
+func (c *command) wait(cmd *osexec.Cmd, stderr *tailWriter) error {
+	if err := cmd.Wait(); err != nil {
+		if exitErr, ok := err.(*osexec.ExitError); ok {
+			return &ExitError{ExitCode: exitErr.ExitCode(), Stderr: strings.TrimSpace(stderr.String())}
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *command) putStdout(t pipeline.Transfer, stdout io.Reader) error {
+	r := bufio.NewReaderSize(stdout, 512)
+
+	head, err := r.Peek(512)
+	if len(head) == 0 {
+		// nothing output
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+
+	contentType := c.stdoutContentType
+	if contentType == "" {
+		contentType = http.DetectContentType(head)
+	}
+
+	return t.Put(pipeline.WithContentType(contentType)(pipeline.AsWriterTo(r)))
+}
+
+func streamInputs(t pipeline.Transfer, w io.Writer) error {
+	for t.Scan() {
+		if err := pipeline.ReadNext(t, func(r io.Reader) error {
+			_, err := io.Copy(w, r)
+			return err
+		}); err != nil {
+			// stdin closed by command
+			if isBrokenPipe(err) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func isBrokenPipe(err error) bool {
+	return err == io.ErrClosedPipe || strings.Contains(err.Error(), "broken pipe") || strings.Contains(err.Error(), "file already closed")
+}
+
+// writeInputs writes inputs to files in dir, named by index with extension of inputs
+func writeInputs(t pipeline.Transfer, dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	var inputs []string
+	if task := pipeline.TaskFromContext(t.Context()); task != nil {
+		inputs = task.Inputs
+	}
+
+	files := make([]string, 0)
+
+	for i := 0; t.Scan(); i++ {
+		filename := strconv.Itoa(i)
+		if i < len(inputs) {
+			filename += filepath.Ext(inputs[i])
+		}
+		filename = filepath.Join(dir, filename)
+
+		if err := pipeline.ReadNext(t, func(r io.Reader) error {
+			f, err := os.Create(filename)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			_, err = io.Copy(f, r)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+
+		files = append(files, filename)
+	}
+
+	return files, nil
+}
+
+func putOutputDir(t pipeline.Transfer, dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+
+	for _, info := range files {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		if err := putFile(t, filepath.Join(dir, info.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func putFile(t pipeline.Transfer, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	contentType := mime.TypeByExtension(filepath.Ext(filename))
+
+	if contentType == "" {
+		head := make([]byte, 512)
+		n, err := io.ReadFull(f, head)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		contentType = http.DetectContentType(head[:n])
+
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	return t.Put(pipeline.WithContentType(contentType)(pipeline.AsWriterTo(f)))
+}
+
+// tailWriter keeps last size bytes written
+type tailWriter struct {
+	size int
+	buf  bytes.Buffer
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	if over := w.buf.Len() - w.size; over > 0 {
+		w.buf.Next(over)
+	}
+	return len(p), nil
+}
+
+func (w *tailWriter) String() string {
+	return w.buf.String()
+}
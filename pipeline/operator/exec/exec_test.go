@@ -0,0 +1,116 @@
+package exec_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/querycap/pipeline/pipeline"
+	"github.com/querycap/pipeline/pipeline/operator/exec"
+)
+
+// transfer in memory, with outputs collected by content type
+type transfer struct {
+	ctx          context.Context
+	inputs       []string
+	outputs      []string
+	contentTypes []string
+}
+
+func (t *transfer) Context() context.Context {
+	return t.ctx
+}
+
+func (t *transfer) Scan() bool {
+	return len(t.inputs) > 0
+}
+
+func (t *transfer) Next() (io.ReadCloser, error) {
+	input := t.inputs[0]
+	t.inputs = t.inputs[1:]
+	return ioutil.NopCloser(bytes.NewBufferString(input)), nil
+}
+
+func (t *transfer) Put(writerTo io.WriterTo) error {
+	buf := bytes.NewBuffer(nil)
+	if _, err := writerTo.WriteTo(buf); err != nil {
+		return err
+	}
+	t.outputs = append(t.outputs, buf.String())
+	t.contentTypes = append(t.contentTypes, writerTo.(pipeline.ContentTypeDescriber).ContentType())
+	return nil
+}
+
+func (t *transfer) Send() error {
+	return nil
+}
+
+func newTransfer(inputs ...string) *transfer {
+	return &transfer{ctx: context.Background(), inputs: inputs}
+}
+
+func TestOperatorHandlerFunc(t *testing.T) {
+	t.Run("stdin to stdout", func(t *testing.T) {
+		tr := newTransfer("hello ", "world")
+
+		err := exec.NewOperatorHandlerFunc("tr", []string{"a-z", "A-Z"})(tr)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(tr.outputs).To(Equal([]string{"HELLO WORLD"}))
+		NewWithT(t).Expect(tr.contentTypes).To(Equal([]string{"text/plain; charset=utf-8"}))
+	})
+
+	t.Run("stdout content type", func(t *testing.T) {
+		tr := newTransfer(`{"a":1}`)
+
+		err := exec.NewOperatorHandlerFunc("cat", nil, exec.WithStdoutContentType("application/json"))(tr)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(tr.outputs).To(Equal([]string{`{"a":1}`}))
+		NewWithT(t).Expect(tr.contentTypes).To(Equal([]string{"application/json"}))
+	})
+
+	t.Run("input files and output dir", func(t *testing.T) {
+		tr := newTransfer("a", "b")
+
+		script := `for f in "$@"; do cat "$f" > "$PIPELINE_OUTPUT_DIR/$(basename "$f").txt"; echo '{}' > "$PIPELINE_OUTPUT_DIR/$(basename "$f").json"; done; echo ignored`
+
+		err := exec.NewOperatorHandlerFunc("sh", []string{"-c", script, "sh"}, exec.WithInputFiles(), exec.WithOutputDir())(tr)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(tr.outputs).To(Equal([]string{"{}\n", "a", "{}\n", "b"}))
+		NewWithT(t).Expect(tr.contentTypes).To(Equal([]string{"application/json", "text/plain; charset=utf-8", "application/json", "text/plain; charset=utf-8"}))
+	})
+
+	t.Run("exit code and stderr", func(t *testing.T) {
+		tr := newTransfer("x")
+
+		err := exec.NewOperatorHandlerFunc("sh", []string{"-c", "echo partial; echo failed >&2; exit 3"}, exec.WithEnvs(map[string]string{"X": "1"}))(tr)
+
+		exitErr := &exec.ExitError{}
+		NewWithT(t).Expect(errors.As(err, &exitErr)).To(BeTrue())
+		NewWithT(t).Expect(exitErr.ExitCode).To(Equal(3))
+		NewWithT(t).Expect(exitErr.Stderr).To(Equal("failed"))
+		NewWithT(t).Expect(err.Error()).To(Equal("exit status 3: failed"))
+	})
+
+	t.Run("stdin not read", func(t *testing.T) {
+		tr := newTransfer(string(make([]byte, 1<<20)))
+
+		err := exec.NewOperatorHandlerFunc("echo", []string{"done"})(tr)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(tr.outputs).To(Equal([]string{"done\n"}))
+	})
+
+	t.Run("killed when context done", func(t *testing.T) {
+		tr := newTransfer()
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		tr.ctx = ctx
+
+		err := exec.NewOperatorHandlerFunc("sleep", []string{"10"})(tr)
+		NewWithT(t).Expect(err).NotTo(BeNil())
+	})
+}
@@ -0,0 +1,72 @@
+package http
+
+import (
+	"sync"
+
+	"github.com/querycap/pipeline/pipeline"
+	"github.com/querycap/pipeline/spec"
+)
+
+// NewDispatchingOperatorMgr serves stages with http set or operator of endpoint registered by the HTTPOperatorMgr,
+// and others by the fallback, like the container or mem operator mgr.
+func NewDispatchingOperatorMgr(httpOperatorMgr *HTTPOperatorMgr, fallback pipeline.OperatorMgr) *DispatchingOperatorMgr {
+	return &DispatchingOperatorMgr{
+		httpOperatorMgr: httpOperatorMgr,
+		fallback:        fallback,
+	}
+}
+
+var _ pipeline.OperatorMgr = (*DispatchingOperatorMgr)(nil)
+
+type DispatchingOperatorMgr struct {
+	httpOperatorMgr *HTTPOperatorMgr
+	fallback        pipeline.OperatorMgr
+	// operator mgr of stages up, to destroy by
+	dispatched sync.Map
+}
+
+func (m *DispatchingOperatorMgr) operatorMgrOf(step spec.Stage) pipeline.OperatorMgr {
+	if step.HTTP != nil {
+		return m.httpOperatorMgr
+	}
+	if _, ok := m.httpOperatorMgr.endpoints.Load(step.Uses.RefID()); ok {
+		return m.httpOperatorMgr
+	}
+	return m.fallback
+}
+
+func (m *DispatchingOperatorMgr) Up(scope string, name string, step spec.Stage, replicas int32) error {
+	operatorMgr := m.operatorMgrOf(step)
+
+	// stage moved between operator mgrs, like http of stage set or unset
+	if v, ok := m.dispatched.Load(scope + "/" + name); ok && v != operatorMgr {
+		if err := v.(pipeline.OperatorMgr).Destroy(scope, name); err != nil {
+			return err
+		}
+	}
+
+	if err := operatorMgr.Up(scope, name, step, replicas); err != nil {
+		return err
+	}
+
+	m.dispatched.Store(scope+"/"+name, operatorMgr)
+	return nil
+}
+
+func (m *DispatchingOperatorMgr) Destroy(scope string, name string) error {
+	v, ok := m.dispatched.Load(scope + "/" + name)
+	if !ok {
+		// stages up before restarted, destroyed by both
+		if err := m.httpOperatorMgr.Destroy(scope, name); err != nil {
+			return err
+		}
+		return m.fallback.Destroy(scope, name)
+	}
+
+	if err := v.(pipeline.OperatorMgr).Destroy(scope, name); err != nil {
+		return err
+	}
+
+	m.dispatched.Delete(scope + "/" + name)
+	return nil
+}
@@ -0,0 +1,249 @@
+package http
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	nethttp "net/http"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/querycap/pipeline/pipeline"
+	"github.com/querycap/pipeline/spec"
+)
+
+const (
+	HeaderTaskID   = "X-Pipeline-Task-Id"
+	HeaderScope    = "X-Pipeline-Scope"
+	HeaderStage    = "X-Pipeline-Stage"
+	HeaderAttempt  = "X-Pipeline-Attempt"
+	HeaderDeadline = "X-Pipeline-Deadline"
+
+	// max bytes of response body kept in StatusError
+	errorBodySize = 4 * 1024
+)
+
+// StatusError of endpoint responded with non-2xx status, with head of response body
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("status %d: %s", e.StatusCode, e.Body)
+}
+
+type Option = func(e *endpoint)
+
+// WithClient to send requests, nethttp.DefaultClient by default
+func WithClient(client *nethttp.Client) Option {
+	return func(e *endpoint) {
+		e.client = client
+	}
+}
+
+// NewOperatorHandlerFunc calls the endpoint for each task, cancelled when task context done.
+//
+// Single input sent as request body, and multiple inputs sent as multipart/form-data,
+// with content types detected by extensions of inputs.
+// Meta of task sent as headers, with task id, scope, stage, attempt and deadline as X-Pipeline-* headers.
+//
+// Response body put as output with content type of response, and each part put as output for multipart response.
+// Non-2xx status returned as StatusError, and 204 puts nothing.
+func NewOperatorHandlerFunc(httpEndpoint spec.HTTPEndpoint, options ...Option) pipeline.OperatorHandlerFunc {
+	e := &endpoint{HTTPEndpoint: httpEndpoint, client: nethttp.DefaultClient}
+	for _, option := range options {
+		option(e)
+	}
+	return e.handle
+}
+
+type endpoint struct {
+	spec.HTTPEndpoint
+	client *nethttp.Client
+}
+
+func (e *endpoint) handle(t pipeline.Transfer) error {
+	task := pipeline.TaskFromContext(t.Context())
+
+	var inputs []string
+	if task != nil {
+		inputs = task.Inputs
+	}
+
+	body, contentType, err := requestBody(t, inputs)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		defer body.Close()
+	}
+
+	method := e.Method
+	if method == "" {
+		method = nethttp.MethodPost
+	}
+
+	req, err := nethttp.NewRequest(method, e.URL, body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(t.Context())
+
+	for k, v := range e.Headers {
+		req.Header.Set(k, v)
+	}
+
+	if task != nil {
+		for k, values := range task.Meta {
+			for _, v := range values {
+				req.Header.Add(k, v)
+			}
+		}
+
+		req.Header.Set(HeaderTaskID, strconv.FormatUint(task.ID, 10))
+		req.Header.Set(HeaderScope, task.Scope)
+
+		if task.TaskStage != nil {
+			req.Header.Set(HeaderStage, task.Stage)
+			req.Header.Set(HeaderAttempt, strconv.Itoa(task.Attempt))
+		}
+
+		if task.Deadline != nil {
+			req.Header.Set(HeaderDeadline, task.Deadline.Format(time.RFC3339Nano))
+		}
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, errorBodySize))
+		return &StatusError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(data))}
+	}
+
+	if resp.StatusCode == nethttp.StatusNoContent {
+		return nil
+	}
+
+	return putResponse(t, resp)
+}
+
+// requestBody returns nil body when no inputs
+func requestBody(t pipeline.Transfer, inputs []string) (io.ReadCloser, string, error) {
+	if !t.Scan() {
+		return nil, "", nil
+	}
+
+	if len(inputs) <= 1 {
+		r, err := t.Next()
+		if err != nil {
+			return nil, "", err
+		}
+		return r, inputContentType(inputs, 0), nil
+	}
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+
+	go func() {
+		_ = pw.CloseWithError(writeMultipart(t, mw, inputs))
+	}()
+
+	return pr, mw.FormDataContentType(), nil
+}
+
+func writeMultipart(t pipeline.Transfer, mw *multipart.Writer, inputs []string) error {
+	for i := 0; t.Scan(); i++ {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="input"; filename="%d%s"`, i, inputExt(inputs, i)))
+		header.Set("Content-Type", inputContentType(inputs, i))
+
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			return err
+		}
+
+		if err := pipeline.ReadNext(t, func(r io.Reader) error {
+			_, err := io.Copy(part, r)
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+
+	return mw.Close()
+}
+
+func inputExt(inputs []string, i int) string {
+	if i < len(inputs) {
+		return filepath.Ext(inputs[i])
+	}
+	return ""
+}
+
+func inputContentType(inputs []string, i int) string {
+	if contentType := mime.TypeByExtension(inputExt(inputs, i)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+func putResponse(t pipeline.Transfer, resp *nethttp.Response) error {
+	contentType := resp.Header.Get("Content-Type")
+
+	if mediaType, params, err := mime.ParseMediaType(contentType); err == nil && strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(resp.Body, params["boundary"])
+
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+
+			if err := putBody(t, part, part.Header.Get("Content-Type")); err != nil {
+				return err
+			}
+		}
+	}
+
+	return putBody(t, resp.Body, contentType)
+}
+
+// putBody puts body with content type, detected by contents when empty; empty body puts nothing
+func putBody(t pipeline.Transfer, body io.Reader, contentType string) error {
+	r := bufio.NewReaderSize(body, 512)
+
+	head, err := r.Peek(512)
+	if len(head) == 0 {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+
+	if contentType == "" {
+		contentType = nethttp.DetectContentType(head)
+	}
+
+	return t.Put(pipeline.WithContentType(contentType)(pipeline.AsWriterTo(r)))
+}
@@ -0,0 +1,172 @@
+package http_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	nethttp "net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/querycap/pipeline/pipeline"
+	"github.com/querycap/pipeline/pipeline/operator/http"
+	"github.com/querycap/pipeline/spec"
+)
+
+// transfer in memory, with outputs collected by content type
+type transfer struct {
+	ctx          context.Context
+	inputs       []string
+	outputs      []string
+	contentTypes []string
+}
+
+func (t *transfer) Context() context.Context {
+	return t.ctx
+}
+
+func (t *transfer) Scan() bool {
+	return len(t.inputs) > 0
+}
+
+func (t *transfer) Next() (io.ReadCloser, error) {
+	input := t.inputs[0]
+	t.inputs = t.inputs[1:]
+	return ioutil.NopCloser(bytes.NewBufferString(input)), nil
+}
+
+func (t *transfer) Put(writerTo io.WriterTo) error {
+	buf := bytes.NewBuffer(nil)
+	if _, err := writerTo.WriteTo(buf); err != nil {
+		return err
+	}
+	t.outputs = append(t.outputs, buf.String())
+	t.contentTypes = append(t.contentTypes, writerTo.(pipeline.ContentTypeDescriber).ContentType())
+	return nil
+}
+
+func (t *transfer) Send() error {
+	return nil
+}
+
+// newTransfer of task with inputs, named by files
+func newTransfer(files []string, inputs ...string) *transfer {
+	task := (&pipeline.TaskMeta{Scope: "test"}).NewTask(1).Next("a", files)
+	task.Meta = textproto.MIMEHeader{"X-Trace-Id": {"trace"}}
+	return &transfer{ctx: pipeline.ContextWithTask(context.Background(), task), inputs: inputs}
+}
+
+func TestOperatorHandlerFunc(t *testing.T) {
+	t.Run("single input", func(t *testing.T) {
+		var header nethttp.Header
+
+		srv := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, req *nethttp.Request) {
+			header = req.Header
+			data, _ := ioutil.ReadAll(req.Body)
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = fmt.Fprintf(w, `{"got":%q}`, string(data))
+		}))
+		defer srv.Close()
+
+		tr := newTransfer([]string{"tasks/1/$input/1.json"}, `{}`)
+
+		err := http.NewOperatorHandlerFunc(spec.HTTPEndpoint{URL: srv.URL, Headers: map[string]string{"Authorization": "Bearer x"}})(tr)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(tr.outputs).To(Equal([]string{`{"got":"{}"}`}))
+		NewWithT(t).Expect(tr.contentTypes).To(Equal([]string{"application/json"}))
+
+		NewWithT(t).Expect(header.Get("Content-Type")).To(Equal("application/json"))
+		NewWithT(t).Expect(header.Get("Authorization")).To(Equal("Bearer x"))
+		NewWithT(t).Expect(header.Get("X-Trace-Id")).To(Equal("trace"))
+		NewWithT(t).Expect(header.Get(http.HeaderTaskID)).To(Equal("1"))
+		NewWithT(t).Expect(header.Get(http.HeaderScope)).To(Equal("test"))
+		NewWithT(t).Expect(header.Get(http.HeaderStage)).To(Equal("a"))
+		NewWithT(t).Expect(header.Get(http.HeaderAttempt)).To(Equal("0"))
+	})
+
+	t.Run("multiple inputs as multipart", func(t *testing.T) {
+		srv := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, req *nethttp.Request) {
+			_, params, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+			mr := multipart.NewReader(req.Body, params["boundary"])
+
+			parts := make([]string, 0)
+			for {
+				part, err := mr.NextPart()
+				if err != nil {
+					break
+				}
+				data, _ := ioutil.ReadAll(part)
+				parts = append(parts, part.FileName()+" "+part.Header.Get("Content-Type")+" "+string(data))
+			}
+
+			_, _ = io.WriteString(w, strings.Join(parts, "\n"))
+		}))
+		defer srv.Close()
+
+		tr := newTransfer([]string{"a/1.json", "b/2"}, `{}`, "b")
+
+		err := http.NewOperatorHandlerFunc(spec.HTTPEndpoint{URL: srv.URL})(tr)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(tr.outputs).To(Equal([]string{"0.json application/json {}\n1 application/octet-stream b"}))
+		NewWithT(t).Expect(tr.contentTypes).To(Equal([]string{"text/plain; charset=utf-8"}))
+	})
+
+	t.Run("multipart response", func(t *testing.T) {
+		srv := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, req *nethttp.Request) {
+			mw := multipart.NewWriter(w)
+			w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+
+			for _, contentType := range []string{"application/json", "text/csv"} {
+				part, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {contentType}})
+				_, _ = io.WriteString(part, contentType)
+			}
+
+			_ = mw.Close()
+		}))
+		defer srv.Close()
+
+		tr := newTransfer(nil)
+
+		err := http.NewOperatorHandlerFunc(spec.HTTPEndpoint{URL: srv.URL, Method: nethttp.MethodGet})(tr)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(tr.outputs).To(Equal([]string{"application/json", "text/csv"}))
+		NewWithT(t).Expect(tr.contentTypes).To(Equal([]string{"application/json", "text/csv"}))
+	})
+
+	t.Run("no content", func(t *testing.T) {
+		srv := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, req *nethttp.Request) {
+			w.WriteHeader(nethttp.StatusNoContent)
+		}))
+		defer srv.Close()
+
+		tr := newTransfer([]string{"1.txt"}, "a")
+
+		err := http.NewOperatorHandlerFunc(spec.HTTPEndpoint{URL: srv.URL})(tr)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(tr.outputs).To(BeEmpty())
+	})
+
+	t.Run("error status", func(t *testing.T) {
+		srv := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, req *nethttp.Request) {
+			nethttp.Error(w, "bad input", nethttp.StatusBadRequest)
+		}))
+		defer srv.Close()
+
+		tr := newTransfer([]string{"1.txt"}, "a")
+
+		err := http.NewOperatorHandlerFunc(spec.HTTPEndpoint{URL: srv.URL})(tr)
+
+		statusErr := &http.StatusError{}
+		NewWithT(t).Expect(errors.As(err, &statusErr)).To(BeTrue())
+		NewWithT(t).Expect(statusErr.StatusCode).To(Equal(nethttp.StatusBadRequest))
+		NewWithT(t).Expect(statusErr.Body).To(Equal("bad input"))
+	})
+}
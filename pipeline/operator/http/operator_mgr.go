@@ -0,0 +1,113 @@
+package http
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/querycap/pipeline/pipeline"
+	"github.com/querycap/pipeline/spec"
+)
+
+func NewHTTPOperatorMgr(pipelineController pipeline.PipelineController, options ...Option) *HTTPOperatorMgr {
+	return &HTTPOperatorMgr{
+		pipelineController: pipelineController,
+		options:            options,
+		instances:          map[string][]pipeline.Subscription{},
+	}
+}
+
+var _ pipeline.OperatorMgr = (*HTTPOperatorMgr)(nil)
+
+type operator struct {
+	endpoint     spec.HTTPEndpoint
+	operatorMeta spec.OperatorMeta
+}
+
+// HTTPOperatorMgr serves stages by calling http endpoints of operators.
+type HTTPOperatorMgr struct {
+	pipelineController pipeline.PipelineController
+	options            []Option
+	endpoints          sync.Map
+
+	rw        sync.Mutex
+	instances map[string][]pipeline.Subscription
+}
+
+// Register endpoint of operator, schemas of operator taken when ref is *spec.Operator or with OperatorMeta.
+// Endpoints registered are kept in memory only, so credentials could be set in headers of them.
+func (m *HTTPOperatorMgr) Register(ref pipeline.WithRefID, endpoint spec.HTTPEndpoint) error {
+	m.endpoints.Store(ref.RefID(), &operator{
+		endpoint:     endpoint,
+		operatorMeta: pipeline.OperatorMetaFrom(ref),
+	})
+	return nil
+}
+
+// Up serves the stage in goroutines, one subscription of event bus for each replica,
+// with http of stage overriding endpoint registered, but headers of endpoint registered kept unless set by stage,
+// replicas of stage already up will be scaled.
+func (m *HTTPOperatorMgr) Up(scope string, name string, step spec.Stage, replicas int32) error {
+	o := &operator{}
+
+	if v, ok := m.endpoints.Load(step.Uses.RefID()); ok {
+		o = v.(*operator)
+	} else if step.HTTP == nil {
+		return fmt.Errorf("%s not found", step.Uses)
+	}
+
+	endpoint := o.endpoint
+	if step.HTTP != nil {
+		endpoint = *step.HTTP
+		endpoint.Headers = mergeHeaders(o.endpoint.Headers, step.HTTP.Headers)
+	}
+
+	if replicas < 1 {
+		replicas = 1
+	}
+
+	handlerFunc := NewOperatorHandlerFunc(endpoint, m.options...)
+
+	m.rw.Lock()
+	defer m.rw.Unlock()
+
+	instanceID := scope + "/" + name
+
+	subscriptions := m.instances[instanceID]
+
+	for i := int32(len(subscriptions)); i < replicas; i++ {
+		subscriptions = append(subscriptions, pipeline.ServeOperator(m.pipelineController.WithScope(scope), name, handlerFunc, pipeline.WithSchemas(o.operatorMeta)))
+	}
+
+	for int32(len(subscriptions)) > replicas {
+		subscriptions[len(subscriptions)-1].Unsubscribe()
+		subscriptions = subscriptions[:len(subscriptions)-1]
+	}
+
+	m.instances[instanceID] = subscriptions
+	return nil
+}
+
+func (m *HTTPOperatorMgr) Destroy(scope string, name string) error {
+	m.rw.Lock()
+	defer m.rw.Unlock()
+
+	instanceID := scope + "/" + name
+
+	for _, subscription := range m.instances[instanceID] {
+		subscription.Unsubscribe()
+	}
+
+	delete(m.instances, instanceID)
+
+	return nil
+}
+
+func mergeHeaders(headers ...map[string]string) map[string]string {
+	merged := map[string]string{}
+	for _, h := range headers {
+		for k, v := range h {
+			merged[k] = v
+		}
+	}
+	return merged
+}
@@ -0,0 +1,189 @@
+package http_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	nethttp "net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-courier/semver"
+	. "github.com/onsi/gomega"
+	"github.com/querycap/pipeline/pipeline"
+	"github.com/querycap/pipeline/pipeline/eventbus/mem"
+	"github.com/querycap/pipeline/pipeline/operator/http"
+	opmem "github.com/querycap/pipeline/pipeline/operator/mem"
+	"github.com/querycap/pipeline/pipeline/pipelinetest"
+	"github.com/querycap/pipeline/pipeline/storage/fs"
+	"github.com/querycap/pipeline/spec"
+	"github.com/spf13/afero"
+)
+
+// appendServer responds request body with suffix appended
+func appendServer(suffix string) *httptest.Server {
+	return httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, req *nethttp.Request) {
+		data, _ := ioutil.ReadAll(req.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write(append(data, suffix...))
+	}))
+}
+
+// authorizedAppendServer responds like appendServer, but only to requests with authorization
+func authorizedAppendServer(suffix string, authorization string) *httptest.Server {
+	return httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, req *nethttp.Request) {
+		if req.Header.Get("Authorization") != authorization {
+			w.WriteHeader(nethttp.StatusUnauthorized)
+			return
+		}
+		data, _ := ioutil.ReadAll(req.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write(append(data, suffix...))
+	}))
+}
+
+func TestHTTPOperatorMgr(t *testing.T) {
+	a := authorizedAppendServer("a", "Bearer token")
+	defer a.Close()
+	b := appendServer("b")
+	defer b.Close()
+
+	pc := pipeline.NewPipelineController(mem.NewMemEventBus(), fs.NewFsStorage(afero.NewMemMapFs()), &pipelinetest.IDGen{}, pipelinetest.MachineIdentifier("test"))
+
+	operatorMgr := http.NewHTTPOperatorMgr(pc)
+
+	version := *semver.MustParseVersion("1.0.0")
+
+	_ = operatorMgr.Register(spec.NewRefOperator("a", version), spec.HTTPEndpoint{URL: "http://operator/a", Headers: map[string]string{"Authorization": "Bearer token"}})
+
+	p, err := pipeline.NewPipelineMgr(operatorMgr, pc).NewPipeline(&spec.Pipeline{
+		Name:    "http",
+		Version: version,
+		PipelineFlow: spec.PipelineFlow{
+			Starts: "a",
+			Ends:   "b",
+			Stages: map[string]spec.Stage{
+				// headers of endpoint registered kept
+				"a": {Uses: *spec.NewRefOperator("a", version), HTTP: &spec.HTTPEndpoint{URL: a.URL}},
+				"b": {Uses: *spec.NewRefOperator("b", version), Deps: []string{"a"}, HTTP: &spec.HTTPEndpoint{URL: b.URL}},
+			},
+		},
+	})
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(p.Start()).To(BeNil())
+	defer p.Stop()
+
+	output, err := pipelinetest.Run(p, "input:")
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(string(output)).To(Equal("input:ab"))
+
+	t.Run("not registered", func(t *testing.T) {
+		err := operatorMgr.Up("test", "c", spec.Stage{Uses: *spec.NewRefOperator("c", version)}, 1)
+		NewWithT(t).Expect(err).NotTo(BeNil())
+	})
+}
+
+func TestHTTPOperatorMgrReplicas(t *testing.T) {
+	inflight := int32(0)
+	concurrent := make(chan struct{})
+
+	// responds once requests handled concurrently
+	s := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, req *nethttp.Request) {
+		if atomic.AddInt32(&inflight, 1) == 2 {
+			close(concurrent)
+		}
+
+		select {
+		case <-concurrent:
+		case <-time.After(2 * time.Second):
+			w.WriteHeader(nethttp.StatusServiceUnavailable)
+			return
+		}
+
+		data, _ := ioutil.ReadAll(req.Body)
+		_, _ = w.Write(data)
+	}))
+	defer s.Close()
+
+	pc := pipeline.NewPipelineController(mem.NewMemEventBus(), fs.NewFsStorage(afero.NewMemMapFs()), &pipelinetest.IDGen{}, pipelinetest.MachineIdentifier("test"))
+
+	version := *semver.MustParseVersion("1.0.0")
+
+	p, err := pipeline.NewPipelineMgr(http.NewHTTPOperatorMgr(pc), pc).NewPipeline(&spec.Pipeline{
+		Name:    "replicas",
+		Version: version,
+		PipelineFlow: spec.PipelineFlow{
+			Starts: "a",
+			Ends:   "a",
+			Stages: map[string]spec.Stage{
+				"a": {Uses: *spec.NewRefOperator("a", version), HTTP: &spec.HTTPEndpoint{URL: s.URL}, Scaling: spec.Scaling{Replicas: 2}},
+			},
+		},
+	})
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(p.Start()).To(BeNil())
+	defer p.Stop()
+
+	outputs := make(chan string, 2)
+
+	for _, input := range []string{"1", "2"} {
+		go func(input string) {
+			output, err := pipelinetest.Run(p, input)
+			if err != nil {
+				output = []byte(err.Error())
+			}
+			outputs <- string(output)
+		}(input)
+	}
+
+	NewWithT(t).Expect([]string{<-outputs, <-outputs}).To(ConsistOf("1", "2"))
+}
+
+func TestDispatchingOperatorMgr(t *testing.T) {
+	b := appendServer("b")
+	defer b.Close()
+
+	pc := pipeline.NewPipelineController(mem.NewMemEventBus(), fs.NewFsStorage(afero.NewMemMapFs()), &pipelinetest.IDGen{}, pipelinetest.MachineIdentifier("test"))
+
+	version := *semver.MustParseVersion("1.0.0")
+
+	memOperatorMgr := opmem.NewMemOperatorMgr(pc)
+	_ = memOperatorMgr.Register(spec.NewRefOperator("a", version), func(t pipeline.Transfer) error {
+		return pipeline.ReadNext(t, func(r io.Reader) error {
+			data, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			return t.Put(bytes.NewBuffer(append(data, 'a')))
+		})
+	})
+
+	operatorMgr := http.NewDispatchingOperatorMgr(http.NewHTTPOperatorMgr(pc), memOperatorMgr)
+
+	p, err := pipeline.NewPipelineMgr(operatorMgr, pc).NewPipeline(&spec.Pipeline{
+		Name:    "dispatching",
+		Version: version,
+		PipelineFlow: spec.PipelineFlow{
+			Starts: "a",
+			Ends:   "b",
+			Stages: map[string]spec.Stage{
+				"a": {Uses: *spec.NewRefOperator("a", version)},
+				"b": {Uses: *spec.NewRefOperator("b", version), Deps: []string{"a"}, HTTP: &spec.HTTPEndpoint{URL: b.URL}},
+			},
+		},
+	})
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(p.Start()).To(BeNil())
+	defer p.Stop()
+
+	output, err := pipelinetest.Run(p, "input:")
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(string(output)).To(Equal("input:ab"))
+
+	t.Run("not registered", func(t *testing.T) {
+		err := operatorMgr.Up("test", "c", spec.Stage{Uses: *spec.NewRefOperator("c", version)}, 1)
+		NewWithT(t).Expect(err).NotTo(BeNil())
+	})
+}
@@ -11,42 +11,79 @@ import (
 func NewMemOperatorMgr(pipelineController pipeline.PipelineController) *MemOperatorMgr {
 	return &MemOperatorMgr{
 		pipelineController: pipelineController,
+		instances:          map[string][]pipeline.Subscription{},
 	}
 }
 
 var _ pipeline.OperatorMgr = (*MemOperatorMgr)(nil)
 
+type operator struct {
+	handlerFunc  pipeline.OperatorHandlerFunc
+	operatorMeta spec.OperatorMeta
+}
+
 type MemOperatorMgr struct {
 	pipelineController pipeline.PipelineController
 	handlerFuncs       sync.Map
-	instances          sync.Map
+
+	rw        sync.Mutex
+	instances map[string][]pipeline.Subscription
 }
 
+// Register handler of operator, schemas of operator taken when ref is *spec.Operator or with OperatorMeta.
 func (m *MemOperatorMgr) Register(ref pipeline.WithRefID, handlerFunc pipeline.OperatorHandlerFunc) error {
-	m.handlerFuncs.Store(ref.RefID(), handlerFunc)
+	m.handlerFuncs.Store(ref.RefID(), &operator{
+		handlerFunc:  handlerFunc,
+		operatorMeta: pipeline.OperatorMetaFrom(ref),
+	})
 	return nil
 }
 
+// Up serves the stage in goroutines, one subscription of event bus for each replica,
+// replicas of stage already up will be scaled.
 func (m *MemOperatorMgr) Up(scope string, name string, step spec.Stage, replicas int32) error {
 	v, ok := m.handlerFuncs.Load(step.Uses.RefID())
 	if !ok {
-		return fmt.Errorf("%s not found", step)
+		return fmt.Errorf("%s not found", step.Uses)
+	}
+
+	if replicas < 1 {
+		replicas = 1
 	}
 
-	subscription := pipeline.ServeOperator(m.pipelineController.WithScope(scope), name, v.(pipeline.OperatorHandlerFunc))
+	o := v.(*operator)
+
+	m.rw.Lock()
+	defer m.rw.Unlock()
+
+	instanceID := scope + "/" + name
 
-	m.instances.Store(scope+"/"+name, subscription)
+	subscriptions := m.instances[instanceID]
+
+	for i := int32(len(subscriptions)); i < replicas; i++ {
+		subscriptions = append(subscriptions, pipeline.ServeOperator(m.pipelineController.WithScope(scope), name, o.handlerFunc, pipeline.WithSchemas(o.operatorMeta)))
+	}
+
+	for int32(len(subscriptions)) > replicas {
+		subscriptions[len(subscriptions)-1].Unsubscribe()
+		subscriptions = subscriptions[:len(subscriptions)-1]
+	}
+
+	m.instances[instanceID] = subscriptions
 	return nil
 }
 
 func (m *MemOperatorMgr) Destroy(scope string, name string) error {
+	m.rw.Lock()
+	defer m.rw.Unlock()
+
 	instanceID := scope + "/" + name
 
-	v, ok := m.instances.Load(instanceID)
-	if ok {
-		v.(pipeline.Subscription).Unsubscribe()
-		m.instances.Delete(instanceID)
+	for _, subscription := range m.instances[instanceID] {
+		subscription.Unsubscribe()
 	}
 
+	delete(m.instances, instanceID)
+
 	return nil
 }
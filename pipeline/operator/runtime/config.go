@@ -0,0 +1,303 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/querycap/pipeline/pipeline"
+	deadletterredis "github.com/querycap/pipeline/pipeline/deadletter/redis"
+	eventbusredis "github.com/querycap/pipeline/pipeline/eventbus/redis"
+	"github.com/querycap/pipeline/pipeline/eventbus/redisstream"
+	idgenredis "github.com/querycap/pipeline/pipeline/idgen/redis"
+	"github.com/querycap/pipeline/pipeline/idgen/snowflake"
+	resultcacheredis "github.com/querycap/pipeline/pipeline/resultcache/redis"
+	"github.com/querycap/pipeline/pipeline/storage/fs"
+	"github.com/querycap/pipeline/pipeline/storage/s3"
+	taskstoreredis "github.com/querycap/pipeline/pipeline/taskstore/redis"
+	"github.com/querycap/pipeline/pkg/redisutil"
+	"github.com/querycap/pipeline/pkg/s3util"
+	"github.com/spf13/afero"
+)
+
+const (
+	DefaultHealthAddr   = ":8080"
+	DefaultDrainTimeout = 30 * time.Second
+	// DefaultTaskTTL of task store, when ttl not set in uri
+	DefaultTaskTTL = 7 * 24 * time.Hour
+)
+
+// Config of operator runtime, loaded from envs by ConfigFromEnv
+type Config struct {
+	// PIPELINE_SCOPE, injected by operator mgr
+	Scope string
+	// PIPELINE_STAGE, injected by operator mgr
+	Stage string
+	// PIPELINE_EVENT_BUS, redis://127.0.0.1:6379?db=1 or redisstream://127.0.0.1:6379?db=1
+	EventBus string
+	// PIPELINE_STORAGE, s3://<accessKey>:<secretKey>@<endpoint>/<bucket>?region=xx (s3s for ssl), or file:///data
+	Storage string
+	// PIPELINE_ID_GEN, snowflake://?worker=1 or redis://127.0.0.1:6379?db=1&key=pipeline:id,
+	// snowflake with worker hashed from machine id when empty, which may conflict.
+	IDGen string
+	// PIPELINE_MACHINE_ID, hostname when empty
+	MachineID string
+	// PIPELINE_TASK_STORE, optional, redis://127.0.0.1:6379?db=1&ttl=168h
+	TaskStore string
+	// PIPELINE_DEAD_LETTER_STORE, optional, redis://127.0.0.1:6379?db=1
+	DeadLetterStore string
+	// PIPELINE_RESULT_CACHE, optional, redis://127.0.0.1:6379?db=1
+	ResultCache string
+	// PIPELINE_HEALTH_ADDR, address of health endpoints, DefaultHealthAddr when empty
+	HealthAddr string
+	// PIPELINE_DRAIN_TIMEOUT, how long to wait for handling tasks when terminating
+	DrainTimeout time.Duration
+}
+
+func ConfigFromEnv() (*Config, error) {
+	c := &Config{
+		Scope:           os.Getenv("PIPELINE_SCOPE"),
+		Stage:           os.Getenv("PIPELINE_STAGE"),
+		EventBus:        os.Getenv("PIPELINE_EVENT_BUS"),
+		Storage:         os.Getenv("PIPELINE_STORAGE"),
+		IDGen:           os.Getenv("PIPELINE_ID_GEN"),
+		MachineID:       os.Getenv("PIPELINE_MACHINE_ID"),
+		TaskStore:       os.Getenv("PIPELINE_TASK_STORE"),
+		DeadLetterStore: os.Getenv("PIPELINE_DEAD_LETTER_STORE"),
+		ResultCache:     os.Getenv("PIPELINE_RESULT_CACHE"),
+		HealthAddr:      os.Getenv("PIPELINE_HEALTH_ADDR"),
+		DrainTimeout:    DefaultDrainTimeout,
+	}
+
+	if c.HealthAddr == "" {
+		c.HealthAddr = DefaultHealthAddr
+	}
+
+	if drainTimeout := os.Getenv("PIPELINE_DRAIN_TIMEOUT"); drainTimeout != "" {
+		d, err := time.ParseDuration(drainTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PIPELINE_DRAIN_TIMEOUT: %w", err)
+		}
+		c.DrainTimeout = d
+	}
+
+	if c.MachineID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		c.MachineID = hostname
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Config) Validate() error {
+	missing := make([]string, 0)
+
+	for env, value := range map[string]string{
+		"PIPELINE_SCOPE":     c.Scope,
+		"PIPELINE_STAGE":     c.Stage,
+		"PIPELINE_EVENT_BUS": c.EventBus,
+		"PIPELINE_STORAGE":   c.Storage,
+	} {
+		if value == "" {
+			missing = append(missing, env)
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("missing %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// PipelineController builds PipelineController not scoped, with eventBus wrapped by wrapEventBus if not nil.
+func (c *Config) PipelineController(wrapEventBus func(eventBus pipeline.EventBus) pipeline.EventBus) (pipeline.PipelineController, error) {
+	pools := redisPools{}
+
+	eventBus, err := c.eventBus(pools)
+	if err != nil {
+		return nil, fmt.Errorf("event bus: %w", err)
+	}
+
+	if wrapEventBus != nil {
+		eventBus = wrapEventBus(eventBus)
+	}
+
+	storage, err := c.storage()
+	if err != nil {
+		return nil, fmt.Errorf("storage: %w", err)
+	}
+
+	idGen, err := c.idGen(pools)
+	if err != nil {
+		return nil, fmt.Errorf("id gen: %w", err)
+	}
+
+	options := make([]pipeline.PipelineControllerOption, 0)
+
+	if c.TaskStore != "" {
+		u, err := url.Parse(c.TaskStore)
+		if err != nil {
+			return nil, fmt.Errorf("task store: %w", err)
+		}
+
+		ttl := DefaultTaskTTL
+		if s := u.Query().Get("ttl"); s != "" {
+			if ttl, err = time.ParseDuration(s); err != nil {
+				return nil, fmt.Errorf("task store: invalid ttl: %w", err)
+			}
+		}
+
+		pool, err := pools.get(c.TaskStore)
+		if err != nil {
+			return nil, fmt.Errorf("task store: %w", err)
+		}
+
+		options = append(options, pipeline.WithTaskStore(taskstoreredis.NewRedisTaskStore(pool, ttl)))
+	}
+
+	if c.DeadLetterStore != "" {
+		pool, err := pools.get(c.DeadLetterStore)
+		if err != nil {
+			return nil, fmt.Errorf("dead letter store: %w", err)
+		}
+		options = append(options, pipeline.WithDeadLetterStore(deadletterredis.NewRedisDeadLetterStore(pool)))
+	}
+
+	if c.ResultCache != "" {
+		pool, err := pools.get(c.ResultCache)
+		if err != nil {
+			return nil, fmt.Errorf("result cache: %w", err)
+		}
+		options = append(options, pipeline.WithResultCache(resultcacheredis.NewRedisResultCache(pool)))
+	}
+
+	return pipeline.NewPipelineController(eventBus, storage, idGen, machineID(c.MachineID), options...), nil
+}
+
+func (c *Config) eventBus(pools redisPools) (pipeline.EventBus, error) {
+	u, err := url.Parse(c.EventBus)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := pools.get(c.EventBus)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "redis":
+		return eventbusredis.NewRedisEventBus(pool), nil
+	case "redisstream":
+		return redisstream.NewRedisStreamEventBus(pool), nil
+	}
+
+	return nil, fmt.Errorf("unsupported scheme %s", u.Scheme)
+}
+
+func (c *Config) storage() (pipeline.Storage, error) {
+	u, err := url.Parse(c.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "file":
+		if u.Path == "" {
+			return nil, errors.New("missing path")
+		}
+		return fs.NewFsStorage(afero.NewBasePathFs(afero.NewOsFs(), u.Path)), nil
+	case "s3", "s3s":
+		bucket := strings.Trim(u.Path, "/")
+		if bucket == "" {
+			return nil, errors.New("missing bucket")
+		}
+
+		client, err := s3util.NewS3(c.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		return s3.NewS3Storage(client, bucket)
+	}
+
+	return nil, fmt.Errorf("unsupported scheme %s", u.Scheme)
+}
+
+func (c *Config) idGen(pools redisPools) (pipeline.IDGen, error) {
+	if c.IDGen == "" {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(c.MachineID))
+		return snowflake.NewSnowflakeIDGen(h.Sum32() % (snowflake.MaxWorkerID + 1))
+	}
+
+	u, err := url.Parse(c.IDGen)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "snowflake":
+		workerID, err := strconv.ParseUint(u.Query().Get("worker"), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid worker: %w", err)
+		}
+		return snowflake.NewSnowflakeIDGen(uint32(workerID))
+	case "redis":
+		pool, err := pools.get(c.IDGen)
+		if err != nil {
+			return nil, err
+		}
+		return idgenredis.NewRedisIDGen(pool, u.Query().Get("key")), nil
+	}
+
+	return nil, fmt.Errorf("unsupported scheme %s", u.Scheme)
+}
+
+// redisPools shares pools of the same redis
+type redisPools map[string]*redis.Pool
+
+func (pools redisPools) get(uri string) (*redis.Pool, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	// same db of same host
+	key := u.User.String() + "@" + u.Host + "/" + u.Query().Get("db")
+
+	if pool, ok := pools[key]; ok {
+		return pool, nil
+	}
+
+	pool, err := redisutil.NewPool(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	pools[key] = pool
+
+	return pool, nil
+}
+
+type machineID string
+
+func (m machineID) MachineID() (string, error) {
+	return string(m), nil
+}
@@ -0,0 +1,190 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/querycap/pipeline/pipeline"
+	"github.com/sirupsen/logrus"
+)
+
+// Main serves the stage of container with handler, configured by envs (see Config),
+// exits once drained after SIGTERM or SIGINT.
+func Main(handler pipeline.OperatorHandlerFunc, options ...pipeline.ServeOperatorOption) {
+	c, err := ConfigFromEnv()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		s := <-sig
+		logrus.Infof("%s received, draining", s)
+		cancel()
+	}()
+
+	if err := Run(ctx, c, handler, options...); err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+// Run serves the stage with health endpoints, until ctx done and drained.
+func Run(ctx context.Context, c *Config, handler pipeline.OperatorHandlerFunc, options ...pipeline.ServeOperatorOption) error {
+	r, err := New(c)
+	if err != nil {
+		return err
+	}
+
+	var srv *http.Server
+
+	if c.HealthAddr != "" {
+		srv = &http.Server{Addr: c.HealthAddr, Handler: r}
+
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logrus.Errorf("health endpoints: %s", err)
+			}
+		}()
+	}
+
+	r.Serve(ctx, handler, options...)
+
+	if srv != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	}
+
+	return nil
+}
+
+func New(c *Config) (*Runtime, error) {
+	r := &Runtime{stage: c.Stage, drainTimeout: c.DrainTimeout}
+
+	pipelineController, err := c.PipelineController(func(eventBus pipeline.EventBus) pipeline.EventBus {
+		r.eventBus = &drainingEventBus{EventBus: eventBus}
+		return r.eventBus
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.pipelineController = pipelineController.WithScope(c.Scope)
+
+	return r, nil
+}
+
+// Runtime serves one stage of pipeline in container.
+type Runtime struct {
+	pipelineController pipeline.PipelineController
+	eventBus           *drainingEventBus
+	stage              string
+	drainTimeout       time.Duration
+	ready              int32
+}
+
+// Serve serves the stage until ctx done,
+// then stops taking tasks, and waits for handling ones done, no longer than drain timeout.
+func (r *Runtime) Serve(ctx context.Context, handler pipeline.OperatorHandlerFunc, options ...pipeline.ServeOperatorOption) {
+	sub := pipeline.ServeOperator(r.pipelineController, r.stage, handler, options...)
+
+	atomic.StoreInt32(&r.ready, 1)
+
+	logrus.Infof("serving %s of %s", r.stage, r.pipelineController.Scope())
+
+	<-ctx.Done()
+
+	atomic.StoreInt32(&r.ready, 0)
+
+	r.eventBus.stop()
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), r.drainTimeout)
+	defer cancel()
+
+	if err := r.eventBus.drain(drainCtx); err != nil {
+		logrus.Warnf("drain %s: %s, tasks handling will be redelivered", r.stage, err)
+	}
+
+	// unsubscribed after drained, since the lease of consumer kept tasks handling from redelivered to others
+	sub.Unsubscribe()
+}
+
+// ServeHTTP serves health endpoints,
+// /healthz for liveness, and /readyz reports 503 when not serving or draining.
+func (r *Runtime) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case "/healthz":
+		w.WriteHeader(http.StatusOK)
+	case "/readyz":
+		if atomic.LoadInt32(&r.ready) == 1 {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+var errDraining = errors.New("draining")
+
+// drainingEventBus nacks events picked once stopped, so they are redelivered to other replicas after redelivery delay.
+type drainingEventBus struct {
+	pipeline.EventBus
+
+	mu       sync.Mutex
+	draining bool
+	handling sync.WaitGroup
+}
+
+func (b *drainingEventBus) Subscribe(topic string, callback pipeline.Handler) pipeline.Subscription {
+	return b.EventBus.Subscribe(topic, func(ctx context.Context, data []byte) error {
+		b.mu.Lock()
+		if b.draining {
+			b.mu.Unlock()
+			return errDraining
+		}
+		b.handling.Add(1)
+		b.mu.Unlock()
+
+		defer b.handling.Done()
+
+		return callback(ctx, data)
+	})
+}
+
+// stop handling events picked after
+func (b *drainingEventBus) stop() {
+	b.mu.Lock()
+	b.draining = true
+	b.mu.Unlock()
+}
+
+// drain waits for events handling done
+func (b *drainingEventBus) drain(ctx context.Context) error {
+	done := make(chan struct{})
+
+	go func() {
+		b.handling.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,208 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-courier/semver"
+	. "github.com/onsi/gomega"
+	"github.com/querycap/pipeline/pipeline"
+	"github.com/querycap/pipeline/spec"
+)
+
+func TestConfigFromEnv(t *testing.T) {
+	envs := map[string]string{
+		"PIPELINE_SCOPE":         "p/x:1.0.0/1",
+		"PIPELINE_STAGE":         "upper",
+		"PIPELINE_EVENT_BUS":     "redis://127.0.0.1:6379",
+		"PIPELINE_STORAGE":       "file:///tmp/pipeline",
+		"PIPELINE_MACHINE_ID":    "m1",
+		"PIPELINE_DRAIN_TIMEOUT": "5s",
+	}
+
+	for k, v := range envs {
+		_ = os.Setenv(k, v)
+	}
+	defer func() {
+		for k := range envs {
+			_ = os.Unsetenv(k)
+		}
+	}()
+
+	c, err := ConfigFromEnv()
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(c).To(Equal(&Config{
+		Scope:        "p/x:1.0.0/1",
+		Stage:        "upper",
+		EventBus:     "redis://127.0.0.1:6379",
+		Storage:      "file:///tmp/pipeline",
+		MachineID:    "m1",
+		HealthAddr:   DefaultHealthAddr,
+		DrainTimeout: 5 * time.Second,
+	}))
+
+	_ = os.Unsetenv("PIPELINE_STAGE")
+	_ = os.Unsetenv("PIPELINE_STORAGE")
+
+	_, err = ConfigFromEnv()
+	NewWithT(t).Expect(err).To(MatchError("missing PIPELINE_STAGE, PIPELINE_STORAGE"))
+}
+
+// operatorMgr serves stages by runtimes in process, as containers do
+type operatorMgr struct {
+	ctx      context.Context
+	conf     Config
+	handlers map[string]pipeline.OperatorHandlerFunc
+	runtimes chan *Runtime
+}
+
+func (m *operatorMgr) Up(scope string, stage string, step spec.Stage, replicas int32) error {
+	c := m.conf
+	c.Scope, c.Stage = scope, stage
+
+	r, err := New(&c)
+	if err != nil {
+		return err
+	}
+
+	go r.Serve(m.ctx, m.handlers[step.Uses.Name])
+
+	m.runtimes <- r
+
+	return nil
+}
+
+func (m *operatorMgr) Destroy(scope string, stage string) error {
+	return nil
+}
+
+func TestRuntime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	NewWithT(t).Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+
+	conf := Config{
+		EventBus:     "redis://127.0.0.1:6379",
+		Storage:      "file://" + dir,
+		IDGen:        "snowflake://?worker=1",
+		MachineID:    "test",
+		DrainTimeout: 5 * time.Second,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handling, release := make(chan struct{}, 2), make(chan struct{})
+	calls := int32(0)
+
+	upper := func(t pipeline.Transfer) error {
+		atomic.AddInt32(&calls, 1)
+		handling <- struct{}{}
+		<-release
+
+		return pipeline.ReadNext(t, func(r io.Reader) error {
+			data, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			return t.Put(bytes.NewBuffer(bytes.ToUpper(data)))
+		})
+	}
+
+	mgr := &operatorMgr{
+		ctx:      ctx,
+		conf:     conf,
+		runtimes: make(chan *Runtime, 1),
+		handlers: map[string]pipeline.OperatorHandlerFunc{
+			"upper": upper,
+		},
+	}
+
+	pc, err := conf.PipelineController(nil)
+	NewWithT(t).Expect(err).To(BeNil())
+
+	p, err := pipeline.NewPipelineMgr(mgr, pc).NewPipeline(&spec.Pipeline{
+		Name:    "runtime",
+		Version: *semver.MustParseVersion("1.0.0"),
+		PipelineFlow: spec.PipelineFlow{
+			Starts: "upper",
+			Ends:   "upper",
+			Stages: map[string]spec.Stage{
+				"upper": {Uses: *spec.NewRefOperator("upper", *semver.MustParseVersion("1.0.0"))},
+			},
+		},
+	})
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(p.Start()).To(BeNil())
+	defer p.Stop()
+
+	r := <-mgr.runtimes
+
+	status := func(path string) int {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	NewWithT(t).Eventually(func() int { return status("/readyz") }).Should(Equal(http.StatusOK))
+	NewWithT(t).Expect(status("/healthz")).To(Equal(http.StatusOK))
+
+	result, err := p.Next(context.Background(), bytes.NewBufferString("hello"))
+	NewWithT(t).Expect(err).To(BeNil())
+
+	<-handling
+
+	// other replica, which should not take the task handling while draining
+	otherCtx, stopOther := context.WithCancel(context.Background())
+	defer stopOther()
+
+	otherConf := conf
+	otherConf.Scope, otherConf.Stage = p.Scope(), "upper"
+
+	other, err := New(&otherConf)
+	NewWithT(t).Expect(err).To(BeNil())
+	go other.Serve(otherCtx, upper)
+
+	NewWithT(t).Eventually(func() int {
+		rec := httptest.NewRecorder()
+		other.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return rec.Code
+	}).Should(Equal(http.StatusOK))
+
+	// terminating while handling
+	cancel()
+
+	NewWithT(t).Eventually(func() int { return status("/readyz") }).Should(Equal(http.StatusServiceUnavailable))
+
+	close(release)
+
+	select {
+	case <-result.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("task not drained")
+	}
+
+	NewWithT(t).Expect(result.Err()).To(BeNil())
+
+	buf := bytes.NewBuffer(nil)
+	for result.Scan() {
+		NewWithT(t).Expect(pipeline.ReadNext(result, func(r io.Reader) error {
+			_, err := io.Copy(buf, r)
+			return err
+		})).To(BeNil())
+	}
+
+	NewWithT(t).Expect(buf.String()).To(Equal("HELLO"))
+
+	NewWithT(t).Consistently(func() int32 {
+		return atomic.LoadInt32(&calls)
+	}, 300*time.Millisecond).Should(Equal(int32(1)))
+}
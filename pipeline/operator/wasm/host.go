@@ -0,0 +1,194 @@
+package wasm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/querycap/pipeline/pipeline"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// HostModule imported by operator modules, functions return negative when failed:
+//
+//	scan() i32                                              1 when more inputs, else 0
+//	next() i32                                              opens next input to read
+//	read(ptr i32, len i32) i32                              reads input opened to memory, returns bytes read, 0 when EOF
+//	put(ptr i32, len i32, contentTypePtr i32, contentTypeLen i32) i32   puts output with content type
+//	fail(ptr i32, len i32)                                  sets message of error returned from handle
+//
+// Operator modules export memory, and handle() i32 called for each task, non-zero returned as ExitError.
+const HostModule = "pipeline"
+
+// ExitError of handle returned non-zero, with message set by fail
+type ExitError struct {
+	ExitCode uint32
+	Message  string
+}
+
+func (e *ExitError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("exit code %d", e.ExitCode)
+	}
+	return fmt.Sprintf("exit code %d: %s", e.ExitCode, e.Message)
+}
+
+type callKey struct{}
+
+// call of handle, host functions bound to transfer of task by context
+type call struct {
+	t       pipeline.Transfer
+	input   io.ReadCloser
+	err     error
+	message string
+}
+
+func (c *call) close() {
+	if c.input != nil {
+		_ = c.input.Close()
+		c.input = nil
+	}
+}
+
+func (c *call) fail(err error) int32 {
+	if c.err == nil {
+		c.err = err
+	}
+	return -1
+}
+
+func callFrom(ctx context.Context) *call {
+	return ctx.Value(callKey{}).(*call)
+}
+
+func instantiateHostModule(ctx context.Context, r wazero.Runtime) error {
+	_, err := r.NewHostModuleBuilder(HostModule).
+		NewFunctionBuilder().WithFunc(func(ctx context.Context) int32 {
+		if callFrom(ctx).t.Scan() {
+			return 1
+		}
+		return 0
+	}).Export("scan").
+		NewFunctionBuilder().WithFunc(func(ctx context.Context) int32 {
+		c := callFrom(ctx)
+		c.close()
+
+		input, err := c.t.Next()
+		if err != nil {
+			return c.fail(err)
+		}
+		c.input = input
+		return 0
+	}).Export("next").
+		NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, ptr uint32, size uint32) int32 {
+		c := callFrom(ctx)
+		if c.input == nil {
+			return c.fail(errors.New("read before next"))
+		}
+
+		buf, ok := m.Memory().Read(ptr, size)
+		if !ok {
+			return c.fail(fmt.Errorf("read out of memory range (%d, %d)", ptr, size))
+		}
+
+		for {
+			n, err := c.input.Read(buf)
+			if n > 0 {
+				return int32(n)
+			}
+			if err == io.EOF {
+				return 0
+			}
+			if err != nil {
+				return c.fail(err)
+			}
+		}
+	}).Export("read").
+		NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, ptr uint32, size uint32, contentTypePtr uint32, contentTypeSize uint32) int32 {
+		c := callFrom(ctx)
+
+		data, ok := m.Memory().Read(ptr, size)
+		if !ok {
+			return c.fail(fmt.Errorf("put out of memory range (%d, %d)", ptr, size))
+		}
+
+		contentType, ok := m.Memory().Read(contentTypePtr, contentTypeSize)
+		if !ok {
+			return c.fail(fmt.Errorf("content type out of memory range (%d, %d)", contentTypePtr, contentTypeSize))
+		}
+
+		// copied, since memory may be changed after returned
+		output := append([]byte{}, data...)
+
+		if err := c.t.Put(pipeline.WithContentType(string(contentType))(pipeline.WriteTo(func(w io.Writer) (int64, error) {
+			n, err := w.Write(output)
+			return int64(n), err
+		}))); err != nil {
+			return c.fail(err)
+		}
+		return 0
+	}).Export("put").
+		NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, ptr uint32, size uint32) {
+		if message, ok := m.Memory().Read(ptr, size); ok {
+			callFrom(ctx).message = string(message)
+		}
+	}).Export("fail").
+		Instantiate(ctx)
+
+	return err
+}
+
+func (m *WasmOperatorMgr) handle(compiled wazero.CompiledModule) pipeline.OperatorHandlerFunc {
+	return func(t pipeline.Transfer) error {
+		ctx := t.Context()
+
+		if m.timeout > 0 {
+			c, cancel := context.WithTimeout(ctx, m.timeout)
+			defer cancel()
+			ctx = c
+		}
+
+		c := &call{t: t}
+		defer c.close()
+
+		ctx = context.WithValue(ctx, callKey{}, c)
+
+		// instance of each task, so nothing shared between tasks
+		mod, err := m.runtime.InstantiateModule(ctx, compiled, m.moduleConfig)
+		if err != nil {
+			return err
+		}
+		defer mod.Close(context.Background())
+
+		handle := mod.ExportedFunction("handle")
+		if handle == nil {
+			return errors.New("missing exported function handle")
+		}
+
+		results, err := handle.Call(ctx)
+		if err != nil {
+			if exitErr, ok := err.(*sys.ExitError); ok {
+				switch exitErr.ExitCode() {
+				case sys.ExitCodeDeadlineExceeded:
+					return context.DeadlineExceeded
+				case sys.ExitCodeContextCanceled:
+					return context.Canceled
+				}
+			}
+			return err
+		}
+
+		if c.err != nil {
+			return c.err
+		}
+
+		if len(results) > 0 && uint32(results[0]) != 0 {
+			return &ExitError{ExitCode: uint32(results[0]), Message: c.message}
+		}
+
+		return nil
+	}
+}
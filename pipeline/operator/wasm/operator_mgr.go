@@ -0,0 +1,251 @@
+package wasm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/querycap/pipeline/pipeline"
+	"github.com/querycap/pipeline/spec"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+const (
+	// DefaultMemoryLimitPages of 64KiB, 16MiB
+	DefaultMemoryLimitPages = 256
+	// DefaultTimeout of each task
+	DefaultTimeout = time.Minute
+)
+
+// ModuleLoader loads .wasm module of operator
+type ModuleLoader = func(ctx context.Context, ref spec.Ref) (io.ReadCloser, error)
+
+// FsModuleLoader loads module at <root>/<name>/<version>.wasm
+func FsModuleLoader(root string) ModuleLoader {
+	return func(ctx context.Context, ref spec.Ref) (io.ReadCloser, error) {
+		return os.Open(filepath.Join(root, filepath.FromSlash(ref.Name), ref.Version.String()+".wasm"))
+	}
+}
+
+// StorageModuleLoader loads module at <prefix>/<name>/<version>.wasm of storage
+func StorageModuleLoader(storage pipeline.Storage, prefix string) ModuleLoader {
+	return func(ctx context.Context, ref spec.Ref) (io.ReadCloser, error) {
+		return storage.Read(ctx, path.Join(prefix, ref.Name, ref.Version.String()+".wasm"))
+	}
+}
+
+type Option = func(m *WasmOperatorMgr)
+
+// WithMemoryLimitPages to limit memory of each instance, in pages of 64KiB
+func WithMemoryLimitPages(pages uint32) Option {
+	return func(m *WasmOperatorMgr) {
+		m.memoryLimitPages = pages
+	}
+}
+
+// WithTimeout to limit wall time of each task, including host functions blocked on reading inputs and putting outputs,
+// 0 for no limit besides the timeout of stage.
+func WithTimeout(timeout time.Duration) Option {
+	return func(m *WasmOperatorMgr) {
+		m.timeout = timeout
+	}
+}
+
+// WithOutput for stdout and stderr of wasi, discarded by default
+func WithOutput(stdout io.Writer, stderr io.Writer) Option {
+	return func(m *WasmOperatorMgr) {
+		m.stdout, m.stderr = stdout, stderr
+	}
+}
+
+// NewWasmOperatorMgr runs operators as webassembly modules in process, sandboxed by pure go runtime.
+//
+// Modules not registered are loaded by loadModule, which could be nil.
+// Wasi imported without filesystem, envs or args, so modules built for wasi reactor (exports _initialize) work too.
+func NewWasmOperatorMgr(pipelineController pipeline.PipelineController, loadModule ModuleLoader, options ...Option) (*WasmOperatorMgr, error) {
+	m := &WasmOperatorMgr{
+		pipelineController: pipelineController,
+		loadModule:         loadModule,
+		memoryLimitPages:   DefaultMemoryLimitPages,
+		timeout:            DefaultTimeout,
+		stdout:             ioutil.Discard,
+		stderr:             ioutil.Discard,
+		operators:          map[string]*operator{},
+		instances:          map[string][]pipeline.Subscription{},
+	}
+
+	for _, option := range options {
+		option(m)
+	}
+
+	ctx := context.Background()
+
+	m.runtime = wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(m.memoryLimitPages).
+		WithCloseOnContextDone(true),
+	)
+
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, m.runtime); err != nil {
+		_ = m.runtime.Close(ctx)
+		return nil, err
+	}
+
+	if err := instantiateHostModule(ctx, m.runtime); err != nil {
+		_ = m.runtime.Close(ctx)
+		return nil, err
+	}
+
+	m.moduleConfig = wazero.NewModuleConfig().
+		// anonymous for instances of each task
+		WithName("").
+		WithStartFunctions("_initialize").
+		WithStdout(m.stdout).
+		WithStderr(m.stderr)
+
+	return m, nil
+}
+
+var _ pipeline.OperatorMgr = (*WasmOperatorMgr)(nil)
+
+type operator struct {
+	compiled     wazero.CompiledModule
+	operatorMeta spec.OperatorMeta
+}
+
+type WasmOperatorMgr struct {
+	pipelineController pipeline.PipelineController
+	loadModule         ModuleLoader
+	memoryLimitPages   uint32
+	timeout            time.Duration
+	stdout             io.Writer
+	stderr             io.Writer
+
+	runtime      wazero.Runtime
+	moduleConfig wazero.ModuleConfig
+
+	mu        sync.Mutex
+	operators map[string]*operator
+
+	rw        sync.Mutex
+	instances map[string][]pipeline.Subscription
+}
+
+// Register module of operator, schemas of operator taken when ref is *spec.Operator or with OperatorMeta.
+func (m *WasmOperatorMgr) Register(ref pipeline.WithRefID, module []byte) error {
+	compiled, err := m.runtime.CompileModule(context.Background(), module)
+	if err != nil {
+		return fmt.Errorf("compile %s: %w", ref.RefID(), err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.operators[ref.RefID()] = &operator{compiled: compiled, operatorMeta: pipeline.OperatorMetaFrom(ref)}
+	return nil
+}
+
+// operator registered, or loaded and compiled once,
+// loaded without lock, so stages of other operators not blocked by slow loaders.
+func (m *WasmOperatorMgr) operator(ref spec.Ref) (*operator, error) {
+	m.mu.Lock()
+	o, ok := m.operators[ref.RefID()]
+	m.mu.Unlock()
+
+	if ok {
+		return o, nil
+	}
+
+	if m.loadModule == nil {
+		return nil, fmt.Errorf("%s not found", ref)
+	}
+
+	ctx := context.Background()
+
+	r, err := m.loadModule(ctx, ref)
+	if err != nil {
+		return nil, fmt.Errorf("load %s: %w", ref, err)
+	}
+	defer r.Close()
+
+	module, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("load %s: %w", ref, err)
+	}
+
+	compiled, err := m.runtime.CompileModule(ctx, module)
+	if err != nil {
+		return nil, fmt.Errorf("compile %s: %w", ref, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// registered or loaded by others meanwhile
+	if o, ok := m.operators[ref.RefID()]; ok {
+		_ = compiled.Close(ctx)
+		return o, nil
+	}
+
+	o = &operator{compiled: compiled}
+	m.operators[ref.RefID()] = o
+	return o, nil
+}
+
+// Up serves the stage in goroutines, one subscription of event bus for each replica,
+// replicas of stage already up will be scaled.
+func (m *WasmOperatorMgr) Up(scope string, name string, step spec.Stage, replicas int32) error {
+	o, err := m.operator(step.Uses)
+	if err != nil {
+		return err
+	}
+
+	if replicas < 1 {
+		replicas = 1
+	}
+
+	m.rw.Lock()
+	defer m.rw.Unlock()
+
+	instanceID := scope + "/" + name
+
+	subscriptions := m.instances[instanceID]
+
+	for i := int32(len(subscriptions)); i < replicas; i++ {
+		subscriptions = append(subscriptions, pipeline.ServeOperator(m.pipelineController.WithScope(scope), name, m.handle(o.compiled), pipeline.WithSchemas(o.operatorMeta)))
+	}
+
+	for int32(len(subscriptions)) > replicas {
+		subscriptions[len(subscriptions)-1].Unsubscribe()
+		subscriptions = subscriptions[:len(subscriptions)-1]
+	}
+
+	m.instances[instanceID] = subscriptions
+	return nil
+}
+
+func (m *WasmOperatorMgr) Destroy(scope string, name string) error {
+	m.rw.Lock()
+	defer m.rw.Unlock()
+
+	instanceID := scope + "/" + name
+
+	for _, subscription := range m.instances[instanceID] {
+		subscription.Unsubscribe()
+	}
+
+	delete(m.instances, instanceID)
+
+	return nil
+}
+
+// Close releases the runtime and compiled modules, stages should be destroyed before.
+func (m *WasmOperatorMgr) Close(ctx context.Context) error {
+	return m.runtime.Close(ctx)
+}
@@ -0,0 +1,200 @@
+package wasm_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-courier/semver"
+	. "github.com/onsi/gomega"
+	"github.com/querycap/pipeline/pipeline"
+	"github.com/querycap/pipeline/pipeline/eventbus/mem"
+	"github.com/querycap/pipeline/pipeline/operator/wasm"
+	"github.com/querycap/pipeline/pipeline/pipelinetest"
+	"github.com/querycap/pipeline/pipeline/storage/fs"
+	"github.com/querycap/pipeline/spec"
+	"github.com/spf13/afero"
+)
+
+// concurrentReadStorage fails reading inputs of stages, unless they are read concurrently by two tasks
+type concurrentReadStorage struct {
+	pipeline.Storage
+	reading    int32
+	concurrent chan struct{}
+}
+
+func (s *concurrentReadStorage) Read(ctx context.Context, path string) (io.ReadCloser, error) {
+	if strings.Contains(path, "/stages/$input/") {
+		if atomic.AddInt32(&s.reading, 1) == 2 {
+			close(s.concurrent)
+		}
+
+		select {
+		case <-s.concurrent:
+		case <-time.After(2 * time.Second):
+			return nil, errors.New("not read concurrently")
+		}
+	}
+	return s.Storage.Read(ctx, path)
+}
+
+// testdataModuleLoader loads testdata/<name>.wasm, see testdata/<name>.wat for sources
+func testdataModuleLoader(ctx context.Context, ref spec.Ref) (io.ReadCloser, error) {
+	return os.Open(filepath.Join("testdata", ref.Name+".wasm"))
+}
+
+func runStage(t *testing.T, operatorMgr pipeline.OperatorMgr, pc pipeline.PipelineController, name string, input string) (string, error) {
+	p, err := pipeline.NewPipelineMgr(operatorMgr, pc).NewPipeline(&spec.Pipeline{
+		Name:    name,
+		Version: *semver.MustParseVersion("1.0.0"),
+		PipelineFlow: spec.PipelineFlow{
+			Starts: "a",
+			Ends:   "a",
+			Stages: map[string]spec.Stage{
+				"a": {Uses: pipelinetest.Ref(name)},
+			},
+		},
+	})
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(p.Start()).To(BeNil())
+	defer p.Stop()
+
+	output, err := pipelinetest.Run(p, input)
+	return string(output), err
+}
+
+func TestWasmOperatorMgr(t *testing.T) {
+	t.Run("loaded", func(t *testing.T) {
+		pc := pipelinetest.NewPipelineController()
+
+		operatorMgr, err := wasm.NewWasmOperatorMgr(pc, testdataModuleLoader)
+		NewWithT(t).Expect(err).To(BeNil())
+		defer operatorMgr.Close(context.Background())
+
+		output, err := runStage(t, operatorMgr, pc, "upper", "input:")
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(output).To(Equal("INPUT:"))
+
+		_, err = runStage(t, operatorMgr, pc, "upper", "")
+		NewWithT(t).Expect(err).NotTo(BeNil())
+		NewWithT(t).Expect(err.Error()).To(ContainSubstring("exit code 2: empty"))
+	})
+
+	t.Run("registered", func(t *testing.T) {
+		pc := pipelinetest.NewPipelineController()
+
+		operatorMgr, err := wasm.NewWasmOperatorMgr(pc, nil)
+		NewWithT(t).Expect(err).To(BeNil())
+		defer operatorMgr.Close(context.Background())
+
+		module, _ := ioutil.ReadFile("testdata/upper.wasm")
+		NewWithT(t).Expect(operatorMgr.Register(pipelinetest.Ref("upper"), module)).To(BeNil())
+
+		output, err := runStage(t, operatorMgr, pc, "upper", "registered")
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(output).To(Equal("REGISTERED"))
+
+		NewWithT(t).Expect(operatorMgr.Up("test", "a", spec.Stage{Uses: pipelinetest.Ref("not-found")}, 1)).NotTo(BeNil())
+	})
+
+	t.Run("loading not blocking others", func(t *testing.T) {
+		pc := pipelinetest.NewPipelineController()
+
+		loading, release := make(chan struct{}), make(chan struct{})
+
+		operatorMgr, err := wasm.NewWasmOperatorMgr(pc, func(ctx context.Context, ref spec.Ref) (io.ReadCloser, error) {
+			if ref.Name == "slow" {
+				close(loading)
+				<-release
+			}
+			return testdataModuleLoader(ctx, ref)
+		})
+		NewWithT(t).Expect(err).To(BeNil())
+		defer operatorMgr.Close(context.Background())
+
+		slowErr := make(chan error, 1)
+		go func() {
+			slowErr <- operatorMgr.Up("test", "slow", spec.Stage{Uses: pipelinetest.Ref("slow")}, 1)
+		}()
+
+		<-loading
+
+		output, err := runStage(t, operatorMgr, pc, "upper", "input:")
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(output).To(Equal("INPUT:"))
+
+		close(release)
+		NewWithT(t).Expect(<-slowErr).NotTo(BeNil())
+	})
+
+	t.Run("replicas", func(t *testing.T) {
+		s := &concurrentReadStorage{Storage: fs.NewFsStorage(afero.NewMemMapFs()), concurrent: make(chan struct{})}
+		pc := pipeline.NewPipelineController(mem.NewMemEventBus(), s, &pipelinetest.IDGen{}, pipelinetest.MachineIdentifier("test"))
+
+		operatorMgr, err := wasm.NewWasmOperatorMgr(pc, testdataModuleLoader)
+		NewWithT(t).Expect(err).To(BeNil())
+		defer operatorMgr.Close(context.Background())
+
+		p, err := pipeline.NewPipelineMgr(operatorMgr, pc).NewPipeline(&spec.Pipeline{
+			Name:    "replicas",
+			Version: *semver.MustParseVersion("1.0.0"),
+			PipelineFlow: spec.PipelineFlow{
+				Starts: "a",
+				Ends:   "a",
+				Stages: map[string]spec.Stage{
+					"a": {Uses: pipelinetest.Ref("upper"), Scaling: spec.Scaling{Replicas: 2}},
+				},
+			},
+		})
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(p.Start()).To(BeNil())
+		defer p.Stop()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		results := make([]pipeline.Result, 0, 2)
+		for _, input := range []string{"a", "b"} {
+			r, err := p.Next(ctx, bytes.NewBufferString(input))
+			NewWithT(t).Expect(err).To(BeNil())
+			results = append(results, r)
+		}
+
+		for _, r := range results {
+			<-r.Done()
+			NewWithT(t).Expect(r.Err()).To(BeNil())
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		pc := pipelinetest.NewPipelineController()
+
+		operatorMgr, err := wasm.NewWasmOperatorMgr(pc, testdataModuleLoader, wasm.WithTimeout(100*time.Millisecond))
+		NewWithT(t).Expect(err).To(BeNil())
+		defer operatorMgr.Close(context.Background())
+
+		_, err = runStage(t, operatorMgr, pc, "spin", "")
+		NewWithT(t).Expect(err).NotTo(BeNil())
+		NewWithT(t).Expect(err.Error()).To(ContainSubstring(context.DeadlineExceeded.Error()))
+	})
+
+	t.Run("memory limit", func(t *testing.T) {
+		pc := pipelinetest.NewPipelineController()
+
+		operatorMgr, err := wasm.NewWasmOperatorMgr(pc, testdataModuleLoader, wasm.WithMemoryLimitPages(4))
+		NewWithT(t).Expect(err).To(BeNil())
+		defer operatorMgr.Close(context.Background())
+
+		_, err = runStage(t, operatorMgr, pc, "grow", "")
+		NewWithT(t).Expect(err).NotTo(BeNil())
+		NewWithT(t).Expect(err.Error()).To(ContainSubstring("exit code 3"))
+	})
+}
@@ -0,0 +1,8 @@
+;; grow fails with 3 when 16 more pages not allowed, for limit of memory.
+(module
+  (memory (export "memory") 1)
+
+  (func (export "handle") (result i32)
+    (if (i32.eq (memory.grow (i32.const 16)) (i32.const -1))
+      (then (return (i32.const 3))))
+    (i32.const 0)))
@@ -0,0 +1,7 @@
+;; spin never returns, for limit of cpu time.
+(module
+  (memory (export "memory") 1)
+
+  (func (export "handle") (result i32)
+    (loop $forever (br $forever))
+    (unreachable)))
@@ -0,0 +1,46 @@
+;; upper puts all inputs joined and upper cased as text/plain, fails when inputs empty.
+(module
+  (import "pipeline" "scan" (func $scan (result i32)))
+  (import "pipeline" "next" (func $next (result i32)))
+  (import "pipeline" "read" (func $read (param i32 i32) (result i32)))
+  (import "pipeline" "put" (func $put (param i32 i32 i32 i32) (result i32)))
+  (import "pipeline" "fail" (func $fail (param i32 i32)))
+
+  (memory (export "memory") 1)
+
+  (data (i32.const 0) "text/plain")
+  (data (i32.const 16) "empty")
+
+  (func (export "handle") (result i32)
+    (local $n i32) (local $total i32) (local $i i32) (local $c i32) (local $a i32)
+
+    ;; inputs read to 1024
+    (block $done
+      (loop $inputs
+        (br_if $done (i32.eqz (call $scan)))
+        (if (call $next) (then (return (i32.const 1))))
+        (loop $reads
+          (local.set $n (call $read (i32.add (i32.const 1024) (local.get $total)) (i32.const 4096)))
+          (if (i32.gt_s (local.get $n) (i32.const 0))
+            (then
+              (local.set $total (i32.add (local.get $total) (local.get $n)))
+              (br $reads))))
+        (if (i32.lt_s (local.get $n) (i32.const 0)) (then (return (i32.const 1))))
+        (br $inputs)))
+
+    (if (i32.eqz (local.get $total))
+      (then
+        (call $fail (i32.const 16) (i32.const 5))
+        (return (i32.const 2))))
+
+    (block $end
+      (loop $chars
+        (br_if $end (i32.ge_u (local.get $i) (local.get $total)))
+        (local.set $a (i32.add (local.get $i) (i32.const 1024)))
+        (local.set $c (i32.load8_u (local.get $a)))
+        (if (i32.and (i32.ge_u (local.get $c) (i32.const 97)) (i32.le_u (local.get $c) (i32.const 122)))
+          (then (i32.store8 (local.get $a) (i32.sub (local.get $c) (i32.const 32)))))
+        (local.set $i (i32.add (local.get $i) (i32.const 1)))
+        (br $chars)))
+
+    (call $put (i32.const 1024) (local.get $total) (i32.const 0) (i32.const 10))))
@@ -23,4 +23,7 @@ type Transfer interface {
 	Context() context.Context
 }
 
+// OperatorHandlerFunc handles task of stage,
+// should honour d.Context() and return once it done, since the stage timed out or task deadline exceeded.
+// Next and Put fail after that, and the replica picks no more tasks until the handler returned.
 type OperatorHandlerFunc = func(d Transfer) error
@@ -7,8 +7,13 @@ type WithOperatorMeta interface {
 }
 
 func OperatorMetaFrom(v interface{}) spec.OperatorMeta {
-	if m, ok := v.(WithOperatorMeta); ok {
+	switch m := v.(type) {
+	case WithOperatorMeta:
 		return m.OperatorMeta()
+	case spec.Operator:
+		return m.OperatorMeta
+	case *spec.Operator:
+		return m.OperatorMeta
 	}
 	return spec.OperatorMeta{}
 }
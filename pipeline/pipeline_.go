@@ -1,68 +1,310 @@
 package pipeline
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"sync"
+	"time"
 
 	"github.com/querycap/pipeline/spec"
 )
 
-func NewPipelineMgr(operatorMgr OperatorMgr, c PipelineController) *PipelineMgr {
-	return &PipelineMgr{operatorMgr: operatorMgr, pipelineController: c}
+func NewPipelineMgr(operatorMgr OperatorMgr, c PipelineController, options ...PipelineMgrOption) *PipelineMgr {
+	p := &PipelineMgr{
+		operatorMgr:        operatorMgr,
+		pipelineController: c,
+		autoscaleInterval:  DefaultAutoscaleInterval,
+		tasksPerReplica:    DefaultTasksPerReplica,
+	}
+	for _, option := range options {
+		option(p)
+	}
+	return p
+}
+
+type PipelineMgrOption = func(p *PipelineMgr)
+
+// WithRetentionPolicy to delete task artifacts of pipelines
+func WithRetentionPolicy(policy RetentionPolicy) PipelineMgrOption {
+	return func(p *PipelineMgr) {
+		p.retentionPolicy = &policy
+	}
+}
+
+// WithAutoscaling to scale stages with replicas range every interval,
+// by desired replicas of queue depth divided by tasksPerReplica.
+func WithAutoscaling(interval time.Duration, tasksPerReplica int) PipelineMgrOption {
+	return func(p *PipelineMgr) {
+		p.autoscaleInterval = interval
+		p.tasksPerReplica = tasksPerReplica
+	}
 }
 
 type PipelineMgr struct {
 	operatorMgr        OperatorMgr
 	pipelineController PipelineController
+	retentionPolicy    *RetentionPolicy
+	autoscaleInterval  time.Duration
+	tasksPerReplica    int
 }
 
+// path of pipeline spec in scope of pipeline
+const pipelineSpecPath = "pipeline.json"
+
 func (p *PipelineMgr) NewPipeline(spec *spec.Pipeline) (*Pipeline, error) {
 	id, err := p.pipelineController.ID()
 	if err != nil {
 		return nil, err
 	}
 
+	pipeline, err := p.pipeline(spec, id)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(specToStore(spec))
+	if err != nil {
+		return nil, err
+	}
+
+	// stored for restoring
+	if err := pipeline.mgr.pipelineController.Put(context.Background(), pipelineSpecPath, WithContentType("application/json")(bytes.NewBuffer(data))); err != nil {
+		return nil, err
+	}
+
+	return pipeline, nil
+}
+
+// specToStore drops headers of http endpoints in stages, which may carry credentials
+func specToStore(s *spec.Pipeline) *spec.Pipeline {
+	stored := *s
+	stored.Stages = make(map[string]spec.Stage, len(s.Stages))
+
+	for name, stage := range s.Stages {
+		if stage.HTTP != nil && len(stage.HTTP.Headers) > 0 {
+			endpoint := *stage.HTTP
+			endpoint.Headers = nil
+			stage.HTTP = &endpoint
+		}
+		stored.Stages[name] = stage
+	}
+
+	return &stored
+}
+
+// RestorePipeline rebuilds the Pipeline created before by its stored spec,
+// for attaching tasks in-flight after restarted.
+func (p *PipelineMgr) RestorePipeline(ctx context.Context, refID string, id uint64) (*Pipeline, error) {
+	s := StorageWithBasePath(p.pipelineController, PipelineScope(refID, id))
+
+	f, err := s.Read(ctx, pipelineSpecPath)
+	if err != nil {
+		return nil, fmt.Errorf("read spec of pipeline %s failed: %w", PipelineScope(refID, id), err)
+	}
+	defer f.Close()
+
+	pipelineSpec := &spec.Pipeline{}
+	if err := json.NewDecoder(f).Decode(pipelineSpec); err != nil {
+		return nil, err
+	}
+
+	if pipelineSpec.RefID() != refID {
+		return nil, fmt.Errorf("stored pipeline %s not match %s", pipelineSpec.RefID(), refID)
+	}
+
+	return p.pipeline(pipelineSpec, id)
+}
+
+func (p *PipelineMgr) pipeline(spec *spec.Pipeline, id uint64) (*Pipeline, error) {
 	taskMeta, err := TaskMetaFromPipeline(spec, id)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Pipeline{
+	pipeline := &Pipeline{
 		id:       id,
 		taskMeta: taskMeta,
 		spec:     spec,
 		mgr: &PipelineMgr{
 			operatorMgr:        p.operatorMgr,
 			pipelineController: p.pipelineController.WithScope(taskMeta.Scope),
+			retentionPolicy:    p.retentionPolicy,
+			autoscaleInterval:  p.autoscaleInterval,
+			tasksPerReplica:    p.tasksPerReplica,
 		},
-	}, nil
+	}
+
+	if p.retentionPolicy != nil {
+		pipeline.retention = NewRetention(pipeline.mgr.pipelineController, taskMeta, *p.retentionPolicy)
+	}
+
+	return pipeline, nil
+}
+
+func (p *PipelineMgr) GetTask(ctx context.Context, taskID uint64) (*TaskState, error) {
+	taskStore := p.pipelineController.TaskStore()
+	if taskStore == nil {
+		return nil, ErrTaskNotFound
+	}
+
+	events, err := taskStore.Events(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	return TaskStateFromEvents(events)
+}
+
+func (p *PipelineMgr) ListTasks(ctx context.Context, filter TaskFilter) ([]*TaskState, error) {
+	taskStore := p.pipelineController.TaskStore()
+	if taskStore == nil {
+		return nil, nil
+	}
+
+	taskIDs, err := taskStore.TaskIDs(ctx, filter.Scope)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*TaskState, 0)
+
+	for _, taskID := range taskIDs {
+		state, err := p.GetTask(ctx, taskID)
+		if err != nil {
+			if err == ErrTaskNotFound {
+				continue
+			}
+			return nil, err
+		}
+
+		if !filter.Match(state) {
+			continue
+		}
+
+		list = append(list, state)
+
+		if filter.Limit > 0 && len(list) >= filter.Limit {
+			break
+		}
+	}
+
+	return list, nil
 }
 
 type Pipeline struct {
-	id       uint64
-	taskMeta *TaskMeta
-	spec     *spec.Pipeline
-	mgr      *PipelineMgr
-	results  sync.Map
+	id        uint64
+	taskMeta  *TaskMeta
+	spec      *spec.Pipeline
+	mgr       *PipelineMgr
+	results   sync.Map
+	retention *Retention
+
+	stopAutoscaler func()
+	stopRetention  func()
+}
+
+func (p *Pipeline) ID() uint64 {
+	return p.id
+}
+
+func (p *Pipeline) Spec() *spec.Pipeline {
+	return p.spec
+}
+
+func (p *Pipeline) Scope() string {
+	return p.taskMeta.Scope
 }
 
 func (p *Pipeline) Start() error {
 	for name, step := range p.spec.Stages {
-		if err := p.mgr.operatorMgr.Up(p.taskMeta.Scope, name, step, 3); err != nil {
+		if err := p.mgr.operatorMgr.Up(p.taskMeta.Scope, name, step, StageReplicas(step)); err != nil {
 			return err
 		}
 	}
+
+	p.startAutoscaler()
+	p.startRetention()
+
 	return nil
 }
 
+// startRetention sweeps until stopped, when RetentionPolicy set
+func (p *Pipeline) startRetention() {
+	if p.retention == nil || p.stopRetention != nil {
+		return
+	}
+
+	interval := p.retention.Policy().SweepInterval
+	if interval <= 0 {
+		interval = DefaultSweepInterval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		p.retention.Run(ctx, interval)
+	}()
+
+	p.stopRetention = func() {
+		cancel()
+		<-done
+	}
+}
+
+// startAutoscaler runs Autoscaler until stopped, when any stage autoscales
+func (p *Pipeline) startAutoscaler() {
+	autoscaler := NewAutoscaler(p, p.mgr.tasksPerReplica)
+	if len(autoscaler.replicas) == 0 || p.mgr.autoscaleInterval <= 0 || p.stopAutoscaler != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		autoscaler.Run(ctx, p.mgr.autoscaleInterval)
+	}()
+
+	p.stopAutoscaler = func() {
+		cancel()
+		<-done
+	}
+}
+
+// Retention returns nil when no RetentionPolicy
+func (p *Pipeline) Retention() *Retention {
+	return p.retention
+}
+
 func (p *Pipeline) Stop() error {
+	// not scaling stages destroyed
+	if p.stopAutoscaler != nil {
+		p.stopAutoscaler()
+		p.stopAutoscaler = nil
+	}
+
+	if p.stopRetention != nil {
+		p.stopRetention()
+		p.stopRetention = nil
+	}
+
 	for name := range p.spec.Stages {
 		if err := p.mgr.operatorMgr.Destroy(p.taskMeta.Scope, name); err != nil {
 			return err
 		}
 	}
+
+	if p.retention != nil && p.retention.Policy().PurgeOnStop {
+		return p.retention.Purge(context.Background())
+	}
+
 	return nil
 }
 
@@ -72,24 +314,297 @@ func (p *Pipeline) Next(ctx context.Context, input io.Reader) (Result, error) {
 		return nil, err
 	}
 
-	go func() {
-		sub := Subscribe(p.mgr.pipelineController, task.Final(), p.finish)
-		defer sub.Unsubscribe()
+	if deadline, ok := ctx.Deadline(); ok {
+		task.Deadline = &deadline
+	}
 
-		<-ctx.Done()
-		p.finish(ctx, task.Err(ctx.Err()))
-	}()
+	r := p.watch(ctx, task)
+
+	recordTaskEvent(p.mgr.pipelineController, ctx, NewTaskEvent(task, TaskEventQueued))
 
 	t, err := newTransfer(p.mgr.pipelineController, ContextWithTask(ctx, task), task)
 	if err != nil {
+		// finished to unsubscribe
+		p.finish(ctx, task.Err(err))
 		return nil, err
 	}
 
 	if err := SendByReader(t, input); err != nil {
+		p.finish(ctx, task.Err(err))
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Attach returns the Result of a task in-flight or done, which created by Next of this pipeline before.
+// TaskStore required to tell whether the task done.
+func (p *Pipeline) Attach(ctx context.Context, taskID uint64) (Result, error) {
+	if p.mgr.pipelineController.TaskStore() == nil {
+		return nil, ErrTaskStoreRequired
+	}
+
+	task := p.taskMeta.NewTask(taskID)
+
+	// watched before state read, so the task done in between not missed
+	r := p.watch(ctx, task)
+
+	state, err := p.mgr.GetTask(ctx, taskID)
+	if err == nil && state.Scope != p.Scope() {
+		err = fmt.Errorf("task %d not belongs to pipeline %s", taskID, p.Scope())
+	}
+	if err != nil {
+		p.finish(ctx, task.Err(err))
+		return nil, err
+	}
+
+	switch state.Status {
+	case TaskStatusSucceeded:
+		p.finish(ctx, task.Next(p.taskMeta.Ends, state.Outputs))
+	case TaskStatusFailed:
+		p.finish(ctx, task.Next(state.Stage, nil).Err(errors.New(state.ErrMsg)))
+	}
+
+	return r, nil
+}
+
+// DeadLetters returns dead letters of the pipeline, latest first
+func (p *Pipeline) DeadLetters(ctx context.Context) ([]*DeadLetter, error) {
+	deadLetterStore := p.mgr.pipelineController.DeadLetterStore()
+	if deadLetterStore == nil {
+		return nil, nil
+	}
+	return deadLetterStore.List(ctx, p.Scope())
+}
+
+func (p *Pipeline) DeadLetter(ctx context.Context, id string) (*DeadLetter, error) {
+	deadLetterStore := p.mgr.pipelineController.DeadLetterStore()
+	if deadLetterStore == nil {
+		return nil, ErrDeadLetterNotFound
+	}
+	return deadLetterStore.Get(ctx, p.Scope(), id)
+}
+
+// Requeue sends the task of dead letter to stage again, and removes the dead letter.
+// Inputs recorded in the dead letter are used, so stage should be the stage of dead letter or one of its upstream stages the task went through.
+func (p *Pipeline) Requeue(ctx context.Context, id string, stage string) (Result, error) {
+	letter, err := p.DeadLetter(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := p.taskMeta.StageDeps[stage]; !ok {
+		return nil, fmt.Errorf("stage %s not found in pipeline %s", stage, p.Scope())
+	}
+
+	inputs, ok := letter.Inputs[stage]
+	if !ok {
+		if stage != letter.Stage {
+			return nil, fmt.Errorf("inputs of stage %s not recorded in dead letter %s", stage, id)
+		}
+		inputs = letter.Task.Inputs
+	}
+
+	task := p.taskMeta.NewTask(letter.Task.ID)
+	task.Meta = letter.Task.Meta
+	task.TaskStage = NewTaskStage(stage, inputs)
+
+	r, err := p.publish(ctx, task)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.PurgeDeadLetters(ctx, id); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Replay reruns stages from fromStage as a new task,
+// with the stored results of upstream stages of the task as inputs, instead of rerunning them.
+func (p *Pipeline) Replay(ctx context.Context, taskID uint64, fromStage string) (Result, error) {
+	if _, ok := p.taskMeta.StageDeps[fromStage]; !ok {
+		return nil, fmt.Errorf("stage %s not found in pipeline %s", fromStage, p.Scope())
+	}
+
+	inputs, err := p.stageInputs(ctx, taskID, fromStage)
+	if err != nil {
+		return nil, err
+	}
+
+	task, err := p.newTask()
+	if err != nil {
+		return nil, err
+	}
+
+	// stages joining upstream stages not rerun, should take their stored results as joined.
+	replayed := p.downstreams(fromStage)
+
+	for stage := range replayed {
+		deps := p.taskMeta.StageDeps[stage]
+		if len(deps) <= 1 {
+			continue
+		}
+
+		for _, dep := range deps {
+			if replayed[dep] {
+				continue
+			}
+
+			outputs, err := p.stageResults(ctx, taskID, dep)
+			if err != nil {
+				return nil, err
+			}
+
+			data, err := json.Marshal(outputs)
+			if err != nil {
+				return nil, err
+			}
+
+			if _, err := p.mgr.pipelineController.Join(ctx, joinKey(task.ID, stage), dep, data, len(deps)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	task.TaskStage = NewTaskStage(fromStage, inputs)
+
+	return p.publish(ctx, task)
+}
+
+// publish sends task to its stage directly, and returns its result.
+func (p *Pipeline) publish(ctx context.Context, task *Task) (Result, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		task.Deadline = &deadline
+	}
+
+	r := p.watch(ctx, task)
+
+	recordTaskEvent(p.mgr.pipelineController, ctx, NewTaskEvent(task, TaskEventQueued))
+
+	if err := Publish(p.mgr.pipelineController, ctx, task.Stage, task); err != nil {
+		p.finish(ctx, task.Err(err))
 		return nil, err
 	}
 
-	return p.register(task), nil
+	return r, nil
+}
+
+// downstreams returns stage and all stages depends on it directly or not.
+func (p *Pipeline) downstreams(stage string) map[string]bool {
+	stages := map[string]bool{}
+
+	var walk func(stage string)
+	walk = func(stage string) {
+		if stages[stage] {
+			return
+		}
+		stages[stage] = true
+
+		for next, deps := range p.taskMeta.StageDeps {
+			for _, dep := range deps {
+				if dep == stage {
+					walk(next)
+				}
+			}
+		}
+	}
+
+	walk(stage)
+
+	return stages
+}
+
+// PurgeDeadLetters removes dead letters of ids, or all dead letters of the pipeline when no ids.
+func (p *Pipeline) PurgeDeadLetters(ctx context.Context, ids ...string) error {
+	deadLetterStore := p.mgr.pipelineController.DeadLetterStore()
+	if deadLetterStore == nil {
+		return nil
+	}
+
+	if len(ids) == 0 {
+		letters, err := deadLetterStore.List(ctx, p.Scope())
+		if err != nil {
+			return err
+		}
+		for _, letter := range letters {
+			ids = append(ids, letter.ID)
+		}
+	}
+
+	for _, id := range ids {
+		if err := deadLetterStore.Del(ctx, p.Scope(), id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stageInputs collects results of deps of stage in the order of deps,
+// or the inputs of task when stage is the starts.
+func (p *Pipeline) stageInputs(ctx context.Context, taskID uint64, stage string) ([]string, error) {
+	deps := p.taskMeta.StageDeps[stage]
+	if stage == p.taskMeta.Starts {
+		deps = []string{"$input"}
+	}
+
+	inputs := make([]string, 0)
+
+	for _, dep := range deps {
+		results, err := p.stageResults(ctx, taskID, dep)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, results...)
+	}
+
+	return inputs, nil
+}
+
+// stageResults returns paths of results of stage stored for task
+func (p *Pipeline) stageResults(ctx context.Context, taskID uint64, stage string) ([]string, error) {
+	list, err := p.mgr.pipelineController.List(ctx, stageResultsPath(taskID, stage)+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(list) == 0 {
+		return nil, fmt.Errorf("no results of %s for task %d", stage, taskID)
+	}
+
+	results := make([]string, len(list))
+	for i := range list {
+		results[i] = list[i].Path
+	}
+
+	return results, nil
+}
+
+// watch registers result of task, and finishes it when task done or ctx done.
+func (p *Pipeline) watch(ctx context.Context, task *Task) *result {
+	r := p.register(task)
+
+	sub := Subscribe(p.mgr.pipelineController, task.Final(), func(ctx context.Context, t *Task) error {
+		p.finish(ctx, t)
+		return nil
+	})
+
+	go func() {
+		defer sub.Unsubscribe()
+
+		select {
+		case <-r.finished:
+		case <-ctx.Done():
+			// the result of task requeued with the same id not finished by mistake
+			if p.getResult(task) == r {
+				p.finish(ctx, task.Err(ctx.Err()))
+			}
+		}
+	}()
+
+	return r
 }
 
 func (p *Pipeline) newTask() (*Task, error) {
@@ -107,18 +622,20 @@ func (p *Pipeline) finish(ctx context.Context, t *Task) {
 		return
 	}
 
-	defer p.results.Delete(t.ID)
+	// deleted before finished, so the result registered again once the task requeued not deleted
+	p.results.Delete(t.ID)
 
 	if t.ErrMsg != "" {
 		r.finish(nil, fmt.Errorf("[%s]%s: %s", p.spec.RefID(), t.Stage, t.ErrMsg))
 		return
 	}
 
-	r.finish(newTransfer(p.mgr.pipelineController, ContextWithTask(ctx, t), t))
+	// results read after the event handled
+	r.finish(newTransfer(p.mgr.pipelineController, ContextWithTask(context.Background(), t), t))
 }
 
 func (p *Pipeline) register(task *Task) *result {
-	r := newResult()
+	r := newResult(task.ID)
 	p.results.Store(task.ID, r)
 	return r
 }
@@ -1 +1,1158 @@
-package pipeline
+package pipeline_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-courier/semver"
+	. "github.com/onsi/gomega"
+	"github.com/querycap/pipeline/pipeline"
+	memdeadletter "github.com/querycap/pipeline/pipeline/deadletter/mem"
+	"github.com/querycap/pipeline/pipeline/eventbus/mem"
+	memoperator "github.com/querycap/pipeline/pipeline/operator/mem"
+	"github.com/querycap/pipeline/pipeline/pipelinetest"
+	memresultcache "github.com/querycap/pipeline/pipeline/resultcache/mem"
+	"github.com/querycap/pipeline/pipeline/storage/fs"
+	memtaskstore "github.com/querycap/pipeline/pipeline/taskstore/mem"
+	"github.com/querycap/pipeline/spec"
+	"github.com/spf13/afero"
+)
+
+func appendHandler(suffix string) pipeline.OperatorHandlerFunc {
+	return func(t pipeline.Transfer) error {
+		data, err := pipelinetest.ReadAll(t)
+		if err != nil {
+			return err
+		}
+		return t.Put(bytes.NewBuffer(append(data, []byte(suffix)...)))
+	}
+}
+
+func startPipeline(t *testing.T, pc pipeline.PipelineController, operatorMgr pipeline.OperatorMgr, name string, flow spec.PipelineFlow) *pipeline.Pipeline {
+	p, err := pipeline.NewPipelineMgr(operatorMgr, pc).NewPipeline(&spec.Pipeline{
+		Name:         name,
+		Version:      *semver.MustParseVersion("1.0.0"),
+		PipelineFlow: flow,
+	})
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(p.Start()).To(BeNil())
+	return p
+}
+
+func TestPipelineFanIn(t *testing.T) {
+	pc := pipelinetest.NewPipelineController()
+	operatorMgr := memoperator.NewMemOperatorMgr(pc)
+
+	joined := int64(0)
+
+	_ = operatorMgr.Register(pipelinetest.Ref("a"), appendHandler("a"))
+	_ = operatorMgr.Register(pipelinetest.Ref("b"), appendHandler("b"))
+	_ = operatorMgr.Register(pipelinetest.Ref("c"), appendHandler("c"))
+	_ = operatorMgr.Register(pipelinetest.Ref("d"), func(t pipeline.Transfer) error {
+		atomic.AddInt64(&joined, 1)
+		return appendHandler("d")(t)
+	})
+
+	p := startPipeline(t, pc, operatorMgr, "fan-in", spec.PipelineFlow{
+		Starts: "a",
+		Ends:   "d",
+		Stages: map[string]spec.Stage{
+			"a": {Uses: pipelinetest.Ref("a")},
+			"b": {Uses: pipelinetest.Ref("b"), Deps: []string{"a"}},
+			"c": {Uses: pipelinetest.Ref("c"), Deps: []string{"a"}},
+			"d": {Uses: pipelinetest.Ref("d"), Deps: []string{"b", "c"}},
+		},
+	})
+	defer p.Stop()
+
+	data, err := pipelinetest.Run(p, "input:")
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(string(data)).To(Equal("input:abinput:acd"))
+
+	time.Sleep(100 * time.Millisecond)
+	NewWithT(t).Expect(atomic.LoadInt64(&joined)).To(Equal(int64(1)))
+}
+
+// subscriptionCountingEventBus counts subscriptions not unsubscribed
+type subscriptionCountingEventBus struct {
+	pipeline.EventBus
+	subscriptions int64
+}
+
+func (b *subscriptionCountingEventBus) Subscribe(topic string, callback pipeline.Handler) pipeline.Subscription {
+	atomic.AddInt64(&b.subscriptions, 1)
+	return &countedSubscription{Subscription: b.EventBus.Subscribe(topic, callback), subscriptions: &b.subscriptions}
+}
+
+type countedSubscription struct {
+	pipeline.Subscription
+	subscriptions *int64
+	once          sync.Once
+}
+
+func (s *countedSubscription) Unsubscribe() {
+	s.once.Do(func() {
+		atomic.AddInt64(s.subscriptions, -1)
+	})
+	s.Subscription.Unsubscribe()
+}
+
+// publishRecordingEventBus records when retries published
+type publishRecordingEventBus struct {
+	pipeline.EventBus
+	mu          sync.Mutex
+	publishedAt []time.Time
+}
+
+func (b *publishRecordingEventBus) Publish(ctx context.Context, topic string, data []byte) error {
+	task := &pipeline.Task{}
+	if err := json.Unmarshal(data, task); err == nil && task.TaskStage != nil && task.RetryAt != nil {
+		b.mu.Lock()
+		b.publishedAt = append(b.publishedAt, time.Now())
+		b.mu.Unlock()
+	}
+	return b.EventBus.Publish(ctx, topic, data)
+}
+
+func (b *publishRecordingEventBus) retries() []time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]time.Time{}, b.publishedAt...)
+}
+
+type errReader struct{}
+
+func (errReader) Read(p []byte) (int, error) {
+	return 0, errors.New("read failed")
+}
+
+func TestPipelineNextFailed(t *testing.T) {
+	eventBus := &subscriptionCountingEventBus{EventBus: mem.NewMemEventBus()}
+	pc := pipeline.NewPipelineController(eventBus, fs.NewFsStorage(afero.NewMemMapFs()), &pipelinetest.IDGen{}, pipelinetest.MachineIdentifier("test"))
+	operatorMgr := memoperator.NewMemOperatorMgr(pc)
+
+	_ = operatorMgr.Register(pipelinetest.Ref("a"), appendHandler("a"))
+
+	p := startPipeline(t, pc, operatorMgr, "next-failed", spec.PipelineFlow{
+		Starts: "a",
+		Ends:   "a",
+		Stages: map[string]spec.Stage{
+			"a": {Uses: pipelinetest.Ref("a")},
+		},
+	})
+	defer p.Stop()
+
+	serving := atomic.LoadInt64(&eventBus.subscriptions)
+
+	_, err := p.Next(context.Background(), errReader{})
+	NewWithT(t).Expect(err).NotTo(BeNil())
+
+	NewWithT(t).Eventually(func() int64 {
+		return atomic.LoadInt64(&eventBus.subscriptions)
+	}).Should(Equal(serving))
+}
+
+func TestPipelineMgrRefuseInvalid(t *testing.T) {
+	pc := pipelinetest.NewPipelineController()
+
+	_, err := pipeline.NewPipelineMgr(memoperator.NewMemOperatorMgr(pc), pc).NewPipeline(&spec.Pipeline{
+		Name:    "invalid",
+		Version: *semver.MustParseVersion("1.0.0"),
+		PipelineFlow: spec.PipelineFlow{
+			Starts: "a",
+			Ends:   "b",
+			Stages: map[string]spec.Stage{
+				"a": {Uses: pipelinetest.Ref("a")},
+				"b": {Uses: pipelinetest.Ref("b"), Deps: []string{"b"}},
+			},
+		},
+	})
+
+	errs := spec.ValidationErrors{}
+	NewWithT(t).Expect(errors.As(err, &errs)).To(BeTrue())
+	NewWithT(t).Expect(errs).To(HaveLen(2))
+}
+
+func TestPipelineRetry(t *testing.T) {
+	pc := pipelinetest.NewPipelineController()
+	operatorMgr := memoperator.NewMemOperatorMgr(pc)
+
+	attempts := make([]int, 0)
+
+	_ = operatorMgr.Register(pipelinetest.Ref("flaky"), func(t pipeline.Transfer) error {
+		task := pipeline.TaskFromContext(t.Context())
+		attempts = append(attempts, task.Attempt)
+		if task.Attempt < 2 {
+			return errors.New("connection refused")
+		}
+		return appendHandler("flaky")(t)
+	})
+
+	_ = operatorMgr.Register(pipelinetest.Ref("broken"), func(t pipeline.Transfer) error {
+		return errors.New("connection refused")
+	})
+
+	t.Run("retried until succeed", func(t *testing.T) {
+		p := startPipeline(t, pc, operatorMgr, "retry", spec.PipelineFlow{
+			Starts: "a",
+			Ends:   "a",
+			Stages: map[string]spec.Stage{
+				"a": {Uses: pipelinetest.Ref("flaky"), Retry: &spec.RetryPolicy{MaxAttempts: 3, InitialBackoff: spec.Duration(10 * time.Millisecond)}},
+			},
+		})
+		defer p.Stop()
+
+		data, err := pipelinetest.Run(p, "input:")
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(string(data)).To(Equal("input:flaky"))
+		NewWithT(t).Expect(attempts).To(Equal([]int{0, 1, 2}))
+	})
+
+	t.Run("retry published at once and handled after backoff", func(t *testing.T) {
+		eventBus := &publishRecordingEventBus{EventBus: mem.NewMemEventBus()}
+		pc := pipeline.NewPipelineController(eventBus, fs.NewFsStorage(afero.NewMemMapFs()), &pipelinetest.IDGen{}, pipelinetest.MachineIdentifier("test"))
+		operatorMgr := memoperator.NewMemOperatorMgr(pc)
+
+		handledAt := make(chan time.Time, 2)
+
+		_ = operatorMgr.Register(pipelinetest.Ref("flaky"), func(t pipeline.Transfer) error {
+			handledAt <- time.Now()
+			if pipeline.TaskFromContext(t.Context()).Attempt < 1 {
+				return errors.New("connection refused")
+			}
+			return appendHandler("flaky")(t)
+		})
+
+		backoff := 300 * time.Millisecond
+
+		p := startPipeline(t, pc, operatorMgr, "retry", spec.PipelineFlow{
+			Starts: "a",
+			Ends:   "a",
+			Stages: map[string]spec.Stage{
+				"a": {Uses: pipelinetest.Ref("flaky"), Retry: &spec.RetryPolicy{MaxAttempts: 2, InitialBackoff: spec.Duration(backoff)}},
+			},
+		})
+		defer p.Stop()
+
+		data, err := pipelinetest.Run(p, "input:")
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(string(data)).To(Equal("input:flaky"))
+
+		failedAt, retriedAt := <-handledAt, <-handledAt
+		NewWithT(t).Expect(retriedAt.Sub(failedAt) >= backoff).To(BeTrue())
+
+		retries := eventBus.retries()
+		NewWithT(t).Expect(retries).To(HaveLen(1))
+		NewWithT(t).Expect(retries[0].Sub(failedAt) < backoff).To(BeTrue())
+	})
+
+	t.Run("failed when retries run out", func(t *testing.T) {
+		p := startPipeline(t, pc, operatorMgr, "retry", spec.PipelineFlow{
+			Starts: "a",
+			Ends:   "a",
+			Stages: map[string]spec.Stage{
+				"a": {Uses: pipelinetest.Ref("broken"), Retry: &spec.RetryPolicy{MaxAttempts: 2, InitialBackoff: spec.Duration(10 * time.Millisecond)}},
+			},
+		})
+		defer p.Stop()
+
+		_, err := pipelinetest.Run(p, "input:")
+		NewWithT(t).Expect(err).NotTo(BeNil())
+		NewWithT(t).Expect(err.Error()).To(ContainSubstring("connection refused (after 2 attempts)"))
+	})
+}
+
+func TestPipelineTimeout(t *testing.T) {
+	pc := pipelinetest.NewPipelineController()
+	operatorMgr := memoperator.NewMemOperatorMgr(pc)
+
+	slowErrs := make(chan error, 1)
+
+	_ = operatorMgr.Register(pipelinetest.Ref("slow"), func(t pipeline.Transfer) error {
+		// not honouring context
+		time.Sleep(300 * time.Millisecond)
+		err := appendHandler("slow")(t)
+		slowErrs <- err
+		return err
+	})
+
+	deadlines := make(chan time.Time, 1)
+
+	_ = operatorMgr.Register(pipelinetest.Ref("deadline"), func(t pipeline.Transfer) error {
+		deadline, _ := t.Context().Deadline()
+		deadlines <- deadline
+		return appendHandler("deadline")(t)
+	})
+
+	t.Run("stage timeout", func(t *testing.T) {
+		p := startPipeline(t, pc, operatorMgr, "timeout", spec.PipelineFlow{
+			Starts: "a",
+			Ends:   "a",
+			Stages: map[string]spec.Stage{
+				"a": {Uses: pipelinetest.Ref("slow"), Timeout: spec.Duration(50 * time.Millisecond)},
+			},
+		})
+		defer p.Stop()
+
+		_, err := pipelinetest.Run(p, "input:")
+		NewWithT(t).Expect(err).NotTo(BeNil())
+		NewWithT(t).Expect(err.Error()).To(ContainSubstring(pipeline.ErrStageTimeout.Error()))
+
+		// inputs and outputs not available after timeout
+		NewWithT(t).Expect(<-slowErrs).To(Equal(context.DeadlineExceeded))
+	})
+
+	t.Run("task deadline reach handler", func(t *testing.T) {
+		p := startPipeline(t, pc, operatorMgr, "timeout", spec.PipelineFlow{
+			Starts: "a",
+			Ends:   "a",
+			Stages: map[string]spec.Stage{
+				"a": {Uses: pipelinetest.Ref("deadline"), Timeout: spec.Duration(time.Hour)},
+			},
+		})
+		defer p.Stop()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		r, err := p.Next(ctx, bytes.NewBufferString("input:"))
+		NewWithT(t).Expect(err).To(BeNil())
+		<-r.Done()
+		NewWithT(t).Expect(r.Err()).To(BeNil())
+
+		expected, _ := ctx.Deadline()
+		NewWithT(t).Expect((<-deadlines).Equal(expected)).To(BeTrue())
+	})
+}
+
+type recordingOperatorMgr struct {
+	pipeline.OperatorMgr
+	rw       sync.Mutex
+	replicas map[string]int32
+}
+
+func (m *recordingOperatorMgr) Up(scope string, name string, step spec.Stage, replicas int32) error {
+	m.rw.Lock()
+	m.replicas[name] = replicas
+	m.rw.Unlock()
+	return m.OperatorMgr.Up(scope, name, step, replicas)
+}
+
+func (m *recordingOperatorMgr) Replicas(name string) int32 {
+	m.rw.Lock()
+	defer m.rw.Unlock()
+	return m.replicas[name]
+}
+
+func TestAutoscaler(t *testing.T) {
+	pc := pipelinetest.NewPipelineController()
+	memOperatorMgr := memoperator.NewMemOperatorMgr(pc)
+	operatorMgr := &recordingOperatorMgr{OperatorMgr: memOperatorMgr, replicas: map[string]int32{}}
+
+	release := make(chan struct{})
+
+	_ = memOperatorMgr.Register(pipelinetest.Ref("heavy"), func(t pipeline.Transfer) error {
+		<-release
+		return appendHandler("heavy")(t)
+	})
+	_ = memOperatorMgr.Register(pipelinetest.Ref("cheap"), appendHandler("cheap"))
+
+	p := startPipeline(t, pc, operatorMgr, "autoscale", spec.PipelineFlow{
+		Starts: "a",
+		Ends:   "b",
+		Stages: map[string]spec.Stage{
+			"a": {Uses: pipelinetest.Ref("heavy"), Scaling: spec.Scaling{MinReplicas: 1, MaxReplicas: 5}},
+			"b": {Uses: pipelinetest.Ref("cheap"), Deps: []string{"a"}, Scaling: spec.Scaling{Replicas: 2}},
+		},
+	})
+	defer p.Stop()
+
+	NewWithT(t).Expect(operatorMgr.Replicas("a")).To(Equal(int32(1)))
+	NewWithT(t).Expect(operatorMgr.Replicas("b")).To(Equal(int32(2)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	results := make([]pipeline.Result, 0)
+
+	for i := 0; i < 7; i++ {
+		r, err := p.Next(ctx, bytes.NewBufferString("input:"))
+		NewWithT(t).Expect(err).To(BeNil())
+		results = append(results, r)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	autoscaler := pipeline.NewAutoscaler(p, 2)
+
+	// one picked, 6 queued
+	NewWithT(t).Expect(autoscaler.Scale(ctx)).To(BeNil())
+	NewWithT(t).Expect(operatorMgr.Replicas("a")).To(Equal(int32(3)))
+
+	close(release)
+
+	for _, r := range results {
+		<-r.Done()
+		NewWithT(t).Expect(r.Err()).To(BeNil())
+	}
+
+	NewWithT(t).Expect(autoscaler.Scale(ctx)).To(BeNil())
+	NewWithT(t).Expect(operatorMgr.Replicas("a")).To(Equal(int32(2)))
+	NewWithT(t).Expect(operatorMgr.Replicas("b")).To(Equal(int32(2)))
+}
+
+func TestPipelineAutoscaling(t *testing.T) {
+	pc := pipelinetest.NewPipelineController()
+	memOperatorMgr := memoperator.NewMemOperatorMgr(pc)
+	operatorMgr := &recordingOperatorMgr{OperatorMgr: memOperatorMgr, replicas: map[string]int32{}}
+
+	release := make(chan struct{})
+
+	_ = memOperatorMgr.Register(pipelinetest.Ref("heavy"), func(t pipeline.Transfer) error {
+		<-release
+		return appendHandler("heavy")(t)
+	})
+
+	p, err := pipeline.NewPipelineMgr(operatorMgr, pc, pipeline.WithAutoscaling(20*time.Millisecond, 1)).NewPipeline(&spec.Pipeline{
+		Name:    "autoscaling",
+		Version: *semver.MustParseVersion("1.0.0"),
+		PipelineFlow: spec.PipelineFlow{
+			Starts: "a",
+			Ends:   "a",
+			Stages: map[string]spec.Stage{
+				"a": {Uses: pipelinetest.Ref("heavy"), Scaling: spec.Scaling{MinReplicas: 1, MaxReplicas: 3}},
+			},
+		},
+	})
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(p.Start()).To(BeNil())
+	defer p.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	results := make([]pipeline.Result, 0)
+
+	for i := 0; i < 5; i++ {
+		r, err := p.Next(ctx, bytes.NewBufferString("input:"))
+		NewWithT(t).Expect(err).To(BeNil())
+		results = append(results, r)
+	}
+
+	NewWithT(t).Eventually(func() int32 {
+		return operatorMgr.Replicas("a")
+	}).Should(Equal(int32(3)))
+
+	close(release)
+
+	for _, r := range results {
+		<-r.Done()
+		NewWithT(t).Expect(r.Err()).To(BeNil())
+	}
+
+	NewWithT(t).Eventually(func() int32 {
+		return operatorMgr.Replicas("a")
+	}).Should(Equal(int32(1)))
+}
+
+func TestPipelineTaskStore(t *testing.T) {
+	pc := pipelinetest.NewPipelineController(pipeline.WithTaskStore(memtaskstore.NewMemTaskStore()))
+	operatorMgr := memoperator.NewMemOperatorMgr(pc)
+	pipelineMgr := pipeline.NewPipelineMgr(operatorMgr, pc)
+
+	release := make(chan struct{})
+
+	_ = operatorMgr.Register(pipelinetest.Ref("a"), appendHandler("a"))
+	_ = operatorMgr.Register(pipelinetest.Ref("blocked"), func(t pipeline.Transfer) error {
+		<-release
+		return errors.New("broken")
+	})
+
+	p := startPipeline(t, pc, operatorMgr, "task-store", spec.PipelineFlow{
+		Starts: "a",
+		Ends:   "b",
+		Stages: map[string]spec.Stage{
+			"a": {Uses: pipelinetest.Ref("a")},
+			"b": {Uses: pipelinetest.Ref("blocked"), Deps: []string{"a"}},
+		},
+	})
+	defer p.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	r, err := p.Next(ctx, bytes.NewBufferString("input:"))
+	NewWithT(t).Expect(err).To(BeNil())
+
+	time.Sleep(50 * time.Millisecond)
+
+	list, err := pipelineMgr.ListTasks(ctx, pipeline.TaskFilter{Scope: p.Scope(), Stage: "b"})
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(list).To(HaveLen(1))
+	NewWithT(t).Expect(list[0].Status).To(Equal(pipeline.TaskStatusRunning))
+	NewWithT(t).Expect(list[0].Stages[0].Stage).To(Equal("a"))
+	NewWithT(t).Expect(list[0].Stages[0].Status).To(Equal(pipeline.TaskStatusSucceeded))
+	NewWithT(t).Expect(list[0].Stages[0].Outputs).To(HaveLen(1))
+
+	close(release)
+	<-r.Done()
+	NewWithT(t).Expect(r.Err()).NotTo(BeNil())
+
+	state, err := pipelineMgr.GetTask(ctx, list[0].ID)
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(state.Status).To(Equal(pipeline.TaskStatusFailed))
+	NewWithT(t).Expect(state.Stage).To(Equal("b"))
+	NewWithT(t).Expect(state.ErrMsg).To(Equal("broken"))
+	NewWithT(t).Expect(state.Stages[1].EndedAt).NotTo(BeNil())
+
+	list, err = pipelineMgr.ListTasks(ctx, pipeline.TaskFilter{Status: pipeline.TaskStatusRunning})
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(list).To(HaveLen(0))
+
+	_, err = pipelineMgr.GetTask(ctx, 0)
+	NewWithT(t).Expect(err).To(Equal(pipeline.ErrTaskNotFound))
+}
+
+func TestPipelineAttach(t *testing.T) {
+	pc := pipelinetest.NewPipelineController(pipeline.WithTaskStore(memtaskstore.NewMemTaskStore()))
+	operatorMgr := memoperator.NewMemOperatorMgr(pc)
+
+	release := make(chan struct{})
+
+	_ = operatorMgr.Register(pipelinetest.Ref("blocked"), func(t pipeline.Transfer) error {
+		<-release
+		return appendHandler("blocked")(t)
+	})
+
+	p := startPipeline(t, pc, operatorMgr, "attach", spec.PipelineFlow{
+		Starts: "a",
+		Ends:   "a",
+		Stages: map[string]spec.Stage{
+			"a": {Uses: pipelinetest.Ref("blocked")},
+		},
+	})
+	defer p.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	submitCtx, submitterGone := context.WithCancel(context.Background())
+
+	submitted, err := p.Next(submitCtx, bytes.NewBufferString("input:"))
+	NewWithT(t).Expect(err).To(BeNil())
+
+	// as restarted
+	submitterGone()
+	<-submitted.Done()
+
+	restored, err := pipeline.NewPipelineMgr(operatorMgr, pc).RestorePipeline(ctx, p.Spec().RefID(), p.ID())
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(restored.Scope()).To(Equal(p.Scope()))
+
+	t.Run("attach in-flight task", func(t *testing.T) {
+		r, err := restored.Attach(ctx, submitted.TaskID())
+		NewWithT(t).Expect(err).To(BeNil())
+
+		release <- struct{}{}
+
+		<-r.Done()
+		NewWithT(t).Expect(r.Err()).To(BeNil())
+
+		data, err := pipelinetest.ReadAll(r)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(string(data)).To(Equal("input:blocked"))
+	})
+
+	t.Run("attach done task", func(t *testing.T) {
+		r, err := restored.Attach(ctx, submitted.TaskID())
+		NewWithT(t).Expect(err).To(BeNil())
+
+		<-r.Done()
+		NewWithT(t).Expect(r.Err()).To(BeNil())
+
+		data, err := pipelinetest.ReadAll(r)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(string(data)).To(Equal("input:blocked"))
+	})
+
+	t.Run("attach task done while detached", func(t *testing.T) {
+		submitCtx, submitterGone := context.WithCancel(context.Background())
+
+		submitted, err := p.Next(submitCtx, bytes.NewBufferString("detached:"))
+		NewWithT(t).Expect(err).To(BeNil())
+
+		submitterGone()
+		<-submitted.Done()
+
+		// done while detached
+		release <- struct{}{}
+
+		// not failed after succeeded, when the result published to no one
+		NewWithT(t).Eventually(func() pipeline.TaskStatus {
+			state, err := pipeline.NewPipelineMgr(operatorMgr, pc).GetTask(ctx, submitted.TaskID())
+			if err != nil || len(state.Stages) == 0 || len(state.Running()) > 0 {
+				return ""
+			}
+			return state.Status
+		}).Should(Equal(pipeline.TaskStatusSucceeded))
+
+		r, err := restored.Attach(ctx, submitted.TaskID())
+		NewWithT(t).Expect(err).To(BeNil())
+
+		<-r.Done()
+		NewWithT(t).Expect(r.Err()).To(BeNil())
+
+		data, err := pipelinetest.ReadAll(r)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(string(data)).To(Equal("detached:blocked"))
+	})
+
+	t.Run("attach unknown task", func(t *testing.T) {
+		_, err := restored.Attach(ctx, 0)
+		NewWithT(t).Expect(err).To(Equal(pipeline.ErrTaskNotFound))
+	})
+
+	t.Run("restore unknown pipeline", func(t *testing.T) {
+		_, err := pipeline.NewPipelineMgr(operatorMgr, pc).RestorePipeline(ctx, p.Spec().RefID(), 0)
+		NewWithT(t).Expect(err).NotTo(BeNil())
+	})
+}
+
+func TestPipelineSpecStoredWithoutHeaders(t *testing.T) {
+	pc := pipelinetest.NewPipelineController()
+	mgr := pipeline.NewPipelineMgr(memoperator.NewMemOperatorMgr(pc), pc)
+
+	p, err := mgr.NewPipeline(&spec.Pipeline{
+		Name:    "headers",
+		Version: *semver.MustParseVersion("1.0.0"),
+		PipelineFlow: spec.PipelineFlow{
+			Starts: "a",
+			Ends:   "a",
+			Stages: map[string]spec.Stage{
+				"a": {HTTP: &spec.HTTPEndpoint{URL: "http://operator/a", Headers: map[string]string{"Authorization": "Bearer token"}}},
+			},
+		},
+	})
+	NewWithT(t).Expect(err).To(BeNil())
+
+	// spec in use not changed
+	NewWithT(t).Expect(p.Spec().Stages["a"].HTTP.Headers).To(HaveKey("Authorization"))
+
+	restored, err := mgr.RestorePipeline(context.Background(), p.Spec().RefID(), p.ID())
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(restored.Spec().Stages["a"].HTTP.URL).To(Equal("http://operator/a"))
+	NewWithT(t).Expect(restored.Spec().Stages["a"].HTTP.Headers).To(BeEmpty())
+}
+
+func TestPipelineAttachWithoutTaskStore(t *testing.T) {
+	pc := pipelinetest.NewPipelineController()
+	operatorMgr := memoperator.NewMemOperatorMgr(pc)
+
+	_ = operatorMgr.Register(pipelinetest.Ref("a"), appendHandler("a"))
+
+	p := startPipeline(t, pc, operatorMgr, "attach-without-task-store", spec.PipelineFlow{
+		Starts: "a",
+		Ends:   "a",
+		Stages: map[string]spec.Stage{
+			"a": {Uses: pipelinetest.Ref("a")},
+		},
+	})
+	defer p.Stop()
+
+	data, err := pipelinetest.Run(p, "input:")
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(string(data)).To(Equal("input:a"))
+
+	_, err = p.Attach(context.Background(), 1)
+	NewWithT(t).Expect(err).To(Equal(pipeline.ErrTaskStoreRequired))
+}
+
+// expiringTaskStore returns ErrTaskNotFound for events of tasks once expired, like the redis one does out of ttl
+type expiringTaskStore struct {
+	pipeline.TaskStore
+	expired sync.Map
+}
+
+func (s *expiringTaskStore) expire(taskID uint64) {
+	s.expired.Store(taskID, true)
+}
+
+func (s *expiringTaskStore) Events(ctx context.Context, taskID uint64) ([]*pipeline.TaskEvent, error) {
+	if _, ok := s.expired.Load(taskID); ok {
+		return nil, pipeline.ErrTaskNotFound
+	}
+	return s.TaskStore.Events(ctx, taskID)
+}
+
+func TestPipelineRetention(t *testing.T) {
+	s := fs.NewFsStorage(afero.NewMemMapFs())
+	taskStore := &expiringTaskStore{TaskStore: memtaskstore.NewMemTaskStore()}
+	pc := pipeline.NewPipelineController(mem.NewMemEventBus(), s, &pipelinetest.IDGen{}, pipelinetest.MachineIdentifier("test"), pipeline.WithTaskStore(taskStore))
+	operatorMgr := memoperator.NewMemOperatorMgr(pc)
+
+	broken := int32(0)
+
+	_ = operatorMgr.Register(pipelinetest.Ref("a"), appendHandler("a"))
+	_ = operatorMgr.Register(pipelinetest.Ref("b"), func(t pipeline.Transfer) error {
+		if atomic.LoadInt32(&broken) == 1 {
+			return errors.New("connection refused")
+		}
+		return appendHandler("b")(t)
+	})
+
+	p, err := pipeline.NewPipelineMgr(operatorMgr, pc, pipeline.WithRetentionPolicy(pipeline.RetentionPolicy{
+		FinalTTL:    time.Millisecond,
+		PurgeOnStop: true,
+	})).NewPipeline(&spec.Pipeline{
+		Name:    "retention",
+		Version: *semver.MustParseVersion("1.0.0"),
+		PipelineFlow: spec.PipelineFlow{
+			Starts: "a",
+			Ends:   "b",
+			Stages: map[string]spec.Stage{
+				"a": {Uses: pipelinetest.Ref("a")},
+				"b": {Uses: pipelinetest.Ref("b"), Deps: []string{"a"}},
+			},
+		},
+	})
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(p.Start()).To(BeNil())
+
+	ctx := context.Background()
+	scoped := pipeline.StorageWithBasePath(s, p.Scope())
+
+	data, err := pipelinetest.Run(p, "input:")
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(string(data)).To(Equal("input:ab"))
+
+	atomic.StoreInt32(&broken, 1)
+
+	_, err = pipelinetest.Run(p, "input:")
+	NewWithT(t).Expect(err).NotTo(BeNil())
+
+	list, err := scoped.List(ctx, "tasks/")
+	NewWithT(t).Expect(err).To(BeNil())
+	// $input, a, b of succeeded, and $input, a of failed
+	NewWithT(t).Expect(list).To(HaveLen(5))
+
+	time.Sleep(10 * time.Millisecond)
+
+	NewWithT(t).Expect(p.Retention().Sweep(ctx)).To(BeNil())
+
+	// intermediates of failed task kept for replay
+	list, err = scoped.List(ctx, "tasks/")
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(list).To(HaveLen(2))
+	NewWithT(t).Expect(list[0].Path).To(ContainSubstring("/stages/$input/results/"))
+	NewWithT(t).Expect(list[1].Path).To(ContainSubstring("/stages/a/results/"))
+
+	list, err = scoped.List(ctx, "")
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(list).To(HaveLen(3))
+
+	t.Run("state of failed task expired", func(t *testing.T) {
+		list, err := scoped.List(ctx, "tasks/")
+		NewWithT(t).Expect(err).To(BeNil())
+
+		failedTaskID, err := strconv.ParseUint(strings.Split(list[0].Path, "/")[1], 10, 64)
+		NewWithT(t).Expect(err).To(BeNil())
+
+		taskStore.expire(failedTaskID)
+
+		NewWithT(t).Expect(p.Retention().Sweep(ctx)).To(BeNil())
+
+		list, err = scoped.List(ctx, "tasks/")
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(list).To(HaveLen(0))
+	})
+
+	NewWithT(t).Expect(p.Stop()).To(BeNil())
+
+	list, err = scoped.List(ctx, "")
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(list).To(HaveLen(0))
+}
+
+func TestPipelineDeadLetters(t *testing.T) {
+	pc := pipelinetest.NewPipelineController(pipeline.WithDeadLetterStore(memdeadletter.NewMemDeadLetterStore()))
+	operatorMgr := memoperator.NewMemOperatorMgr(pc)
+
+	broken := int32(1)
+
+	_ = operatorMgr.Register(pipelinetest.Ref("a"), appendHandler("a"))
+	_ = operatorMgr.Register(pipelinetest.Ref("b"), func(t pipeline.Transfer) error {
+		if atomic.LoadInt32(&broken) == 1 {
+			return errors.New("connection refused")
+		}
+		return appendHandler("b")(t)
+	})
+
+	flow := spec.PipelineFlow{
+		Starts: "a",
+		Ends:   "b",
+		Stages: map[string]spec.Stage{
+			"a": {Uses: pipelinetest.Ref("a")},
+			"b": {Uses: pipelinetest.Ref("b"), Deps: []string{"a"}, Retry: &spec.RetryPolicy{MaxAttempts: 2, InitialBackoff: spec.Duration(10 * time.Millisecond)}},
+		},
+	}
+
+	requeue := func(p *pipeline.Pipeline, id string, stage string) ([]byte, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		r, err := p.Requeue(ctx, id, stage)
+		if err != nil {
+			return nil, err
+		}
+
+		<-r.Done()
+
+		if err := r.Err(); err != nil {
+			return nil, err
+		}
+
+		return pipelinetest.ReadAll(r)
+	}
+
+	t.Run("failed", func(t *testing.T) {
+		atomic.StoreInt32(&broken, 1)
+
+		p := startPipeline(t, pc, operatorMgr, "dead-letters", flow)
+		defer p.Stop()
+
+		_, err := pipelinetest.Run(p, "input:")
+		NewWithT(t).Expect(err).NotTo(BeNil())
+
+		letters, err := p.DeadLetters(context.Background())
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(letters).To(HaveLen(1))
+		NewWithT(t).Expect(letters[0].Stage).To(Equal("b"))
+		NewWithT(t).Expect(letters[0].Reason).To(Equal(pipeline.DeadLetterFailed))
+		NewWithT(t).Expect(letters[0].Errors).To(Equal([]string{"connection refused", "connection refused (after 2 attempts)"}))
+
+		atomic.StoreInt32(&broken, 0)
+
+		data, err := requeue(p, letters[0].ID, "b")
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(string(data)).To(Equal("input:ab"))
+
+		letters, err = p.DeadLetters(context.Background())
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(letters).To(HaveLen(0))
+	})
+
+	t.Run("undeliverable", func(t *testing.T) {
+		atomic.StoreInt32(&broken, 0)
+
+		p := startPipeline(t, pc, operatorMgr, "dead-letters", flow)
+		defer p.Stop()
+
+		NewWithT(t).Expect(operatorMgr.Destroy(p.Scope(), "b")).To(BeNil())
+
+		_, err := pipelinetest.Run(p, "input:")
+		NewWithT(t).Expect(err).NotTo(BeNil())
+
+		letters, err := p.DeadLetters(context.Background())
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(letters).To(HaveLen(1))
+		NewWithT(t).Expect(letters[0].Reason).To(Equal(pipeline.DeadLetterUndeliverable))
+
+		NewWithT(t).Expect(p.Start()).To(BeNil())
+
+		// rerun from starts with the stored input
+		data, err := requeue(p, letters[0].ID, "a")
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(string(data)).To(Equal("input:ab"))
+
+		NewWithT(t).Expect(p.PurgeDeadLetters(context.Background())).To(BeNil())
+	})
+
+	t.Run("requeue to upstream stage with recorded inputs", func(t *testing.T) {
+		partial := int32(1)
+
+		_ = operatorMgr.Register(pipelinetest.Ref("partial"), func(t pipeline.Transfer) error {
+			if atomic.CompareAndSwapInt32(&partial, 1, 0) {
+				_ = t.Put(bytes.NewBufferString("partial"))
+				return errors.New("connection reset")
+			}
+			return appendHandler("p")(t)
+		})
+
+		atomic.StoreInt32(&broken, 1)
+
+		p := startPipeline(t, pc, operatorMgr, "dead-letters-upstream", spec.PipelineFlow{
+			Starts: "a",
+			Ends:   "b",
+			Stages: map[string]spec.Stage{
+				"a": {Uses: pipelinetest.Ref("partial"), Retry: &spec.RetryPolicy{MaxAttempts: 2, InitialBackoff: spec.Duration(10 * time.Millisecond)}},
+				"c": {Uses: pipelinetest.Ref("a"), Deps: []string{"a"}},
+				"b": {Uses: pipelinetest.Ref("b"), Deps: []string{"c"}},
+			},
+		})
+		defer p.Stop()
+
+		_, err := pipelinetest.Run(p, "input:")
+		NewWithT(t).Expect(err).NotTo(BeNil())
+
+		letters, err := p.DeadLetters(context.Background())
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(letters).To(HaveLen(1))
+
+		atomic.StoreInt32(&broken, 0)
+
+		// partial output of failed attempt of a not taken
+		data, err := requeue(p, letters[0].ID, "c")
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(string(data)).To(Equal("input:pab"))
+	})
+}
+
+func TestPipelineReplay(t *testing.T) {
+	pc := pipelinetest.NewPipelineController()
+	operatorMgr := memoperator.NewMemOperatorMgr(pc)
+
+	calls := map[string]*int64{}
+
+	for _, name := range []string{"a", "b", "c", "d"} {
+		name := name
+		calls[name] = new(int64)
+
+		_ = operatorMgr.Register(pipelinetest.Ref(name), func(t pipeline.Transfer) error {
+			atomic.AddInt64(calls[name], 1)
+			return appendHandler(name)(t)
+		})
+	}
+
+	p := startPipeline(t, pc, operatorMgr, "replay", spec.PipelineFlow{
+		Starts: "a",
+		Ends:   "d",
+		Stages: map[string]spec.Stage{
+			"a": {Uses: pipelinetest.Ref("a")},
+			"b": {Uses: pipelinetest.Ref("b"), Deps: []string{"a"}},
+			"c": {Uses: pipelinetest.Ref("c"), Deps: []string{"a"}},
+			"d": {Uses: pipelinetest.Ref("d"), Deps: []string{"b", "c"}},
+		},
+	})
+	defer p.Stop()
+
+	wait := func(r pipeline.Result, err error) ([]byte, error) {
+		if err != nil {
+			return nil, err
+		}
+		<-r.Done()
+		if err := r.Err(); err != nil {
+			return nil, err
+		}
+		return pipelinetest.ReadAll(r)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	r, err := p.Next(ctx, bytes.NewBufferString("input:"))
+	NewWithT(t).Expect(err).To(BeNil())
+
+	data, err := wait(r, nil)
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(string(data)).To(Equal("input:abinput:acd"))
+
+	t.Run("from stage joined with stored results", func(t *testing.T) {
+		replayed, err := p.Replay(ctx, r.TaskID(), "c")
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(replayed.TaskID()).NotTo(Equal(r.TaskID()))
+
+		data, err := wait(replayed, nil)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(string(data)).To(Equal("input:abinput:acd"))
+
+		NewWithT(t).Expect(atomic.LoadInt64(calls["a"])).To(Equal(int64(1)))
+		NewWithT(t).Expect(atomic.LoadInt64(calls["b"])).To(Equal(int64(1)))
+		NewWithT(t).Expect(atomic.LoadInt64(calls["c"])).To(Equal(int64(2)))
+		NewWithT(t).Expect(atomic.LoadInt64(calls["d"])).To(Equal(int64(2)))
+	})
+
+	t.Run("from starts", func(t *testing.T) {
+		data, err := wait(p.Replay(ctx, r.TaskID(), "a"))
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(string(data)).To(Equal("input:abinput:acd"))
+	})
+
+	t.Run("unknown stage", func(t *testing.T) {
+		_, err := p.Replay(ctx, r.TaskID(), "x")
+		NewWithT(t).Expect(err).NotTo(BeNil())
+	})
+}
+
+type statCountingStorage struct {
+	pipeline.Storage
+	stats int64
+}
+
+func (s *statCountingStorage) Stat(ctx context.Context, path string) (*pipeline.ObjectInfo, error) {
+	atomic.AddInt64(&s.stats, 1)
+	return s.Storage.Stat(ctx, path)
+}
+
+func TestPipelineResultCache(t *testing.T) {
+	s := &statCountingStorage{Storage: fs.NewFsStorage(afero.NewMemMapFs())}
+	pc := pipeline.NewPipelineController(mem.NewMemEventBus(), s, &pipelinetest.IDGen{}, pipelinetest.MachineIdentifier("test"), pipeline.WithResultCache(memresultcache.NewMemResultCache()))
+	operatorMgr := memoperator.NewMemOperatorMgr(pc)
+
+	calls := int64(0)
+
+	_ = operatorMgr.Register(pipelinetest.Ref("a"), appendHandler("a"))
+	_ = operatorMgr.Register(pipelinetest.Ref("b"), func(t pipeline.Transfer) error {
+		atomic.AddInt64(&calls, 1)
+		return appendHandler("b")(t)
+	})
+
+	flow := spec.PipelineFlow{
+		Starts: "a",
+		Ends:   "b",
+		Stages: map[string]spec.Stage{
+			"a": {Uses: pipelinetest.Ref("a")},
+			"b": {Uses: pipelinetest.Ref("b"), Deps: []string{"a"}, Cache: &spec.Cache{}},
+		},
+	}
+
+	p := startPipeline(t, pc, operatorMgr, "result-cache", flow)
+	defer p.Stop()
+
+	for i := 0; i < 3; i++ {
+		data, err := pipelinetest.Run(p, "input:")
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(string(data)).To(Equal("input:ab"))
+	}
+
+	NewWithT(t).Expect(atomic.LoadInt64(&calls)).To(Equal(int64(1)))
+	// checksums of inputs carried from a
+	NewWithT(t).Expect(atomic.LoadInt64(&s.stats)).To(Equal(int64(0)))
+
+	data, err := pipelinetest.Run(p, "other:")
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(string(data)).To(Equal("other:ab"))
+
+	NewWithT(t).Expect(atomic.LoadInt64(&calls)).To(Equal(int64(2)))
+
+	t.Run("shared by pipelines", func(t *testing.T) {
+		other := startPipeline(t, pc, operatorMgr, "result-cache", flow)
+		defer other.Stop()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		r, err := other.Next(ctx, bytes.NewBufferString("input:"))
+		NewWithT(t).Expect(err).To(BeNil())
+
+		<-r.Done()
+		NewWithT(t).Expect(r.Err()).To(BeNil())
+
+		NewWithT(t).Expect(atomic.LoadInt64(&calls)).To(Equal(int64(2)))
+
+		// purged the pipeline cached them
+		scoped := pipeline.StorageWithBasePath(s, p.Scope())
+		list, err := scoped.List(ctx, "")
+		NewWithT(t).Expect(err).To(BeNil())
+		for _, o := range list {
+			NewWithT(t).Expect(scoped.Del(ctx, o.Path)).To(BeNil())
+		}
+
+		// cached outputs copied
+		data, err := pipelinetest.ReadAll(r)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(string(data)).To(Equal("input:ab"))
+
+		// cached outputs gone
+		data, err = pipelinetest.Run(other, "input:")
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(string(data)).To(Equal("input:ab"))
+
+		NewWithT(t).Expect(atomic.LoadInt64(&calls)).To(Equal(int64(3)))
+	})
+}
+
+func TestPipelineValidateSchemas(t *testing.T) {
+	pc := pipelinetest.NewPipelineController()
+	operatorMgr := memoperator.NewMemOperatorMgr(pc)
+
+	jsonOperator := func(name string, schema spec.Schema) *spec.Operator {
+		return &spec.Operator{
+			Project: spec.Project{Group: "test", Name: name, Version: *semver.MustParseVersion("1.0.0")},
+			OperatorMeta: spec.OperatorMeta{
+				Inputs:  spec.DataSchema{ContentType: "application/json", Schema: schema},
+				Outputs: spec.DataSchema{ContentType: "application/json", Schema: schema},
+			},
+		}
+	}
+
+	person := spec.Schema{
+		Type:       "object",
+		Required:   []string{"name"},
+		Properties: map[string]*spec.Schema{"name": {Type: "string"}},
+	}
+
+	_ = operatorMgr.Register(jsonOperator("echo", person), func(t pipeline.Transfer) error {
+		data, err := pipelinetest.ReadAll(t)
+		if err != nil {
+			return err
+		}
+		return t.Put(pipeline.WithContentType("application/json")(bytes.NewBuffer(data)))
+	})
+
+	_ = operatorMgr.Register(jsonOperator("broken", person), func(t pipeline.Transfer) error {
+		return t.Put(pipeline.WithContentType("application/json")(bytes.NewBufferString(`{"name":1}`)))
+	})
+
+	run := func(uses string, validateSchemas bool, input string) ([]byte, error) {
+		p := startPipeline(t, pc, operatorMgr, "schemas-"+uses, spec.PipelineFlow{
+			Starts:          "a",
+			Ends:            "a",
+			ValidateSchemas: validateSchemas,
+			Stages: map[string]spec.Stage{
+				"a": {Uses: pipelinetest.Ref("test/" + uses)},
+			},
+		})
+		defer p.Stop()
+
+		return pipelinetest.Run(p, input)
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		data, err := run("echo", true, `{"name":"x"}`)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(string(data)).To(Equal(`{"name":"x"}`))
+	})
+
+	t.Run("invalid input", func(t *testing.T) {
+		_, err := run("echo", true, `{}`)
+		NewWithT(t).Expect(err).NotTo(BeNil())
+		NewWithT(t).Expect(err.Error()).To(ContainSubstring("schema violation"))
+		NewWithT(t).Expect(err.Error()).To(ContainSubstring("$.name: required"))
+	})
+
+	t.Run("invalid output", func(t *testing.T) {
+		_, err := run("broken", true, `{"name":"x"}`)
+		NewWithT(t).Expect(err).NotTo(BeNil())
+		NewWithT(t).Expect(err.Error()).To(ContainSubstring("output: $.name: should be string, but got number"))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		data, err := run("echo", false, `{}`)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(string(data)).To(Equal(`{}`))
+	})
+}
+
+func TestNackDelay(t *testing.T) {
+	NewWithT(t).Expect(pipeline.NackDelay(errors.New("x"), time.Second)).To(Equal(time.Second))
+	NewWithT(t).Expect(pipeline.NackDelay(pipeline.NackWithDelay(errors.New("x"), 3*time.Second), time.Second)).To(Equal(3 * time.Second))
+	NewWithT(t).Expect(pipeline.NackDelay(pipeline.NackWithDelay(errors.New("x"), time.Millisecond), time.Second)).To(Equal(time.Second))
+}
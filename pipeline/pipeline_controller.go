@@ -1,7 +1,34 @@
 package pipeline
 
-func NewPipelineController(eventBus EventBus, s Storage, idGen IDGen, machineIdentifier MachineIdentifier) PipelineController {
-	return &pipelineController{EventBus: eventBus, Storage: s, IDGen: idGen, MachineIdentifier: machineIdentifier}
+func NewPipelineController(eventBus EventBus, s Storage, idGen IDGen, machineIdentifier MachineIdentifier, options ...PipelineControllerOption) PipelineController {
+	c := &pipelineController{EventBus: eventBus, Storage: s, IDGen: idGen, MachineIdentifier: machineIdentifier}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+type PipelineControllerOption = func(c *pipelineController)
+
+// WithTaskStore to record lifecycle of tasks
+func WithTaskStore(taskStore TaskStore) PipelineControllerOption {
+	return func(c *pipelineController) {
+		c.taskStore = taskStore
+	}
+}
+
+// WithDeadLetterStore to keep tasks failed or undeliverable
+func WithDeadLetterStore(deadLetterStore DeadLetterStore) PipelineControllerOption {
+	return func(c *pipelineController) {
+		c.deadLetterStore = deadLetterStore
+	}
+}
+
+// WithResultCache to skip handlers of stages with cache enabled, when the same inputs handled before
+func WithResultCache(resultCache ResultCache) PipelineControllerOption {
+	return func(c *pipelineController) {
+		c.resultCache = resultCache
+	}
 }
 
 type PipelineController interface {
@@ -12,26 +39,62 @@ type PipelineController interface {
 
 	WithScope(scope string) PipelineController
 	Scope() string
+	// Root returns the PipelineController without scope, for objects shared between pipelines, like cached results
+	Root() PipelineController
+
+	// TaskStore could be nil
+	TaskStore() TaskStore
+	// DeadLetterStore could be nil
+	DeadLetterStore() DeadLetterStore
+	// ResultCache could be nil
+	ResultCache() ResultCache
 }
 
 type pipelineController struct {
 	scope string
+	root  *pipelineController
 	EventBus
 	Storage
 	IDGen
 	MachineIdentifier
+	taskStore       TaskStore
+	deadLetterStore DeadLetterStore
+	resultCache     ResultCache
 }
 
 func (p *pipelineController) Scope() string {
 	return p.scope
 }
 
+func (p *pipelineController) Root() PipelineController {
+	if p.root == nil {
+		return p
+	}
+	return p.root
+}
+
+func (p *pipelineController) TaskStore() TaskStore {
+	return p.taskStore
+}
+
+func (p *pipelineController) DeadLetterStore() DeadLetterStore {
+	return p.deadLetterStore
+}
+
+func (p *pipelineController) ResultCache() ResultCache {
+	return p.resultCache
+}
+
 func (p *pipelineController) WithScope(scope string) PipelineController {
 	return &pipelineController{
 		scope:             scope,
+		root:              p.Root().(*pipelineController),
 		IDGen:             p.IDGen,
 		MachineIdentifier: p.MachineIdentifier,
 		EventBus:          EventBusWithPrefix(p.EventBus, scope),
 		Storage:           StorageWithBasePath(p.Storage, scope),
+		taskStore:         p.taskStore,
+		deadLetterStore:   p.deadLetterStore,
+		resultCache:       p.resultCache,
 	}
 }
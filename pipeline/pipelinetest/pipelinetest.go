@@ -0,0 +1,82 @@
+package pipelinetest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-courier/semver"
+	"github.com/querycap/pipeline/pipeline"
+	"github.com/querycap/pipeline/pipeline/eventbus/mem"
+	"github.com/querycap/pipeline/pipeline/storage/fs"
+	"github.com/querycap/pipeline/spec"
+	"github.com/spf13/afero"
+)
+
+// IDGen generates sequential ids from 1
+type IDGen struct {
+	id uint64
+}
+
+func (g *IDGen) ID() (uint64, error) {
+	return atomic.AddUint64(&g.id, 1), nil
+}
+
+type MachineIdentifier string
+
+func (m MachineIdentifier) MachineID() (string, error) {
+	return string(m), nil
+}
+
+// NewPipelineController creates PipelineController with mem event bus and storage
+func NewPipelineController(options ...pipeline.PipelineControllerOption) pipeline.PipelineController {
+	return pipeline.NewPipelineController(
+		mem.NewMemEventBus(),
+		fs.NewFsStorage(afero.NewMemMapFs()),
+		&IDGen{},
+		MachineIdentifier("test"),
+		options...,
+	)
+}
+
+// Ref refers operator name at 1.0.0
+func Ref(name string) spec.Ref {
+	return *spec.NewRefOperator(name, *semver.MustParseVersion("1.0.0"))
+}
+
+// ReadAll reads all outputs of r joined
+func ReadAll(r pipeline.Receiver) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+
+	for r.Scan() {
+		if err := pipeline.ReadNext(r, func(r io.Reader) error {
+			_, err := io.Copy(buf, r)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Run runs task with input through p, and returns outputs once done, in 5 seconds
+func Run(p *pipeline.Pipeline, input string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	r, err := p.Next(ctx, bytes.NewBufferString(input))
+	if err != nil {
+		return nil, err
+	}
+
+	<-r.Done()
+
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+
+	return ReadAll(r)
+}
@@ -1,22 +1,35 @@
 package pipeline
 
+import "sync"
+
 type Result interface {
+	TaskID() uint64
 	Done() <-chan struct{}
 	Err() error
 
 	Receiver
 }
 
-func newResult() *result {
+func newResult(taskID uint64) *result {
 	return &result{
-		done: make(chan struct{}, 1),
+		taskID:   taskID,
+		done:     make(chan struct{}, 1),
+		finished: make(chan struct{}),
 	}
 }
 
 type result struct {
-	done chan struct{}
-	err  error
+	taskID uint64
+	once   sync.Once
+	done   chan struct{}
+	err    error
 	Receiver
+
+	finished chan struct{}
+}
+
+func (r *result) TaskID() uint64 {
+	return r.taskID
 }
 
 func (r *result) Done() <-chan struct{} {
@@ -28,10 +41,13 @@ func (r *result) Err() error {
 }
 
 func (r *result) finish(receiver Receiver, err error) {
-	if err != nil {
-		r.err = err
-	} else {
-		r.Receiver = receiver
-	}
-	r.done <- struct{}{}
+	r.once.Do(func() {
+		if err != nil {
+			r.err = err
+		} else {
+			r.Receiver = receiver
+		}
+		r.done <- struct{}{}
+		close(r.finished)
+	})
 }
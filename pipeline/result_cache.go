@@ -0,0 +1,208 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path/filepath"
+	"time"
+
+	"github.com/querycap/pipeline/spec"
+)
+
+var ErrCacheMiss = errors.New("cache miss")
+
+// ResultCache indexes output paths of stage by cache key, paths are without scope since shared between pipelines.
+type ResultCache interface {
+	// Get returns ErrCacheMiss when not cached or expired
+	Get(ctx context.Context, key string) ([]string, error)
+	// Set with ttl, kept forever when ttl is zero
+	Set(ctx context.Context, key string, outputs []string, ttl time.Duration) error
+}
+
+// StageCache of stage, with the key part not changed by tasks.
+type StageCache struct {
+	// hash of operator ref, container and http endpoint of stage
+	Key string
+	TTL spec.Duration `json:",omitempty"`
+}
+
+func StageCacheFromStage(stage spec.Stage) (*StageCache, error) {
+	if stage.Cache == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(struct {
+		Uses      string
+		Container spec.Container
+		HTTP      *spec.HTTPEndpoint `json:",omitempty"`
+	}{
+		Uses:      stage.Uses.String(),
+		Container: stage.Container,
+		HTTP:      stage.HTTP,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &StageCache{Key: hash(data), TTL: stage.Cache.TTL}, nil
+}
+
+// newStageCacheEntry returns nil when cache of stage disabled,
+// or could not be addressed, like no checksum for input objects.
+// The key not includes scope, so results shared between pipelines of the same stage.
+// Checksums of inputs carried by task are used, otherwise stat from Storage, which may read whole object.
+func newStageCacheEntry(pipelineController PipelineController, ctx context.Context, task *Task) (*stageCacheEntry, error) {
+	resultCache := pipelineController.ResultCache()
+	if resultCache == nil {
+		return nil, nil
+	}
+
+	stageCache, ok := task.StageCaches[task.Stage]
+	if !ok {
+		return nil, nil
+	}
+
+	parts := []string{stageCache.Key}
+
+	for _, input := range task.Inputs {
+		if checksum, ok := task.Checksums[input]; ok {
+			parts = append(parts, checksum)
+			continue
+		}
+
+		info, err := pipelineController.Stat(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("stat %s failed: %w", input, err)
+		}
+
+		if info.Checksum == "" {
+			return nil, nil
+		}
+
+		parts = append(parts, info.Checksum)
+	}
+
+	data, err := json.Marshal(parts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stageCacheEntry{
+		pipelineController: pipelineController,
+		resultCache:        resultCache,
+		key:                hash(data),
+		ttl:                time.Duration(stageCache.TTL),
+	}, nil
+}
+
+type stageCacheEntry struct {
+	pipelineController PipelineController
+	resultCache        ResultCache
+	key                string
+	ttl                time.Duration
+}
+
+// lookup returns paths of cached outputs without scope, or nil when missed or any of outputs deleted.
+func (e *stageCacheEntry) lookup(ctx context.Context) ([]string, error) {
+	outputs, err := e.resultCache.Get(ctx, e.key)
+	if err != nil {
+		if err == ErrCacheMiss {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	for _, output := range outputs {
+		ok, err := e.pipelineController.Root().Exists(ctx, output)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, nil
+		}
+	}
+
+	return outputs, nil
+}
+
+// restore copies cached outputs as outputs of the task,
+// since cached ones could be deleted with the task put them, by Retention.
+func (e *stageCacheEntry) restore(t *transfer, outputs []string) error {
+	for _, output := range outputs {
+		if err := e.copy(t, output); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *stageCacheEntry) copy(t *transfer, output string) error {
+	f, err := e.pipelineController.Root().Read(t.Context(), output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return t.Put(WithContentType(mime.TypeByExtension(filepath.Ext(output)))(AsWriterTo(f)))
+}
+
+// store outputs with scope, so could be restored in other pipelines
+func (e *stageCacheEntry) store(ctx context.Context, outputs []string) error {
+	if len(outputs) == 0 {
+		return nil
+	}
+
+	paths := make([]string, len(outputs))
+	for i := range outputs {
+		paths[i] = filepath.Join(e.pipelineController.Scope(), outputs[i])
+	}
+
+	return e.resultCache.Set(ctx, e.key, paths, e.ttl)
+}
+
+// withChecksum returns writerTo hashing content written, and checksum to call after written
+func withChecksum(writerTo io.WriterTo) (io.WriterTo, func() string) {
+	h := sha256.New()
+
+	var hashed io.WriterTo = WriteTo(func(w io.Writer) (int64, error) {
+		return writerTo.WriteTo(io.MultiWriter(w, h))
+	})
+
+	// keeps length and content type for Storage
+	withLen, hasLen := writerTo.(WithLen)
+	contentTypeDescriber, hasContentType := writerTo.(ContentTypeDescriber)
+
+	switch {
+	case hasLen && hasContentType:
+		hashed = struct {
+			io.WriterTo
+			WithLen
+			ContentTypeDescriber
+		}{hashed, withLen, contentTypeDescriber}
+	case hasLen:
+		hashed = struct {
+			io.WriterTo
+			WithLen
+		}{hashed, withLen}
+	case hasContentType:
+		hashed = struct {
+			io.WriterTo
+			ContentTypeDescriber
+		}{hashed, contentTypeDescriber}
+	}
+
+	return hashed, func() string {
+		return hex.EncodeToString(h.Sum(nil))
+	}
+}
+
+func hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
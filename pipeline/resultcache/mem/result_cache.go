@@ -0,0 +1,58 @@
+package mem
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/querycap/pipeline/pipeline"
+)
+
+func NewMemResultCache() pipeline.ResultCache {
+	return &MemResultCache{
+		entries: map[string]*entry{},
+	}
+}
+
+type entry struct {
+	outputs   []string
+	expiresAt time.Time
+}
+
+type MemResultCache struct {
+	rw      sync.RWMutex
+	entries map[string]*entry
+}
+
+func (m *MemResultCache) Get(ctx context.Context, key string) ([]string, error) {
+	m.rw.RLock()
+	defer m.rw.RUnlock()
+
+	e, ok := m.entries[key]
+	if !ok || (!e.expiresAt.IsZero() && time.Now().After(e.expiresAt)) {
+		return nil, pipeline.ErrCacheMiss
+	}
+
+	return append([]string{}, e.outputs...), nil
+}
+
+func (m *MemResultCache) Set(ctx context.Context, key string, outputs []string, ttl time.Duration) error {
+	m.rw.Lock()
+	defer m.rw.Unlock()
+
+	e := &entry{outputs: append([]string{}, outputs...)}
+	if ttl > 0 {
+		e.expiresAt = time.Now().Add(ttl)
+	}
+
+	m.entries[key] = e
+
+	// drop expired
+	for k, e := range m.entries {
+		if !e.expiresAt.IsZero() && time.Now().After(e.expiresAt) {
+			delete(m.entries, k)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/querycap/pipeline/pipeline"
+)
+
+type RedisPool interface {
+	Get() redis.Conn
+}
+
+// NewRedisResultCache creates ResultCache on redis, outputs of key kept in result_cache:<key>
+func NewRedisResultCache(pool RedisPool) pipeline.ResultCache {
+	return &RedisResultCache{pool: pool}
+}
+
+type RedisResultCache struct {
+	pool RedisPool
+}
+
+func (r *RedisResultCache) Get(ctx context.Context, key string) ([]string, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("GET", cacheKey(key)))
+	if err != nil {
+		if err == redis.ErrNil {
+			return nil, pipeline.ErrCacheMiss
+		}
+		return nil, err
+	}
+
+	outputs := make([]string, 0)
+	if err := json.Unmarshal(data, &outputs); err != nil {
+		return nil, err
+	}
+
+	return outputs, nil
+}
+
+func (r *RedisResultCache) Set(ctx context.Context, key string, outputs []string, ttl time.Duration) error {
+	data, err := json.Marshal(outputs)
+	if err != nil {
+		return err
+	}
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	args := redis.Args{}.Add(cacheKey(key), data)
+	if ttl > 0 {
+		args = args.Add("PX", int64(ttl/time.Millisecond))
+	}
+
+	_, err = conn.Do("SET", args...)
+	return err
+}
+
+func cacheKey(key string) string {
+	return strings.Join([]string{"result_cache", key}, ":")
+}
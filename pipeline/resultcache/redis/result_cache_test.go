@@ -0,0 +1,38 @@
+package redis_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/querycap/pipeline/pipeline"
+	"github.com/querycap/pipeline/pipeline/resultcache/redis"
+	"github.com/querycap/pipeline/pkg/redisutil"
+)
+
+var pool, _ = redisutil.NewPool("tcp://127.0.0.1:6379")
+
+func TestRedisResultCache(t *testing.T) {
+	c := redis.NewRedisResultCache(pool)
+
+	ctx := context.Background()
+	key := fmt.Sprintf("test:%d", time.Now().UnixNano())
+
+	_, err := c.Get(ctx, key)
+	NewWithT(t).Expect(err).To(Equal(pipeline.ErrCacheMiss))
+
+	NewWithT(t).Expect(c.Set(ctx, key, []string{"a", "b"}, 0)).To(BeNil())
+
+	outputs, err := c.Get(ctx, key)
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(outputs).To(Equal([]string{"a", "b"}))
+
+	NewWithT(t).Expect(c.Set(ctx, key, []string{"c"}, 10*time.Millisecond)).To(BeNil())
+
+	NewWithT(t).Eventually(func() error {
+		_, err := c.Get(ctx, key)
+		return err
+	}, 3*time.Second).Should(Equal(pipeline.ErrCacheMiss))
+}
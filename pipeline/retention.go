@@ -0,0 +1,222 @@
+package pipeline
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultSweepInterval of Retention run by Pipeline.Start
+const DefaultSweepInterval = time.Minute
+
+type RetentionPolicy struct {
+	// how long intermediate stage outputs kept after the task succeeded,
+	// deleted at the next sweep when zero.
+	// intermediates of tasks failed are kept for Replay and Requeue,
+	// until the state of task expired in TaskStore and no dead letter of it left.
+	// without TaskStore, states of tasks unknown, so they are deleted after IntermediateTTL since written, and kept when zero.
+	IntermediateTTL time.Duration
+	// how long final outputs kept after written, kept forever when zero.
+	FinalTTL time.Duration
+	// how often Pipeline.Start sweeps, DefaultSweepInterval when zero.
+	SweepInterval time.Duration
+	// purge the whole scope of pipeline after Pipeline.Stop
+	PurgeOnStop bool
+}
+
+func NewRetention(pipelineController PipelineController, taskMeta *TaskMeta, policy RetentionPolicy) *Retention {
+	return &Retention{
+		pipelineController: pipelineController,
+		taskMeta:           taskMeta,
+		policy:             policy,
+	}
+}
+
+// Retention deletes task artifacts in Storage of a pipeline scope.
+type Retention struct {
+	pipelineController PipelineController
+	taskMeta           *TaskMeta
+	policy             RetentionPolicy
+}
+
+func (r *Retention) Policy() RetentionPolicy {
+	return r.policy
+}
+
+// Sweep deletes final outputs out of FinalTTL, and intermediate outputs of tasks by their states.
+func (r *Retention) Sweep(ctx context.Context) error {
+	list, err := r.pipelineController.List(ctx, tasksPath(0))
+	if err != nil {
+		return err
+	}
+
+	deadLetters, err := r.deadLetterTaskIDs(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	// cache of task states, nil when task not found
+	states := map[uint64]*TaskState{}
+
+	for _, o := range list {
+		expired := false
+
+		if stageOfPath(o.Path) == r.taskMeta.Ends {
+			expired = r.policy.FinalTTL > 0 && now.Sub(o.LastModified) >= r.policy.FinalTTL
+		} else {
+			taskID := taskIDOfPath(o.Path)
+
+			state, ok := states[taskID]
+			if !ok {
+				state, err = r.taskState(ctx, taskID)
+				if err != nil {
+					return err
+				}
+				states[taskID] = state
+			}
+
+			expired = r.intermediateExpired(o, state, deadLetters[taskID], now)
+		}
+
+		if !expired {
+			continue
+		}
+
+		if err := r.pipelineController.Del(ctx, o.Path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Retention) intermediateExpired(o ObjectInfo, state *TaskState, hasDeadLetter bool, now time.Time) bool {
+	if r.pipelineController.TaskStore() == nil {
+		return r.policy.IntermediateTTL > 0 && now.Sub(o.LastModified) >= r.policy.IntermediateTTL
+	}
+
+	// state expired, could not be replayed any more.
+	if state == nil {
+		return !hasDeadLetter
+	}
+
+	switch state.Status {
+	case TaskStatusSucceeded:
+		return now.Sub(state.UpdatedAt) >= r.policy.IntermediateTTL
+	default:
+		// in-flight, or failed for Replay and Requeue
+		return false
+	}
+}
+
+// taskState returns nil when task not found
+func (r *Retention) taskState(ctx context.Context, taskID uint64) (*TaskState, error) {
+	taskStore := r.pipelineController.TaskStore()
+	if taskStore == nil {
+		return nil, nil
+	}
+
+	var state *TaskState
+
+	events, err := taskStore.Events(ctx, taskID)
+	if err == nil {
+		state, err = TaskStateFromEvents(events)
+	}
+	if err != nil {
+		// expired or never recorded
+		if err == ErrTaskNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return state, nil
+}
+
+func (r *Retention) deadLetterTaskIDs(ctx context.Context) (map[uint64]bool, error) {
+	taskIDs := map[uint64]bool{}
+
+	deadLetterStore := r.pipelineController.DeadLetterStore()
+	if deadLetterStore == nil {
+		return taskIDs, nil
+	}
+
+	letters, err := deadLetterStore.List(ctx, r.taskMeta.Scope)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, letter := range letters {
+		taskIDs[letter.Task.ID] = true
+	}
+
+	return taskIDs, nil
+}
+
+// Purge deletes all objects of the pipeline scope.
+func (r *Retention) Purge(ctx context.Context) error {
+	list, err := r.pipelineController.List(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	for _, o := range list {
+		if err := r.pipelineController.Del(ctx, o.Path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Run sweeps every interval until ctx done.
+func (r *Retention) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Sweep(ctx); err != nil {
+				logrus.WithContext(ctx).Warnf("sweep %s failed: %s", r.taskMeta.Scope, err)
+			}
+		}
+	}
+}
+
+// tasksPath returns the path prefix of outputs of task, or of all tasks when taskID is zero.
+func tasksPath(taskID uint64) string {
+	if taskID == 0 {
+		return "tasks/"
+	}
+	return filepath.Join("tasks", strconv.FormatUint(taskID, 10)) + "/"
+}
+
+func stageResultsPath(taskID uint64, stage string) string {
+	return filepath.Join("tasks", strconv.FormatUint(taskID, 10), "stages", stage, "results")
+}
+
+// taskIDOfPath parses task id from tasks/<id>/..., zero when not matched
+func taskIDOfPath(path string) uint64 {
+	parts := strings.Split(path, "/")
+	if len(parts) < 2 || parts[0] != "tasks" {
+		return 0
+	}
+	taskID, _ := strconv.ParseUint(parts[1], 10, 64)
+	return taskID
+}
+
+// stageOfPath parses stage from tasks/<id>/stages/<stage>/results/<filename>
+func stageOfPath(path string) string {
+	parts := strings.Split(path, "/")
+	if len(parts) < 5 || parts[0] != "tasks" || parts[2] != "stages" {
+		return ""
+	}
+	return parts[3]
+}
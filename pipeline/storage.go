@@ -2,14 +2,37 @@ package pipeline
 
 import (
 	"context"
+	"errors"
 	"io"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 type Storage interface {
 	Read(ctx context.Context, path string) (io.ReadCloser, error)
 	Put(ctx context.Context, path string, writerTo io.WriterTo) error
 	Del(ctx context.Context, path string) error
+	// List returns all objects which path starts with prefix, in order of path
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// Stat returns ErrObjectNotFound when object not exists
+	Stat(ctx context.Context, path string) (*ObjectInfo, error)
+	Exists(ctx context.Context, path string) (bool, error)
+}
+
+var ErrObjectNotFound = errors.New("object not found")
+
+type ObjectInfo struct {
+	Path         string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+	// Checksum changes when content changed, but only comparable between objects of the same Storage.
+	// md5 of content in hex for fs.
+	// ETag of object for s3, which is md5 of content only when uploaded in single part,
+	// but md5 of md5s of parts with part count suffixed (<hex>-<n>) when multipart uploaded, so not a content checksum.
+	// may be empty in List.
+	Checksum string
 }
 
 type WithLen interface {
@@ -43,3 +66,42 @@ func (s *storageWithBathPath) Put(ctx context.Context, path string, writerTo io.
 func (s *storageWithBathPath) Del(ctx context.Context, path string) error {
 	return s.s.Del(ctx, filepath.Join(s.basePath, path))
 }
+
+func (s *storageWithBathPath) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	list, err := s.s.List(ctx, s.join(prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range list {
+		list[i].Path = s.trim(list[i].Path)
+	}
+
+	return list, nil
+}
+
+func (s *storageWithBathPath) Stat(ctx context.Context, path string) (*ObjectInfo, error) {
+	info, err := s.s.Stat(ctx, filepath.Join(s.basePath, path))
+	if err != nil {
+		return nil, err
+	}
+	info.Path = s.trim(info.Path)
+	return info, nil
+}
+
+func (s *storageWithBathPath) Exists(ctx context.Context, path string) (bool, error) {
+	return s.s.Exists(ctx, filepath.Join(s.basePath, path))
+}
+
+func (s *storageWithBathPath) trim(path string) string {
+	return strings.TrimPrefix(path, s.basePath+"/")
+}
+
+// join as filepath.Join, but keeps the trailing slash of prefix as directory
+func (s *storageWithBathPath) join(prefix string) string {
+	p := filepath.Join(s.basePath, prefix)
+	if prefix == "" || strings.HasSuffix(prefix, "/") {
+		p += "/"
+	}
+	return p
+}
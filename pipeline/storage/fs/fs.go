@@ -2,9 +2,13 @@ package fs
 
 import (
 	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"io"
+	"mime"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/querycap/pipeline/pipeline"
 	"github.com/spf13/afero"
@@ -44,3 +48,83 @@ func (f *FsStorage) Put(ctx context.Context, path string, writerTo io.WriterTo)
 	_, err = writerTo.WriteTo(file)
 	return err
 }
+
+func (f *FsStorage) List(ctx context.Context, prefix string) ([]pipeline.ObjectInfo, error) {
+	root := prefix
+	if !strings.HasSuffix(root, "/") {
+		root = filepath.Dir(root)
+	}
+
+	list := make([]pipeline.ObjectInfo, 0)
+
+	err := afero.Walk(f.fs, root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if info.IsDir() || !strings.HasPrefix(path, prefix) {
+			return nil
+		}
+
+		list = append(list, *objectInfo(path, info))
+
+		return nil
+	})
+
+	return list, err
+}
+
+// Stat reads whole content of file for md5 as Checksum
+func (f *FsStorage) Stat(ctx context.Context, path string) (*pipeline.ObjectInfo, error) {
+	info, err := f.fs.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, pipeline.ErrObjectNotFound
+		}
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return nil, pipeline.ErrObjectNotFound
+	}
+
+	file, err := f.fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return nil, err
+	}
+
+	o := objectInfo(path, info)
+	o.Checksum = hex.EncodeToString(h.Sum(nil))
+
+	return o, nil
+}
+
+// Exists stats file only, not reading content for Checksum as Stat
+func (f *FsStorage) Exists(ctx context.Context, path string) (bool, error) {
+	info, err := f.fs.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return !info.IsDir(), nil
+}
+
+func objectInfo(path string, info os.FileInfo) *pipeline.ObjectInfo {
+	return &pipeline.ObjectInfo{
+		Path:         path,
+		Size:         info.Size(),
+		ContentType:  mime.TypeByExtension(filepath.Ext(path)),
+		LastModified: info.ModTime(),
+	}
+}
@@ -37,3 +37,63 @@ func TestFsStorage(t *testing.T) {
 	}
 
 }
+
+func TestFsStorageList(t *testing.T) {
+	s := NewFsStorage(afero.NewMemMapFs())
+
+	for _, filename := range []string{"tasks/1/a", "tasks/1/b/c", "tasks/10/a", "tasks/2/a"} {
+		err := pipeline.PutWithCost(s, filename, bytes.NewBufferString(filename))
+		NewWithT(t).Expect(err).To(BeNil())
+	}
+
+	paths := func(list []pipeline.ObjectInfo) []string {
+		p := make([]string, len(list))
+		for i := range list {
+			p[i] = list[i].Path
+		}
+		return p
+	}
+
+	list, err := s.List(context.Background(), "tasks/1/")
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(paths(list)).To(Equal([]string{"tasks/1/a", "tasks/1/b/c"}))
+	NewWithT(t).Expect(list[0].Size).To(Equal(int64(len("tasks/1/a"))))
+
+	list, err = s.List(context.Background(), "tasks/1")
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(paths(list)).To(Equal([]string{"tasks/1/a", "tasks/1/b/c", "tasks/10/a"}))
+
+	list, err = pipeline.StorageWithBasePath(s, "tasks").List(context.Background(), "1/")
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(paths(list)).To(Equal([]string{"1/a", "1/b/c"}))
+
+	list, err = s.List(context.Background(), "not-exists/")
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(list).To(HaveLen(0))
+}
+
+func TestFsStorageStat(t *testing.T) {
+	s := pipeline.StorageWithBasePath(NewFsStorage(afero.NewMemMapFs()), "scope")
+
+	err := pipeline.PutWithCost(s, "tasks/1/a.json", bytes.NewBufferString("{}"))
+	NewWithT(t).Expect(err).To(BeNil())
+
+	info, err := s.Stat(context.Background(), "tasks/1/a.json")
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(info.Path).To(Equal("tasks/1/a.json"))
+	NewWithT(t).Expect(info.Size).To(Equal(int64(2)))
+	NewWithT(t).Expect(info.ContentType).To(Equal("application/json"))
+	NewWithT(t).Expect(info.Checksum).To(Equal("99914b932bd37a50b983c5e7c90ae93b"))
+	NewWithT(t).Expect(info.LastModified.IsZero()).To(BeFalse())
+
+	exists, err := s.Exists(context.Background(), "tasks/1/a.json")
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(exists).To(BeTrue())
+
+	_, err = s.Stat(context.Background(), "tasks/1/b.json")
+	NewWithT(t).Expect(err).To(Equal(pipeline.ErrObjectNotFound))
+
+	exists, err = s.Exists(context.Background(), "tasks/1")
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(exists).To(BeFalse())
+}
@@ -1,15 +1,27 @@
 package s3
 
 import (
-	"bytes"
 	"context"
 	"io"
+	"strings"
 
 	"github.com/minio/minio-go/v6"
 	"github.com/querycap/pipeline/pipeline"
 )
 
-func NewS3Storage(minio *minio.Client, bucket string) (pipeline.Storage, error) {
+// DefaultPartSize of multipart uploading, also the max size of memory buffered for each uploading.
+var DefaultPartSize uint64 = 16 * 1024 * 1024
+
+type S3StorageOption = func(s *S3Storage)
+
+// WithPartSize to set the part size of multipart uploading, should not be less than 5MiB.
+func WithPartSize(partSize uint64) S3StorageOption {
+	return func(s *S3Storage) {
+		s.partSize = partSize
+	}
+}
+
+func NewS3Storage(minio *minio.Client, bucket string, options ...S3StorageOption) (pipeline.Storage, error) {
 	exists, err := minio.BucketExists(bucket)
 	if err != nil {
 		return nil, err
@@ -21,15 +33,23 @@ func NewS3Storage(minio *minio.Client, bucket string) (pipeline.Storage, error)
 		}
 	}
 
-	return &S3Storage{
-		minio:  minio,
-		bucket: bucket,
-	}, nil
+	s := &S3Storage{
+		minio:    minio,
+		bucket:   bucket,
+		partSize: DefaultPartSize,
+	}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	return s, nil
 }
 
 type S3Storage struct {
-	minio  *minio.Client
-	bucket string
+	minio    *minio.Client
+	bucket   string
+	partSize uint64
 }
 
 func (f *S3Storage) Del(ctx context.Context, path string) error {
@@ -47,16 +67,84 @@ func (f *S3Storage) Put(ctx context.Context, path string, writerTo io.WriterTo)
 		contentType = contentTypeDescriber.ContentType()
 	}
 
-	buf := bytes.NewBuffer(nil)
+	// unknown size will be uploaded in parts
+	size := int64(-1)
+
+	if withLen, ok := writerTo.(pipeline.WithLen); ok {
+		size = int64(withLen.Len())
+	}
+
+	r, w := io.Pipe()
+	writeErr := make(chan error, 1)
+
+	go func() {
+		_, err := writerTo.WriteTo(w)
+		// EOF when err is nil
+		_ = w.CloseWithError(err)
+		writeErr <- err
+	}()
+
+	_, err := f.minio.PutObjectWithContext(ctx, f.bucket, path, r, size, minio.PutObjectOptions{
+		ContentType: contentType,
+		PartSize:    f.partSize,
+	})
+
+	// unblock writing when uploading stopped
+	_ = r.CloseWithError(err)
+
+	if errForWrite := <-writeErr; err == nil && errForWrite != nil {
+		// more data written than the size declared
+		return errForWrite
+	}
 
-	n, err := writerTo.WriteTo(buf)
+	return err
+}
+
+func (f *S3Storage) List(ctx context.Context, prefix string) ([]pipeline.ObjectInfo, error) {
+	done := make(chan struct{})
+	defer close(done)
+
+	list := make([]pipeline.ObjectInfo, 0)
+
+	for o := range f.minio.ListObjectsV2(f.bucket, prefix, true, done) {
+		if o.Err != nil {
+			return nil, o.Err
+		}
+
+		list = append(list, *objectInfo(o))
+	}
+
+	return list, nil
+}
+
+func (f *S3Storage) Stat(ctx context.Context, path string) (*pipeline.ObjectInfo, error) {
+	o, err := f.minio.StatObjectWithContext(ctx, f.bucket, path, minio.StatObjectOptions{})
 	if err != nil {
-		return err
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, pipeline.ErrObjectNotFound
+		}
+		return nil, err
 	}
+	return objectInfo(o), nil
+}
 
-	if _, err = f.minio.PutObjectWithContext(ctx, f.bucket, path, buf, n, minio.PutObjectOptions{ContentType: contentType}); err != nil {
-		return err
+func (f *S3Storage) Exists(ctx context.Context, path string) (bool, error) {
+	if _, err := f.Stat(ctx, path); err != nil {
+		if err == pipeline.ErrObjectNotFound {
+			return false, nil
+		}
+		return false, err
 	}
+	return true, nil
+}
 
-	return nil
+// objectInfo takes ETag as Checksum, which not md5 of content when multipart uploaded
+func objectInfo(o minio.ObjectInfo) *pipeline.ObjectInfo {
+	return &pipeline.ObjectInfo{
+		Path:         o.Key,
+		Size:         o.Size,
+		ContentType:  o.ContentType,
+		LastModified: o.LastModified,
+		Checksum:     strings.Trim(o.ETag, `"`),
+	}
 }
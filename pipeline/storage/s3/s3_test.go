@@ -3,7 +3,9 @@ package s3
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"strings"
 	"testing"
@@ -38,3 +40,78 @@ func TestS3Storage(t *testing.T) {
 	}
 
 }
+
+func TestS3StorageList(t *testing.T) {
+	s3, _ := s3util.NewS3("s3://minioadmin:minioadmin@127.0.0.1:9000")
+
+	s, _ := NewS3Storage(s3, "tmp")
+
+	for _, filename := range []string{"list/1/a", "list/1/b/c", "list/10/a"} {
+		err := pipeline.PutWithCost(s, filename, bytes.NewBufferString(filename))
+		NewWithT(t).Expect(err).To(BeNil())
+	}
+
+	list, err := s.List(context.Background(), "list/1/")
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(list).To(HaveLen(2))
+	NewWithT(t).Expect(list[0].Path).To(Equal("list/1/a"))
+	NewWithT(t).Expect(list[1].Path).To(Equal("list/1/b/c"))
+}
+
+func TestS3StorageStat(t *testing.T) {
+	s3, _ := s3util.NewS3("s3://minioadmin:minioadmin@127.0.0.1:9000")
+
+	s, _ := NewS3Storage(s3, "tmp")
+
+	err := pipeline.PutWithCost(s, "stat/a.json", pipeline.WithContentType("application/json")(bytes.NewBufferString("{}")))
+	NewWithT(t).Expect(err).To(BeNil())
+
+	info, err := s.Stat(context.Background(), "stat/a.json")
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(info.Size).To(Equal(int64(2)))
+	NewWithT(t).Expect(info.ContentType).To(Equal("application/json"))
+	NewWithT(t).Expect(info.Checksum).To(Equal("99914b932bd37a50b983c5e7c90ae93b"))
+
+	exists, err := s.Exists(context.Background(), "stat/b.json")
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(exists).To(BeFalse())
+}
+
+func TestS3StorageStreaming(t *testing.T) {
+	s3, _ := s3util.NewS3("s3://minioadmin:minioadmin@127.0.0.1:9000")
+
+	s, _ := NewS3Storage(s3, "tmp", WithPartSize(5*1024*1024))
+
+	chunk := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	chunks := 12
+
+	// size unknown
+	writerTo := pipeline.WriteTo(func(w io.Writer) (int64, error) {
+		n := int64(0)
+		for i := 0; i < chunks; i++ {
+			c, err := w.Write(chunk)
+			n += int64(c)
+			if err != nil {
+				return n, err
+			}
+		}
+		return n, nil
+	})
+
+	err := pipeline.PutWithCost(s, "streaming/large", writerTo)
+	NewWithT(t).Expect(err).To(BeNil())
+
+	info, err := s.Stat(context.Background(), "streaming/large")
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(info.Size).To(Equal(int64(len(chunk) * chunks)))
+
+	t.Run("failed when writing failed", func(t *testing.T) {
+		err := pipeline.PutWithCost(s, "streaming/failed", pipeline.WriteTo(func(w io.Writer) (int64, error) {
+			return 0, errors.New("broken")
+		}))
+		NewWithT(t).Expect(err).NotTo(BeNil())
+
+		exists, _ := s.Exists(context.Background(), "streaming/failed")
+		NewWithT(t).Expect(exists).To(BeFalse())
+	})
+}
@@ -3,6 +3,7 @@ package pipeline
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/textproto"
 	"strconv"
@@ -21,13 +22,13 @@ func Publish(pipelineController PipelineController, ctx context.Context, topic s
 	return pipelineController.Publish(ctx, topic, data)
 }
 
-func Subscribe(pipelineController PipelineController, topic string, callback func(ctx context.Context, task *Task)) Subscription {
-	return pipelineController.Subscribe(topic, func(ctx context.Context, data []byte) {
+func Subscribe(pipelineController PipelineController, topic string, callback func(ctx context.Context, task *Task) error) Subscription {
+	return pipelineController.Subscribe(topic, func(ctx context.Context, data []byte) error {
 		task := &Task{}
 		if err := json.Unmarshal(data, task); err != nil {
 			task.ErrMsg = err.Error()
 		}
-		callback(ctx, task)
+		return callback(ctx, task)
 	})
 }
 
@@ -35,48 +36,75 @@ func ContextWithTask(ctx context.Context, task *Task) context.Context {
 	return context.WithValue(ctx, "pipeline.task", task)
 }
 
+// TaskFromContext returns nil when ctx not of task
 func TaskFromContext(ctx context.Context) *Task {
-	return ctx.Value("pipeline.task").(*Task)
+	task, _ := ctx.Value("pipeline.task").(*Task)
+	return task
 }
 
-func TaskMetaFromPipeline(spec *spec.Pipeline, pipelineID uint64) (*TaskMeta, error) {
-	if spec == nil || spec.Stages == nil || len(spec.Stages) == 0 {
-		return nil, fmt.Errorf("pipeline %s missing steps", spec)
+func TaskMetaFromPipeline(p *spec.Pipeline, pipelineID uint64) (*TaskMeta, error) {
+	if p == nil {
+		return nil, fmt.Errorf("missing pipeline")
 	}
 
-	if spec.Starts == "" {
-		return nil, fmt.Errorf("pipeline %s unknown starts", spec)
-	}
-
-	if spec.Ends == "" {
-		return nil, fmt.Errorf("pipeline %s unknown ends", spec)
+	if err := p.Validate(); err != nil {
+		return nil, fmt.Errorf("pipeline %s invalid: %w", p, err)
 	}
 
 	taskMeta := TaskMeta{
-		Scope:     fmt.Sprintf("p/%s/%d", spec.RefID(), pipelineID),
-		Starts:    spec.Starts,
-		Ends:      spec.Ends,
-		StageDeps: map[string][]string{},
+		Scope:           PipelineScope(p.RefID(), pipelineID),
+		Starts:          p.Starts,
+		Ends:            p.Ends,
+		StageDeps:       map[string][]string{},
+		ValidateSchemas: p.ValidateSchemas,
 	}
 
-	for name, step := range spec.Stages {
+	for name, step := range p.Stages {
 		taskMeta.StageDeps[name] = step.Deps
 
-		for _, dep := range step.Deps {
-			if _, ok := spec.Stages[dep]; !ok {
-				return nil, fmt.Errorf("pipeline %s step %s has invalid dep %s", spec, step, dep)
+		if step.Retry != nil {
+			if taskMeta.StageRetryPolicies == nil {
+				taskMeta.StageRetryPolicies = map[string]spec.RetryPolicy{}
 			}
+			taskMeta.StageRetryPolicies[name] = *step.Retry
+		}
+
+		if step.Timeout > 0 {
+			if taskMeta.StageTimeouts == nil {
+				taskMeta.StageTimeouts = map[string]spec.Duration{}
+			}
+			taskMeta.StageTimeouts[name] = step.Timeout
+		}
+
+		stageCache, err := StageCacheFromStage(step)
+		if err != nil {
+			return nil, err
+		}
+
+		if stageCache != nil {
+			if taskMeta.StageCaches == nil {
+				taskMeta.StageCaches = map[string]StageCache{}
+			}
+			taskMeta.StageCaches[name] = *stageCache
 		}
 	}
 
 	return &taskMeta, nil
 }
 
+func PipelineScope(refID string, pipelineID uint64) string {
+	return fmt.Sprintf("p/%s/%d", refID, pipelineID)
+}
+
 type TaskMeta struct {
-	Scope     string
-	Starts    string
-	Ends      string
-	StageDeps map[string][]string
+	Scope              string
+	Starts             string
+	Ends               string
+	StageDeps          map[string][]string
+	StageRetryPolicies map[string]spec.RetryPolicy `json:",omitempty"`
+	StageTimeouts      map[string]spec.Duration    `json:",omitempty"`
+	StageCaches        map[string]StageCache       `json:",omitempty"`
+	ValidateSchemas    bool                        `json:",omitempty"`
 }
 
 func (taskMeta *TaskMeta) NewTask(taskID uint64) *Task {
@@ -92,6 +120,8 @@ func (taskMeta *TaskMeta) NewTask(taskID uint64) *Task {
 type TaskContext struct {
 	ID   uint64
 	Meta textproto.MIMEHeader `json:",omitempty"`
+	// Deadline of whole task
+	Deadline *time.Time `json:",omitempty"`
 	TaskMeta
 }
 
@@ -111,7 +141,15 @@ type TaskStage struct {
 
 	Stage  string
 	Inputs []string
-	ErrMsg string `json:",omitempty"`
+	// checksums of Inputs carried from upstream stage, for key of result cache without stat
+	Checksums map[string]string `json:",omitempty"`
+	ErrMsg    string            `json:",omitempty"`
+	// Attempt of stage, starts from 0
+	Attempt int `json:",omitempty"`
+	// Errors of previous attempts
+	Errors []string `json:",omitempty"`
+	// RetryAt, the attempt not handled before
+	RetryAt *time.Time `json:",omitempty"`
 }
 
 func (s TaskStage) Next(stage string, inputs []string) *TaskStage {
@@ -122,6 +160,9 @@ func (s TaskStage) Next(stage string, inputs []string) *TaskStage {
 		}
 	}
 
+	// not needed any more
+	s.Checksums = nil
+
 	return &TaskStage{
 		Stage:    stage,
 		Inputs:   inputs,
@@ -134,6 +175,15 @@ func (s TaskStage) Err(err error) *TaskStage {
 	return &s
 }
 
+func (s TaskStage) Retry() *TaskStage {
+	if s.ErrMsg != "" {
+		s.Errors = append(append([]string{}, s.Errors...), s.ErrMsg)
+	}
+	s.ErrMsg = ""
+	s.Attempt++
+	return &s
+}
+
 type Task struct {
 	TaskContext
 
@@ -154,55 +204,267 @@ func (t Task) Err(err error) *Task {
 	}
 }
 
-func ServeOperator(pipelineController PipelineController, stage string, operatorHandlerFunc OperatorHandlerFunc) Subscription {
+func (t Task) Retry() *Task {
+	return &Task{
+		TaskContext: t.TaskContext,
+		TaskStage:   t.TaskStage.Retry(),
+	}
+}
+
+var (
+	ErrStageTimeout = errors.New("stage timeout")
+	ErrTaskTimeout  = errors.New("task timeout")
+)
+
+var errRetryNotDue = errors.New("retry not due")
+
+type ServeOperatorOption = func(o *serveOperatorOptions)
+
+type serveOperatorOptions struct {
+	operatorMeta spec.OperatorMeta
+}
+
+// WithSchemas of operator to validate inputs and outputs against, when ValidateSchemas of pipeline enabled
+func WithSchemas(operatorMeta spec.OperatorMeta) ServeOperatorOption {
+	return func(o *serveOperatorOptions) {
+		o.operatorMeta = operatorMeta
+	}
+}
+
+func ServeOperator(pipelineController PipelineController, stage string, operatorHandlerFunc OperatorHandlerFunc, options ...ServeOperatorOption) Subscription {
+	opts := &serveOperatorOptions{}
+	for _, option := range options {
+		option(opts)
+	}
+
 	logger := logrus.WithFields(logrus.Fields{
 		"pipeline":       pipelineController.Scope(),
 		"pipeline/stage": stage,
 	})
 
-	sub := Subscribe(pipelineController, stage, func(ctx context.Context, task *Task) {
+	sub := Subscribe(pipelineController, stage, func(ctx context.Context, task *Task) (errForNack error) {
 		if task.ErrMsg != "" {
-			return
+			return nil
+		}
+
+		if task.RetryAt != nil {
+			if d := time.Until(*task.RetryAt); d > 0 {
+				return NackWithDelay(errRetryNotDue, d)
+			}
 		}
 
 		l := logger.WithContext(ctx).WithFields(logrus.Fields{
-			"taskID": task.ID,
+			"taskID":  task.ID,
+			"attempt": task.Attempt,
 		})
 
 		l.Debugf("%s started.", stage)
 
+		recordTaskEvent(pipelineController, ctx, NewTaskEvent(task, TaskEventStageStart))
+
 		startedAt := time.Now()
 		var finalErr error
+		var handlerAbandoned <-chan struct{}
+
+		fail := func(err error) error {
+			recordTaskEvent(pipelineController, ctx, NewTaskEvent(task, TaskEventFailed).WithErr(err))
+
+			// undeliverable task put when sending
+			if !errors.Is(err, ErrNoSubscriptionsForTopic) {
+				putDeadLetter(pipelineController, ctx, NewDeadLetter(task, DeadLetterFailed, err))
+			}
+
+			if err := Publish(pipelineController, ctx, task.Final(), task.Err(err)); err != nil && err != ErrNoSubscriptionsForTopic {
+				return err
+			}
+			// or no one waiting for the result
+			return nil
+		}
 
 		defer func() {
+			if handlerAbandoned != nil {
+				defer waitHandler(l, stage, handlerAbandoned)
+			}
+
 			if finalErr != nil {
+				recordTaskEvent(pipelineController, ctx, NewTaskEvent(task, TaskEventStageFailed).WithErr(finalErr))
+
+				if policy, ok := task.StageRetryPolicies[stage]; ok && !errors.Is(finalErr, ErrTaskTimeout) && policy.ShouldRetry(task.Attempt, finalErr) {
+					backoff := policy.Backoff(task.Attempt)
+
+					l.Warnf("%s failed in %s, retry after %s, err: %s", stage, time.Since(startedAt), backoff, finalErr)
+
+					retry := task.Err(finalErr).Retry()
+					retryAt := time.Now().Add(backoff)
+					retry.RetryAt = &retryAt
+
+					// published at once, kept by event bus until due, so not lost when the replica gone
+					if err := Publish(pipelineController, ctx, stage, retry); err != nil {
+						l.Error(err)
+
+						if err := fail(finalErr); err != nil {
+							l.Error(err)
+							errForNack = err
+						}
+					}
+					return
+				}
+
+				if task.Attempt > 0 {
+					finalErr = fmt.Errorf("%w (after %d attempts)", finalErr, task.Attempt+1)
+				}
+
 				l.Warnf("%s failed in %s, err: %s", stage, time.Since(startedAt), finalErr)
 
-				if err := Publish(pipelineController, ctx, task.Final(), task.Err(finalErr)); err != nil {
+				if err := fail(finalErr); err != nil {
 					l.Error(err)
+					// redelivered, since the failure could not be reported
+					errForNack = err
 				}
 			} else {
+				recordTaskEvent(pipelineController, ctx, NewTaskEvent(task, TaskEventStageDone))
+
 				l.Debugf("%s done in %s", stage, time.Since(startedAt))
 			}
 		}()
 
-		t, err := newTransfer(pipelineController, ContextWithTask(context.Background(), task), task)
+		taskCtx, cancel, errTimeout := timeoutContext(context.Background(), task, stage)
+		defer cancel()
+
+		t, err := newTransfer(pipelineController, ContextWithTask(taskCtx, task), task)
 		if err != nil {
 			finalErr = err
 			return
 		}
 
-		if err := operatorHandlerFunc(t); err != nil {
+		if task.ValidateSchemas {
+			if err := validateInputs(pipelineController, taskCtx, task, opts.operatorMeta.Inputs); err != nil {
+				finalErr = err
+				return
+			}
+
+			if opts.operatorMeta.Outputs.Declared() {
+				t.outputSchema = &opts.operatorMeta.Outputs
+			}
+		}
+
+		cacheEntry, err := newStageCacheEntry(pipelineController, taskCtx, task)
+		if err != nil {
+			l.Warnf("%s cache disabled: %s", stage, err)
+		}
+
+		if cacheEntry != nil {
+			outputs, err := cacheEntry.lookup(taskCtx)
+			if err != nil {
+				l.Warnf("%s cache lookup failed: %s", stage, err)
+			}
+
+			if outputs != nil {
+				l.Debugf("%s cache hit", stage)
+
+				if err := cacheEntry.restore(t, outputs); err != nil {
+					l.Warnf("%s cache restore failed: %s", stage, err)
+					t.reset()
+				} else {
+					if err := t.Send(); err != nil && err != ErrNoInputsForNext {
+						finalErr = err
+					}
+					return nil
+				}
+			}
+		}
+
+		handlerDone, err := runUntilDone(taskCtx, errTimeout, func() error {
+			return operatorHandlerFunc(t)
+		})
+		if err != nil {
 			finalErr = err
+			// waits after failure reported
+			handlerAbandoned = handlerDone
 			return
 		}
 
+		if cacheEntry != nil {
+			if err := cacheEntry.store(taskCtx, t.outputs); err != nil {
+				l.Warnf("%s cache store failed: %s", stage, err)
+			}
+		}
+
 		if err := t.Send(); err != nil && err != ErrNoInputsForNext {
 			finalErr = err
 		}
+
+		return nil
 	})
 
 	return NewSubscription(func() {
 		sub.Unsubscribe()
 	})
 }
+
+// timeoutContext limits ctx by the deadline of task and the timeout of stage,
+// returns the error to report when the limit exceeded too.
+func timeoutContext(ctx context.Context, task *Task, stage string) (context.Context, context.CancelFunc, error) {
+	deadline := time.Time{}
+	var errTimeout error
+
+	if timeout, ok := task.StageTimeouts[stage]; ok && timeout > 0 {
+		deadline = time.Now().Add(time.Duration(timeout))
+		errTimeout = fmt.Errorf("%w after %s", ErrStageTimeout, timeout)
+	}
+
+	if task.Deadline != nil && (deadline.IsZero() || task.Deadline.Before(deadline)) {
+		deadline = *task.Deadline
+		errTimeout = ErrTaskTimeout
+	}
+
+	if deadline.IsZero() {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, nil
+	}
+
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	return ctx, cancel, errTimeout
+}
+
+// runUntilDone returns once fn returned or ctx done, with done closed when fn returned.
+func runUntilDone(ctx context.Context, errTimeout error, fn func() error) (<-chan struct{}, error) {
+	done := make(chan struct{})
+
+	if ctx.Err() == nil {
+		chErr := make(chan error, 1)
+
+		go func() {
+			defer close(done)
+			chErr <- fn()
+		}()
+
+		select {
+		case err := <-chErr:
+			return done, err
+		case <-ctx.Done():
+		}
+	} else {
+		close(done)
+	}
+
+	if ctx.Err() == context.DeadlineExceeded && errTimeout != nil {
+		return done, errTimeout
+	}
+	return done, ctx.Err()
+}
+
+// handler not returned after timeout holds the replica until returned, instead of leaking
+var handlerGracePeriod = 10 * time.Second
+
+func waitHandler(l *logrus.Entry, stage string, done <-chan struct{}) {
+	select {
+	case <-done:
+		return
+	case <-time.After(handlerGracePeriod):
+	}
+
+	l.Warnf("%s handler still running after timeout, it should return once context of transfer done", stage)
+
+	<-done
+}
@@ -0,0 +1,228 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrTaskNotFound = errors.New("task not found")
+	// ErrTaskStoreRequired returned when states of tasks needed but no TaskStore
+	ErrTaskStoreRequired = errors.New("task store required")
+)
+
+// TaskStore records lifecycle events of tasks.
+// Events are append only, so replicas of stages could record without locking,
+// and the state of task is folded from its events when reading.
+type TaskStore interface {
+	Record(ctx context.Context, event *TaskEvent) error
+	// Events returns events of task in recorded order
+	Events(ctx context.Context, taskID uint64) ([]*TaskEvent, error)
+	// TaskIDs returns ids of tasks in scope, or of all scopes when scope is empty, latest first.
+	TaskIDs(ctx context.Context, scope string) ([]uint64, error)
+}
+
+type TaskEventType string
+
+const (
+	TaskEventQueued      TaskEventType = "queued"
+	TaskEventStageStart  TaskEventType = "stage_start"
+	TaskEventStageSend   TaskEventType = "stage_send"
+	TaskEventStageDone   TaskEventType = "stage_done"
+	TaskEventStageFailed TaskEventType = "stage_failed"
+	TaskEventSucceeded   TaskEventType = "succeeded"
+	TaskEventFailed      TaskEventType = "failed"
+)
+
+type TaskEvent struct {
+	TaskID  uint64
+	Scope   string
+	Type    TaskEventType
+	Stage   string   `json:",omitempty"`
+	Attempt int      `json:",omitempty"`
+	Outputs []string `json:",omitempty"`
+	ErrMsg  string   `json:",omitempty"`
+	Time    time.Time
+}
+
+func NewTaskEvent(task *Task, typ TaskEventType) *TaskEvent {
+	e := &TaskEvent{
+		TaskID: task.ID,
+		Scope:  task.Scope,
+		Type:   typ,
+		Time:   time.Now(),
+	}
+	if task.TaskStage != nil {
+		e.Stage = task.Stage
+		e.Attempt = task.Attempt
+		e.ErrMsg = task.ErrMsg
+	}
+	return e
+}
+
+func (e TaskEvent) WithOutputs(outputs []string) *TaskEvent {
+	e.Outputs = outputs
+	return &e
+}
+
+func (e TaskEvent) WithErr(err error) *TaskEvent {
+	e.ErrMsg = err.Error()
+	return &e
+}
+
+type TaskStatus string
+
+const (
+	TaskStatusQueued    TaskStatus = "queued"
+	TaskStatusRunning   TaskStatus = "running"
+	TaskStatusSucceeded TaskStatus = "succeeded"
+	TaskStatusFailed    TaskStatus = "failed"
+)
+
+type TaskState struct {
+	ID     uint64
+	Scope  string
+	Status TaskStatus
+	// stages in started order
+	Stages    []*TaskStageState
+	Outputs   []string `json:",omitempty"`
+	Stage     string   `json:",omitempty"`
+	ErrMsg    string   `json:",omitempty"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Running returns stages still in running
+func (s *TaskState) Running() []string {
+	stages := make([]string, 0)
+	for _, stage := range s.Stages {
+		if stage.Status == TaskStatusRunning {
+			stages = append(stages, stage.Stage)
+		}
+	}
+	return stages
+}
+
+type TaskStageState struct {
+	Stage     string
+	Status    TaskStatus
+	Attempt   int      `json:",omitempty"`
+	Outputs   []string `json:",omitempty"`
+	ErrMsg    string   `json:",omitempty"`
+	StartedAt time.Time
+	EndedAt   *time.Time `json:",omitempty"`
+}
+
+func TaskStateFromEvents(events []*TaskEvent) (*TaskState, error) {
+	if len(events) == 0 {
+		return nil, ErrTaskNotFound
+	}
+
+	state := &TaskState{
+		ID:     events[0].TaskID,
+		Scope:  events[0].Scope,
+		Status: TaskStatusQueued,
+	}
+
+	stages := map[string]*TaskStageState{}
+
+	stageOf := func(e *TaskEvent) *TaskStageState {
+		if s, ok := stages[e.Stage]; ok {
+			return s
+		}
+		s := &TaskStageState{Stage: e.Stage, StartedAt: e.Time}
+		stages[e.Stage] = s
+		state.Stages = append(state.Stages, s)
+		return s
+	}
+
+	for _, e := range events {
+		if state.CreatedAt.IsZero() || e.Time.Before(state.CreatedAt) {
+			state.CreatedAt = e.Time
+		}
+		if e.Time.After(state.UpdatedAt) {
+			state.UpdatedAt = e.Time
+		}
+
+		switch e.Type {
+		case TaskEventQueued:
+			// queued again when requeued
+			state.Status = TaskStatusQueued
+			state.Stage = ""
+			state.ErrMsg = ""
+		case TaskEventStageStart:
+			s := stageOf(e)
+			s.Status = TaskStatusRunning
+			s.Attempt = e.Attempt
+			s.ErrMsg = ""
+			s.EndedAt = nil
+
+			if state.Status == TaskStatusQueued {
+				state.Status = TaskStatusRunning
+			}
+		case TaskEventStageSend:
+			s := stageOf(e)
+			s.Outputs = append(s.Outputs, e.Outputs...)
+		case TaskEventStageDone, TaskEventStageFailed:
+			s := stageOf(e)
+			s.Status = TaskStatusSucceeded
+			s.Attempt = e.Attempt
+			s.ErrMsg = e.ErrMsg
+			if e.Type == TaskEventStageFailed {
+				s.Status = TaskStatusFailed
+			}
+			endedAt := e.Time
+			s.EndedAt = &endedAt
+		case TaskEventSucceeded:
+			state.Status = TaskStatusSucceeded
+			state.Outputs = e.Outputs
+		case TaskEventFailed:
+			state.Status = TaskStatusFailed
+			state.Stage = e.Stage
+			state.ErrMsg = e.ErrMsg
+		}
+	}
+
+	return state, nil
+}
+
+type TaskFilter struct {
+	// tasks of all scopes when empty
+	Scope  string
+	Status TaskStatus
+	// tasks running in stage
+	Stage string
+	// no limit when zero
+	Limit int
+}
+
+func (f TaskFilter) Match(state *TaskState) bool {
+	if f.Status != "" && state.Status != f.Status {
+		return false
+	}
+
+	if f.Stage != "" {
+		for _, stage := range state.Running() {
+			if stage == f.Stage {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+func recordTaskEvent(pipelineController PipelineController, ctx context.Context, event *TaskEvent) {
+	taskStore := pipelineController.TaskStore()
+	if taskStore == nil {
+		return
+	}
+
+	if err := taskStore.Record(ctx, event); err != nil {
+		logrus.WithContext(ctx).Warnf("record %s of task %d failed: %s", event.Type, event.TaskID, err)
+	}
+}
@@ -0,0 +1,66 @@
+package mem
+
+import (
+	"context"
+	"sync"
+
+	"github.com/querycap/pipeline/pipeline"
+)
+
+func NewMemTaskStore() pipeline.TaskStore {
+	return &MemTaskStore{
+		events: map[uint64][]*pipeline.TaskEvent{},
+		scopes: map[uint64]string{},
+	}
+}
+
+type MemTaskStore struct {
+	rw     sync.RWMutex
+	events map[uint64][]*pipeline.TaskEvent
+	scopes map[uint64]string
+	// task ids in created order
+	taskIDs []uint64
+}
+
+func (m *MemTaskStore) Record(ctx context.Context, event *pipeline.TaskEvent) error {
+	m.rw.Lock()
+	defer m.rw.Unlock()
+
+	if _, ok := m.events[event.TaskID]; !ok {
+		m.taskIDs = append(m.taskIDs, event.TaskID)
+		m.scopes[event.TaskID] = event.Scope
+	}
+
+	m.events[event.TaskID] = append(m.events[event.TaskID], event)
+
+	return nil
+}
+
+func (m *MemTaskStore) Events(ctx context.Context, taskID uint64) ([]*pipeline.TaskEvent, error) {
+	m.rw.RLock()
+	defer m.rw.RUnlock()
+
+	events, ok := m.events[taskID]
+	if !ok {
+		return nil, pipeline.ErrTaskNotFound
+	}
+
+	return append([]*pipeline.TaskEvent{}, events...), nil
+}
+
+func (m *MemTaskStore) TaskIDs(ctx context.Context, scope string) ([]uint64, error) {
+	m.rw.RLock()
+	defer m.rw.RUnlock()
+
+	taskIDs := make([]uint64, 0)
+
+	for i := len(m.taskIDs) - 1; i >= 0; i-- {
+		taskID := m.taskIDs[i]
+
+		if scope == "" || m.scopes[taskID] == scope {
+			taskIDs = append(taskIDs, taskID)
+		}
+	}
+
+	return taskIDs, nil
+}
@@ -0,0 +1,123 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/querycap/pipeline/pipeline"
+)
+
+type RedisPool interface {
+	Get() redis.Conn
+}
+
+// NewRedisTaskStore creates TaskStore on redis,
+// events of task expire after ttl since last recorded, and task leaves the index after ttl since created,
+// kept forever when ttl is zero.
+func NewRedisTaskStore(pool RedisPool, ttl time.Duration) pipeline.TaskStore {
+	return &RedisTaskStore{pool: pool, ttl: ttl}
+}
+
+type RedisTaskStore struct {
+	pool RedisPool
+	ttl  time.Duration
+}
+
+func (r *RedisTaskStore) Record(ctx context.Context, event *pipeline.TaskEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	key := eventsKey(event.TaskID)
+	score := event.Time.UnixNano()
+
+	if err := conn.Send("MULTI"); err != nil {
+		return err
+	}
+
+	_ = conn.Send("RPUSH", key, data)
+	if r.ttl > 0 {
+		_ = conn.Send("PEXPIRE", key, int64(r.ttl/time.Millisecond))
+	}
+	// NX keeps score as the created time of task
+	_ = conn.Send("ZADD", indexKey(""), "NX", score, event.TaskID)
+	_ = conn.Send("ZADD", indexKey(event.Scope), "NX", score, event.TaskID)
+
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+func (r *RedisTaskStore) Events(ctx context.Context, taskID uint64) ([]*pipeline.TaskEvent, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	values, err := redis.ByteSlices(conn.Do("LRANGE", eventsKey(taskID), 0, -1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(values) == 0 {
+		return nil, pipeline.ErrTaskNotFound
+	}
+
+	events := make([]*pipeline.TaskEvent, len(values))
+
+	for i := range values {
+		events[i] = &pipeline.TaskEvent{}
+		if err := json.Unmarshal(values[i], events[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return events, nil
+}
+
+func (r *RedisTaskStore) TaskIDs(ctx context.Context, scope string) ([]uint64, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	key := indexKey(scope)
+
+	// drop ids of tasks which events expired
+	if r.ttl > 0 {
+		if _, err := conn.Do("ZREMRANGEBYSCORE", key, "-inf", time.Now().Add(-r.ttl).UnixNano()); err != nil {
+			return nil, err
+		}
+	}
+
+	values, err := redis.Strings(conn.Do("ZREVRANGE", key, 0, -1))
+	if err != nil {
+		return nil, err
+	}
+
+	taskIDs := make([]uint64, len(values))
+
+	for i := range values {
+		taskID, err := strconv.ParseUint(values[i], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		taskIDs[i] = taskID
+	}
+
+	return taskIDs, nil
+}
+
+func eventsKey(taskID uint64) string {
+	return strings.Join([]string{"tasks", strconv.FormatUint(taskID, 10), "events"}, ":")
+}
+
+func indexKey(scope string) string {
+	if scope == "" {
+		return "tasks"
+	}
+	return strings.Join([]string{scope, "tasks"}, ":")
+}
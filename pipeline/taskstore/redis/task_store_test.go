@@ -0,0 +1,75 @@
+package redis_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/querycap/pipeline/pipeline"
+	"github.com/querycap/pipeline/pipeline/taskstore/redis"
+	"github.com/querycap/pipeline/pkg/redisutil"
+)
+
+var pool, _ = redisutil.NewPool("tcp://127.0.0.1:6379")
+
+func TestRedisTaskStore(t *testing.T) {
+	s := redis.NewRedisTaskStore(pool, time.Minute)
+
+	ctx := context.Background()
+	scope := fmt.Sprintf("p/test:1.0.0/%d", time.Now().UnixNano())
+	taskID := uint64(time.Now().UnixNano())
+
+	task := &pipeline.Task{
+		TaskContext: pipeline.TaskContext{ID: taskID, TaskMeta: pipeline.TaskMeta{Scope: scope}},
+		TaskStage:   pipeline.NewTaskStage("$input", nil),
+	}
+
+	NewWithT(t).Expect(s.Record(ctx, pipeline.NewTaskEvent(task, pipeline.TaskEventQueued))).To(BeNil())
+
+	task = task.Next("a", []string{"input"})
+
+	NewWithT(t).Expect(s.Record(ctx, pipeline.NewTaskEvent(task, pipeline.TaskEventStageStart))).To(BeNil())
+	NewWithT(t).Expect(s.Record(ctx, pipeline.NewTaskEvent(task, pipeline.TaskEventStageSend).WithOutputs([]string{"output"}))).To(BeNil())
+
+	events, err := s.Events(ctx, taskID)
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(events).To(HaveLen(3))
+	NewWithT(t).Expect(events[2].Outputs).To(Equal([]string{"output"}))
+
+	state, err := pipeline.TaskStateFromEvents(events)
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(state.Status).To(Equal(pipeline.TaskStatusRunning))
+	NewWithT(t).Expect(state.Running()).To(Equal([]string{"a"}))
+
+	taskIDs, err := s.TaskIDs(ctx, scope)
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(taskIDs).To(Equal([]uint64{taskID}))
+
+	_, err = s.Events(ctx, taskID+1)
+	NewWithT(t).Expect(err).To(Equal(pipeline.ErrTaskNotFound))
+}
+
+func TestRedisTaskStoreWithoutTTL(t *testing.T) {
+	s := redis.NewRedisTaskStore(pool, 0)
+
+	ctx := context.Background()
+	scope := fmt.Sprintf("p/test:1.0.0/%d", time.Now().UnixNano())
+	taskID := uint64(time.Now().UnixNano())
+
+	task := &pipeline.Task{
+		TaskContext: pipeline.TaskContext{ID: taskID, TaskMeta: pipeline.TaskMeta{Scope: scope}},
+		TaskStage:   pipeline.NewTaskStage("$input", nil),
+	}
+
+	NewWithT(t).Expect(s.Record(ctx, pipeline.NewTaskEvent(task, pipeline.TaskEventQueued))).To(BeNil())
+
+	events, err := s.Events(ctx, taskID)
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(events).To(HaveLen(1))
+
+	taskIDs, err := s.TaskIDs(ctx, scope)
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(taskIDs).To(Equal([]uint64{taskID}))
+}
@@ -2,11 +2,15 @@ package pipeline
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"io"
 	"mime"
 	"path/filepath"
 	"strconv"
+	"strings"
+
+	"github.com/querycap/pipeline/spec"
 )
 
 var (
@@ -26,6 +30,10 @@ type transfer struct {
 	inputScanIdx int
 
 	outputs []string
+	// checksums of outputs, when any next stage caches results
+	checksums map[string]string
+	// outputs validated against when not nil
+	outputSchema *spec.DataSchema
 }
 
 func (t *transfer) Context() context.Context {
@@ -44,6 +52,11 @@ func (t *transfer) Next() (io.ReadCloser, error) {
 		return nil, errors.New("no more inputs")
 	}
 
+	// handler timed out or cancelled
+	if err := t.Context().Err(); err != nil {
+		return nil, err
+	}
+
 	inputFile := t.task.Inputs[t.inputScanIdx]
 
 	file, err := t.pipelineController.Read(t.Context(), inputFile)
@@ -55,6 +68,19 @@ func (t *transfer) Next() (io.ReadCloser, error) {
 }
 
 func (t *transfer) Put(writerTo io.WriterTo) error {
+	// outputs of stage reported failed not taken
+	if err := t.Context().Err(); err != nil {
+		return err
+	}
+
+	if t.outputSchema != nil {
+		validated, err := validateOutput(writerTo, *t.outputSchema)
+		if err != nil {
+			return err
+		}
+		writerTo = validated
+	}
+
 	machineID, err := t.pipelineController.MachineID()
 	if err != nil {
 		return err
@@ -77,11 +103,12 @@ func (t *transfer) Put(writerTo io.WriterTo) error {
 		}
 	}
 
-	filename = filepath.Join(
-		"tasks", strconv.FormatUint(t.task.ID, 10),
-		"stages", t.task.Stage,
-		"results", filename,
-	)
+	filename = filepath.Join(stageResultsPath(t.task.ID, t.task.Stage), filename)
+
+	var checksum func() string
+	if t.checksumOutputs() {
+		writerTo, checksum = withChecksum(writerTo)
+	}
 
 	if err := t.pipelineController.Put(t.Context(), filename, writerTo); err != nil {
 		return err
@@ -89,6 +116,13 @@ func (t *transfer) Put(writerTo io.WriterTo) error {
 
 	t.outputs = append(t.outputs, filename)
 
+	if checksum != nil {
+		if t.checksums == nil {
+			t.checksums = map[string]string{}
+		}
+		t.checksums[filename] = checksum()
+	}
+
 	return nil
 }
 
@@ -97,30 +131,127 @@ func (t *transfer) Send() error {
 		return ErrNoInputsForNext
 	}
 
-	nextStages := make([]string, 0)
+	nextStages := t.nextStages()
+	if t.task.Stage == t.task.Ends {
+		nextStages = append(nextStages, t.task.Final())
+	}
 
 	switch t.task.Stage {
 	case "$input":
-		nextStages = append(nextStages, t.task.Starts)
 	case t.task.Ends:
-		nextStages = append(nextStages, t.task.Final())
+		recordTaskEvent(t.pipelineController, t.Context(), NewTaskEvent(t.task, TaskEventStageSend).WithOutputs(t.outputs))
+		recordTaskEvent(t.pipelineController, t.Context(), NewTaskEvent(t.task, TaskEventSucceeded).WithOutputs(t.outputs))
 	default:
-		for step, deps := range t.task.StageDeps {
-			for _, dep := range deps {
-				if t.task.Stage == dep {
-					nextStages = append(nextStages, step)
-				}
-			}
-		}
+		recordTaskEvent(t.pipelineController, t.Context(), NewTaskEvent(t.task, TaskEventStageSend).WithOutputs(t.outputs))
 	}
 
 	for _, next := range nextStages {
-		if err := Publish(t.pipelineController, t.Context(), next, t.task.Next(next, t.outputs)); err != nil {
+		inputs, err := t.join(next)
+		if err != nil {
+			return err
+		}
+
+		// waiting for other deps
+		if inputs == nil {
+			continue
+		}
+
+		task := t.task.Next(next, inputs)
+
+		// checksums not carried by join
+		if len(t.task.StageDeps[next]) <= 1 {
+			task.Checksums = t.checksums
+		}
+
+		if err := Publish(t.pipelineController, t.Context(), next, task); err != nil {
+			if err == ErrNoSubscriptionsForTopic {
+				// no one attached, the result kept for attaching later
+				if next == t.task.Final() {
+					continue
+				}
+				putDeadLetter(t.pipelineController, t.Context(), NewDeadLetter(task, DeadLetterUndeliverable, err))
+			}
 			return err
 		}
 	}
 
-	t.outputs = []string{}
+	t.reset()
 
 	return nil
 }
+
+// reset drops outputs put
+func (t *transfer) reset() {
+	t.outputs = []string{}
+	t.checksums = nil
+}
+
+// nextStages returns stages which outputs sent to, not including the final
+func (t *transfer) nextStages() []string {
+	switch t.task.Stage {
+	case "$input":
+		return []string{t.task.Starts}
+	case t.task.Ends:
+		return nil
+	}
+
+	stages := make([]string, 0)
+	for step, deps := range t.task.StageDeps {
+		for _, dep := range deps {
+			if t.task.Stage == dep {
+				stages = append(stages, step)
+			}
+		}
+	}
+	return stages
+}
+
+// checksumOutputs when any next stage caches results, and takes outputs without join
+func (t *transfer) checksumOutputs() bool {
+	for _, next := range t.nextStages() {
+		if _, ok := t.task.StageCaches[next]; ok && len(t.task.StageDeps[next]) <= 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// join collects outputs of all deps of the next stage,
+// returns the union of them in the order of deps once the last dep done, otherwise nil.
+func (t *transfer) join(next string) ([]string, error) {
+	deps := t.task.StageDeps[next]
+
+	if len(deps) <= 1 {
+		return t.outputs, nil
+	}
+
+	data, err := json.Marshal(t.outputs)
+	if err != nil {
+		return nil, err
+	}
+
+	parts, err := t.pipelineController.Join(t.Context(), joinKey(t.task.ID, next), t.task.Stage, data, len(deps))
+	if err != nil {
+		return nil, err
+	}
+
+	if parts == nil {
+		return nil, nil
+	}
+
+	inputs := make([]string, 0)
+
+	for _, dep := range deps {
+		outputs := make([]string, 0)
+		if err := json.Unmarshal(parts[dep], &outputs); err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, outputs...)
+	}
+
+	return inputs, nil
+}
+
+func joinKey(taskID uint64, stage string) string {
+	return strings.Join([]string{"tasks", strconv.FormatUint(taskID, 10), "stages", stage, "$join"}, "/")
+}
@@ -21,10 +21,18 @@ func (j *eventBusWithPrefix) Publish(ctx context.Context, topic string, data []b
 	return j.eventBus.Publish(ctx, concat(":", j.prefix, topic), data)
 }
 
-func (j *eventBusWithPrefix) Subscribe(topic string, callback func(ctx context.Context, data []byte)) Subscription {
+func (j *eventBusWithPrefix) Subscribe(topic string, callback Handler) Subscription {
 	return j.eventBus.Subscribe(concat(":", j.prefix, topic), callback)
 }
 
+func (j *eventBusWithPrefix) Join(ctx context.Context, key string, part string, data []byte, n int) (map[string][]byte, error) {
+	return j.eventBus.Join(ctx, concat(":", j.prefix, key), part, data, n)
+}
+
+func (j *eventBusWithPrefix) QueueDepth(ctx context.Context, topic string) (int, error) {
+	return j.eventBus.QueueDepth(ctx, concat(":", j.prefix, topic))
+}
+
 func NewSubscription(callback func()) Subscription {
 	return &subscription{callback: callback}
 }
@@ -0,0 +1,456 @@
+package operatorgen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/querycap/pipeline/spec"
+)
+
+// Generate generates go source of package pkg for operator,
+// with types Input and Output for inputs and outputs of operator,
+// and NewOperatorHandlerFunc to wrap typed Handler as pipeline.OperatorHandlerFunc.
+//
+// Json inputs and outputs are decoded and encoded as generated structs,
+// others are passed as []byte.
+func Generate(op *spec.Operator, pkg string) ([]byte, error) {
+	g := &generator{
+		imports:     map[string]bool{"context": true, "github.com/querycap/pipeline/pipeline": true},
+		definitions: map[string]*spec.Schema{},
+	}
+
+	input, err := g.side("Input", op.Inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := g.side("Output", op.Outputs)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(nil)
+
+	err = fileTemplate.Execute(buf, map[string]interface{}{
+		"Operator": op.Project.String(),
+		"Package":  pkg,
+		"Imports":  g.sortedImports(),
+		"Decls":    g.decls,
+		"Input":    input,
+		"Output":   output,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated source failed: %s\n%s", err, buf.String())
+	}
+
+	return src, nil
+}
+
+type side struct {
+	Name string
+	// type used in Handler, like *Input or Input
+	Type        string
+	JSON        bool
+	ContentType string
+}
+
+type generator struct {
+	imports map[string]bool
+	decls   []string
+	// declared types by name of definitions
+	definitions map[string]*spec.Schema
+}
+
+// sortedImports returns std imports and others in groups
+func (g *generator) sortedImports() [][]string {
+	std, others := make([]string, 0), make([]string, 0)
+
+	for path := range g.imports {
+		if strings.Contains(strings.Split(path, "/")[0], ".") {
+			others = append(others, path)
+		} else {
+			std = append(std, path)
+		}
+	}
+
+	sort.Strings(std)
+	sort.Strings(others)
+
+	return [][]string{std, others}
+}
+
+func (g *generator) side(name string, dataSchema spec.DataSchema) (*side, error) {
+	s := &side{Name: name, Type: name, ContentType: dataSchema.ContentType}
+
+	if !dataSchema.IsJSON() {
+		if name == "Input" {
+			g.imports["io"] = true
+			g.imports["io/ioutil"] = true
+		} else {
+			g.imports["bytes"] = true
+		}
+		g.decl(fmt.Sprintf("// %s of operator, with content type %s\ntype %s []byte", name, orAny(dataSchema.ContentType), name))
+		return s, nil
+	}
+
+	s.JSON = true
+
+	root := &dataSchema.Schema
+
+	t := &typeResolver{generator: g, root: root, rootName: name, prefix: name}
+
+	if resolved := t.resolve(root); resolved != nil && isStruct(resolved) {
+		s.Type = "*" + name
+		return s, t.declStruct(name, resolved)
+	}
+
+	typ, err := t.goType(name+"Value", root)
+	if err != nil {
+		return nil, err
+	}
+
+	g.decl(fmt.Sprintf("// %s of operator\ntype %s = %s", name, name, typ))
+
+	return s, nil
+}
+
+func orAny(contentType string) string {
+	if contentType == "" {
+		return "any"
+	}
+	return contentType
+}
+
+func (g *generator) decl(decl string) {
+	g.decls = append(g.decls, decl)
+}
+
+type typeResolver struct {
+	*generator
+	root     *spec.Schema
+	rootName string
+	// prefix of definitions when conflicted with ones of the other side
+	prefix string
+}
+
+func (t *typeResolver) resolve(s *spec.Schema) *spec.Schema {
+	for i := 0; s != nil && s.Ref != "" && i < 32; i++ {
+		s = t.resolveRef(s.Ref)
+	}
+	return s
+}
+
+func (t *typeResolver) resolveRef(ref string) *spec.Schema {
+	if ref == "#" {
+		return t.root
+	}
+	return t.root.Definitions[strings.TrimPrefix(ref, "#/definitions/")]
+}
+
+func isStruct(s *spec.Schema) bool {
+	return s.Type == "object" && len(s.Properties) > 0
+}
+
+// goType returns go type of schema, name used when struct declaration needed.
+func (t *typeResolver) goType(name string, s *spec.Schema) (string, error) {
+	if s == nil {
+		return "interface{}", nil
+	}
+
+	if s.Ref != "" {
+		return t.refType(s.Ref)
+	}
+
+	// like anyOf [X, null]
+	if len(s.AnyOf) > 0 {
+		types := make([]*spec.Schema, 0, len(s.AnyOf))
+		for _, sub := range s.AnyOf {
+			if sub.Type != "null" {
+				types = append(types, sub)
+			}
+		}
+		if len(types) == 1 && s.Type == "" {
+			typ, err := t.goType(name, types[0])
+			if err != nil {
+				return "", err
+			}
+			return pointerOf(typ), nil
+		}
+		return "interface{}", nil
+	}
+
+	typ := ""
+
+	switch s.Type {
+	case "boolean":
+		typ = "bool"
+	case "integer":
+		typ = "int64"
+	case "number":
+		typ = "float64"
+	case "string":
+		switch s.Format {
+		case "byte":
+			typ = "[]byte"
+		case "date-time":
+			t.imports["time"] = true
+			typ = "time.Time"
+		default:
+			typ = "string"
+		}
+	case "array":
+		items, err := t.goType(name+"Item", s.Items)
+		if err != nil {
+			return "", err
+		}
+		typ = "[]" + items
+	case "object":
+		if isStruct(s) {
+			if err := t.declStruct(name, s); err != nil {
+				return "", err
+			}
+			typ = name
+		} else {
+			values, err := t.goType(name+"Value", s.AdditionalProperties)
+			if err != nil {
+				return "", err
+			}
+			typ = "map[string]" + values
+		}
+	default:
+		return "interface{}", nil
+	}
+
+	if s.Nullable {
+		return pointerOf(typ), nil
+	}
+
+	return typ, nil
+}
+
+func pointerOf(typ string) string {
+	if strings.HasPrefix(typ, "[]") || strings.HasPrefix(typ, "map[") || strings.HasPrefix(typ, "*") || typ == "interface{}" {
+		return typ
+	}
+	return "*" + typ
+}
+
+func (t *typeResolver) refType(ref string) (string, error) {
+	if ref == "#" {
+		return t.rootName, nil
+	}
+
+	name := strings.TrimPrefix(ref, "#/definitions/")
+
+	s, ok := t.root.Definitions[name]
+	if !ok || name == ref {
+		return "", fmt.Errorf("unresolvable $ref %s", ref)
+	}
+
+	typeName := goName(name)
+
+	if declared, ok := t.definitions[typeName]; ok {
+		if declared == s || reflect.DeepEqual(declared, s) {
+			return typeName, nil
+		}
+		// same name with different schema in the other side
+		typeName = t.prefix + typeName
+		if _, ok := t.definitions[typeName]; ok {
+			return typeName, nil
+		}
+	}
+
+	t.definitions[typeName] = s
+
+	if isStruct(s) {
+		return typeName, t.declStruct(typeName, s)
+	}
+
+	typ, err := t.goType(typeName+"Value", s)
+	if err != nil {
+		return "", err
+	}
+
+	t.decl(fmt.Sprintf("%stype %s = %s", comment(s.Description), typeName, typ))
+
+	return typeName, nil
+}
+
+func (t *typeResolver) declStruct(name string, s *spec.Schema) error {
+	required := map[string]bool{}
+	for _, key := range s.Required {
+		required[key] = true
+	}
+
+	keys := make([]string, 0, len(s.Properties))
+	for key := range s.Properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// declared before fields resolved, nested structs declared after it
+	idx := len(t.decls)
+	t.decl("")
+
+	fields := bytes.NewBuffer(nil)
+
+	for _, key := range keys {
+		prop := s.Properties[key]
+		fieldName := goName(key)
+
+		typ, err := t.goType(name+fieldName, prop)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %s", name, key, err)
+		}
+
+		tag := key
+		if !required[key] {
+			tag += ",omitempty"
+			// structs are never omitted as empty
+			if resolved := t.resolve(prop); resolved != nil && (isStruct(resolved) || typ == "time.Time") {
+				typ = pointerOf(typ)
+			}
+		}
+
+		tags := fmt.Sprintf("json:%q", tag)
+		if prop.Description != "" && !strings.Contains(prop.Description, "`") {
+			tags += " description:" + strconv.Quote(prop.Description)
+		}
+
+		fmt.Fprintf(fields, "%s%s %s `%s`\n", comment(prop.Description), fieldName, typ, tags)
+	}
+
+	description := s.Description
+	if description == "" && (name == "Input" || name == "Output") {
+		description = name + " of operator"
+	}
+
+	t.decls[idx] = fmt.Sprintf("%stype %s struct {\n%s}", comment(description), name, fields.String())
+
+	return nil
+}
+
+func comment(description string) string {
+	if description == "" {
+		return ""
+	}
+	lines := strings.Split(strings.TrimSpace(description), "\n")
+	for i := range lines {
+		lines[i] = "// " + lines[i]
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+var commonInitialisms = map[string]bool{
+	"API": true, "HTML": true, "HTTP": true, "HTTPS": true, "ID": true, "IP": true,
+	"JSON": true, "SQL": true, "URI": true, "URL": true, "UUID": true, "XML": true,
+}
+
+// goName converts key of property to exported go identifier, like user_id to UserID
+func goName(key string) string {
+	words := strings.FieldsFunc(key, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	b := strings.Builder{}
+
+	for _, word := range words {
+		if upper := strings.ToUpper(word); commonInitialisms[upper] {
+			b.WriteString(upper)
+			continue
+		}
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		b.WriteString(string(runes))
+	}
+
+	name := b.String()
+
+	if name == "" || unicode.IsDigit([]rune(name)[0]) {
+		name = "X" + name
+	}
+
+	return name
+}
+
+var fileTemplate = template.Must(template.New("operator").Parse(`// Code generated by operator-gen from {{ .Operator }}; DO NOT EDIT.
+
+package {{ .Package }}
+
+import (
+{{- range $i, $group := .Imports }}
+{{- if $i }}
+{{ end }}
+{{- range $group }}
+	{{ printf "%q" . }}
+{{- end }}
+{{- end }}
+)
+{{ range .Decls }}
+{{ . }}
+{{ end }}
+// Handler handles each of inputs, and returns output to put.
+{{- if eq .Output.Type "*Output" }}
+// Nothing put when output is nil.
+{{- end }}
+type Handler = func(ctx context.Context, input {{ .Input.Type }}) ({{ .Output.Type }}, error)
+
+// NewOperatorHandlerFunc wraps handle as pipeline.OperatorHandlerFunc of {{ .Operator }}.
+func NewOperatorHandlerFunc(handle Handler) pipeline.OperatorHandlerFunc {
+	return func(t pipeline.Transfer) error {
+		for t.Scan() {
+{{- if .Input.JSON }}
+{{- if eq .Input.Type "*Input" }}
+			input := &Input{}
+			if err := pipeline.ReadNextJSON(t, input); err != nil {
+{{- else }}
+			var input Input
+			if err := pipeline.ReadNextJSON(t, &input); err != nil {
+{{- end }}
+				return err
+			}
+{{- else }}
+			var input Input
+			if err := pipeline.ReadNext(t, func(r io.Reader) (err error) {
+				input, err = ioutil.ReadAll(r)
+				return
+			}); err != nil {
+				return err
+			}
+{{- end }}
+
+			output, err := handle(t.Context(), input)
+			if err != nil {
+				return err
+			}
+{{ if eq .Output.Type "*Output" }}
+			if output == nil {
+				continue
+			}
+{{ end }}
+{{- if .Output.JSON }}
+			if err := pipeline.PutJSON(t, output); err != nil {
+				return err
+			}
+{{- else }}
+			if err := t.Put(pipeline.WithContentType({{ printf "%q" .Output.ContentType }})(bytes.NewBuffer(output))); err != nil {
+				return err
+			}
+{{- end }}
+		}
+		return nil
+	}
+}
+`))
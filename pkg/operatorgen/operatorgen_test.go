@@ -0,0 +1,139 @@
+package operatorgen
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"testing"
+
+	"github.com/go-courier/semver"
+	. "github.com/onsi/gomega"
+	"github.com/querycap/pipeline/spec"
+)
+
+var (
+	fset = token.NewFileSet()
+	// imported packages cached, shared by checks
+	sourceImporter = importer.ForCompiler(fset, "source", nil)
+)
+
+// typeCheck parses and type checks generated source with imports from source
+func typeCheck(src []byte) error {
+	f, err := parser.ParseFile(fset, "operator.go", src, 0)
+	if err != nil {
+		return err
+	}
+
+	conf := types.Config{Importer: sourceImporter}
+
+	_, err = conf.Check(f.Name.Name, fset, []*ast.File{f}, nil)
+	return err
+}
+
+func TestGenerate(t *testing.T) {
+	op := &spec.Operator{
+		Project: spec.Project{Group: "querycap", Name: "greet", Version: *semver.MustParseVersion("1.0.0")},
+		OperatorMeta: spec.OperatorMeta{
+			Inputs: spec.DataSchema{
+				ContentType: "application/json",
+				Schema: spec.Schema{
+					Type:     "object",
+					Required: []string{"name", "owner"},
+					Properties: map[string]*spec.Schema{
+						"name":       {Type: "string", Description: "name of person"},
+						"age":        {Type: "integer", Nullable: true},
+						"created_at": {Type: "string", Format: "date-time"},
+						"owner":      {Ref: "#/definitions/Person"},
+					},
+					Definitions: map[string]*spec.Schema{
+						"Person": {
+							Type:     "object",
+							Required: []string{"name"},
+							Properties: map[string]*spec.Schema{
+								"name":    {Type: "string"},
+								"friends": {Type: "array", Items: &spec.Schema{Ref: "#/definitions/Person"}},
+							},
+						},
+					},
+				},
+			},
+			Outputs: spec.DataSchema{
+				ContentType: "application/json",
+				Schema:      spec.Schema{Type: "array", Items: &spec.Schema{Type: "string"}},
+			},
+		},
+	}
+
+	src, err := Generate(op, "greet")
+	NewWithT(t).Expect(err).To(BeNil())
+
+	code := string(src)
+
+	NewWithT(t).Expect(code).To(ContainSubstring("package greet"))
+	NewWithT(t).Expect(code).To(ContainSubstring("\"time\"\n\n\t\"github.com/querycap/pipeline/pipeline\""))
+	NewWithT(t).Expect(code).To(ContainSubstring("Age       *int64     `json:\"age,omitempty\"`"))
+	NewWithT(t).Expect(code).To(ContainSubstring("CreatedAt *time.Time `json:\"created_at,omitempty\"`"))
+	NewWithT(t).Expect(code).To(ContainSubstring("// name of person\n\tName  string `json:\"name\" description:\"name of person\"`"))
+	NewWithT(t).Expect(code).To(ContainSubstring("Owner Person `json:\"owner\"`"))
+	NewWithT(t).Expect(code).To(ContainSubstring("Friends []Person `json:\"friends,omitempty\"`"))
+	NewWithT(t).Expect(code).To(ContainSubstring("type Output = []string"))
+	NewWithT(t).Expect(code).To(ContainSubstring("type Handler = func(ctx context.Context, input *Input) (Output, error)"))
+	NewWithT(t).Expect(code).To(ContainSubstring("pipeline.ReadNextJSON(t, input)"))
+	NewWithT(t).Expect(code).To(ContainSubstring("pipeline.PutJSON(t, output)"))
+	NewWithT(t).Expect(typeCheck(src)).To(BeNil())
+
+	t.Run("scalar", func(t *testing.T) {
+		src, err := Generate(&spec.Operator{
+			OperatorMeta: spec.OperatorMeta{
+				Inputs:  spec.DataSchema{ContentType: "application/json", Schema: spec.Schema{Type: "string"}},
+				Outputs: spec.DataSchema{ContentType: "application/json", Schema: spec.Schema{Type: "integer"}},
+			},
+		}, "scalar")
+		NewWithT(t).Expect(err).To(BeNil())
+
+		code := string(src)
+
+		NewWithT(t).Expect(code).To(ContainSubstring("var input Input"))
+		NewWithT(t).Expect(code).To(ContainSubstring("pipeline.ReadNextJSON(t, &input)"))
+		NewWithT(t).Expect(typeCheck(src)).To(BeNil())
+	})
+
+	t.Run("raw", func(t *testing.T) {
+		src, err := Generate(&spec.Operator{
+			OperatorMeta: spec.OperatorMeta{
+				Inputs:  spec.DataSchema{ContentType: "image/png"},
+				Outputs: spec.DataSchema{ContentType: "text/plain"},
+			},
+		}, "raw")
+		NewWithT(t).Expect(err).To(BeNil())
+
+		code := string(src)
+
+		NewWithT(t).Expect(code).To(ContainSubstring("type Input []byte"))
+		NewWithT(t).Expect(code).To(ContainSubstring("type Output []byte"))
+		NewWithT(t).Expect(code).To(ContainSubstring("input, err = ioutil.ReadAll(r)"))
+		NewWithT(t).Expect(code).To(ContainSubstring(`pipeline.WithContentType("text/plain")(bytes.NewBuffer(output))`))
+		NewWithT(t).Expect(typeCheck(src)).To(BeNil())
+	})
+
+	t.Run("unresolvable $ref", func(t *testing.T) {
+		_, err := Generate(&spec.Operator{
+			OperatorMeta: spec.OperatorMeta{
+				Inputs: spec.DataSchema{
+					ContentType: "application/json",
+					Schema:      spec.Schema{Type: "object", Properties: map[string]*spec.Schema{"x": {Ref: "#/definitions/X"}}},
+				},
+			},
+		}, "broken")
+		NewWithT(t).Expect(err).NotTo(BeNil())
+	})
+}
+
+func TestGoName(t *testing.T) {
+	NewWithT(t).Expect(goName("user_id")).To(Equal("UserID"))
+	NewWithT(t).Expect(goName("createdAt")).To(Equal("CreatedAt"))
+	NewWithT(t).Expect(goName("image-url")).To(Equal("ImageURL"))
+	NewWithT(t).Expect(goName("1st")).To(Equal("X1st"))
+}
@@ -0,0 +1,25 @@
+package redisutil
+
+import (
+	"time"
+)
+
+// Backoff for retrying when connection dropped, doubled from Min up to Max until reset.
+type Backoff struct {
+	Min time.Duration
+	Max time.Duration
+	d   time.Duration
+}
+
+func (b *Backoff) Next() time.Duration {
+	if b.d == 0 {
+		b.d = b.Min
+	} else if b.d *= 2; b.d > b.Max {
+		b.d = b.Max
+	}
+	return b.d
+}
+
+func (b *Backoff) Reset() {
+	b.d = 0
+}
@@ -18,6 +18,8 @@ type PipelineFlow struct {
 	Stages map[string]Stage `json:"stages" yaml:"stages"`
 	Starts string           `json:"starts" yaml:"starts"`
 	Ends   string           `json:"ends" yaml:"ends"`
+	// validate inputs and outputs of stages against schemas in OperatorMeta of operators
+	ValidateSchemas bool `json:"validateSchemas,omitempty" yaml:"validateSchemas,omitempty"`
 }
 
 func (o Pipeline) String() string {
@@ -29,11 +31,43 @@ func (o Pipeline) RefID() string {
 }
 
 type Stage struct {
-	Deps      []string `json:"deps" yaml:"deps"`
-	Uses      Ref      `json:"uses" yaml:"uses"`
+	Deps      []string      `json:"deps" yaml:"deps"`
+	Uses      Ref           `json:"uses" yaml:"uses"`
+	Retry     *RetryPolicy  `json:"retry,omitempty" yaml:"retry,omitempty"`
+	Timeout   Duration      `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	Cache     *Cache        `json:"cache,omitempty" yaml:"cache,omitempty"`
+	HTTP      *HTTPEndpoint `json:"http,omitempty" yaml:"http,omitempty"`
+	Scaling   `yaml:",inline"`
 	Container `yaml:",inline"`
 }
 
+// Cache memoizes results of stage by its operator, container and content of inputs.
+type Cache struct {
+	// results cached forever when zero, until results deleted.
+	TTL Duration `json:"ttl,omitempty" yaml:"ttl,omitempty"`
+}
+
+// HTTPEndpoint of operator, called for each task with inputs as request body.
+type HTTPEndpoint struct {
+	URL string `json:"url" yaml:"url"`
+	// POST when empty
+	Method string `json:"method,omitempty" yaml:"method,omitempty"`
+	// not stored with the pipeline spec, so dropped once pipeline restored,
+	// credentials and headers needed after restored should be set in endpoints registered to the operator mgr.
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+}
+
+type Scaling struct {
+	Replicas int32 `json:"replicas,omitempty" yaml:"replicas,omitempty"`
+	// autoscaling enabled when maxReplicas greater than minReplicas
+	MinReplicas int32 `json:"minReplicas,omitempty" yaml:"minReplicas,omitempty"`
+	MaxReplicas int32 `json:"maxReplicas,omitempty" yaml:"maxReplicas,omitempty"`
+}
+
+func (r Scaling) Autoscaling() bool {
+	return r.MaxReplicas > r.MinReplicas
+}
+
 type Container struct {
 	Command []string `json:"command,omitempty" yaml:"command,omitempty"`
 	Args    []string `json:"args,omitempty" yaml:"args,omitempty"`
@@ -0,0 +1,84 @@
+package spec
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// OperatorCatalog looks up operator by ref
+type OperatorCatalog interface {
+	Operator(ref Ref) (*Operator, error)
+}
+
+type OperatorCatalogFunc func(ref Ref) (*Operator, error)
+
+func (fn OperatorCatalogFunc) Operator(ref Ref) (*Operator, error) {
+	return fn(ref)
+}
+
+// IncompatibleEdge from stage to stage depends on it
+type IncompatibleEdge struct {
+	From    string
+	To      string
+	Reasons []string
+}
+
+func (e *IncompatibleEdge) Error() string {
+	return fmt.Sprintf("stage %s -> %s: %s", e.From, e.To, strings.Join(e.Reasons, ", "))
+}
+
+type IncompatibleEdges []*IncompatibleEdge
+
+func (edges IncompatibleEdges) Error() string {
+	msgs := make([]string, len(edges))
+	for i := range edges {
+		msgs[i] = edges[i].Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Check outputs of each stage are compatible with inputs of stages depends on it,
+// by OperatorMeta of operators of stages.
+// All incompatible edges found are returned as IncompatibleEdges.
+func (o Pipeline) Check(catalog OperatorCatalog) error {
+	names := make([]string, 0, len(o.Stages))
+	for name := range o.Stages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	operators := map[string]*Operator{}
+
+	for _, name := range names {
+		op, err := catalog.Operator(o.Stages[name].Uses)
+		if err != nil {
+			return fmt.Errorf("operator %s of stage %s not found: %w", o.Stages[name].Uses, name, err)
+		}
+		if op == nil {
+			return fmt.Errorf("operator %s of stage %s not found", o.Stages[name].Uses, name)
+		}
+		operators[name] = op
+	}
+
+	edges := IncompatibleEdges{}
+
+	for _, name := range names {
+		for _, dep := range o.Stages[name].Deps {
+			upstream, ok := operators[dep]
+			if !ok {
+				continue
+			}
+
+			if reasons := upstream.Outputs.CompatibleWith(operators[name].Inputs); len(reasons) > 0 {
+				edges = append(edges, &IncompatibleEdge{From: dep, To: name, Reasons: reasons})
+			}
+		}
+	}
+
+	if len(edges) > 0 {
+		return edges
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package spec
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-courier/semver"
+	. "github.com/onsi/gomega"
+)
+
+func TestPipelineCheck(t *testing.T) {
+	person := Schema{
+		Type:     "object",
+		Required: []string{"name"},
+		Properties: map[string]*Schema{
+			"name": {Type: "string"},
+			"age":  {Type: "integer"},
+		},
+	}
+
+	operators := map[string]*Operator{
+		"person:1.0.0": {OperatorMeta: OperatorMeta{
+			Outputs: DataSchema{ContentType: "application/json", Schema: person},
+		}},
+		"adult:1.0.0": {OperatorMeta: OperatorMeta{
+			Inputs: DataSchema{ContentType: "application/json", Schema: Schema{
+				Type:     "object",
+				Required: []string{"name", "age"},
+				Properties: map[string]*Schema{
+					"name": {Type: "string"},
+					"age":  {Type: "number"},
+				},
+			}},
+		}},
+		"named:1.0.0": {OperatorMeta: OperatorMeta{
+			Inputs: DataSchema{ContentType: "application/json", Schema: Schema{
+				Type:       "object",
+				Properties: map[string]*Schema{"name": {Type: "array"}},
+			}},
+		}},
+		"image:1.0.0": {OperatorMeta: OperatorMeta{
+			Inputs: DataSchema{ContentType: "image/*"},
+		}},
+		"any:1.0.0": {},
+	}
+
+	catalog := OperatorCatalogFunc(func(ref Ref) (*Operator, error) {
+		if op, ok := operators[ref.RefID()]; ok {
+			return op, nil
+		}
+		return nil, errors.New("not found")
+	})
+
+	ref := func(name string) Ref {
+		return *NewRefOperator(name, *semver.MustParseVersion("1.0.0"))
+	}
+
+	p := Pipeline{
+		PipelineFlow: PipelineFlow{
+			Starts: "a",
+			Ends:   "e",
+			Stages: map[string]Stage{
+				"a": {Uses: ref("person")},
+				"b": {Uses: ref("adult"), Deps: []string{"a"}},
+				"c": {Uses: ref("named"), Deps: []string{"a"}},
+				"d": {Uses: ref("image"), Deps: []string{"a"}},
+				"e": {Uses: ref("any"), Deps: []string{"b", "c", "d"}},
+			},
+		},
+	}
+
+	err := p.Check(catalog)
+
+	NewWithT(t).Expect(err).To(Equal(IncompatibleEdges{
+		{From: "a", To: "b", Reasons: []string{"$.age: optional, but required"}},
+		{From: "a", To: "c", Reasons: []string{"$.name: string produced, but array required"}},
+		{From: "a", To: "d", Reasons: []string{"content type application/json produced, but image/* required"}},
+	}))
+
+	NewWithT(t).Expect(err.Error()).To(ContainSubstring("stage a -> c: $.name: string produced, but array required"))
+
+	t.Run("unknown operator", func(t *testing.T) {
+		p.Stages["f"] = Stage{Uses: ref("unknown")}
+		defer delete(p.Stages, "f")
+
+		NewWithT(t).Expect(p.Check(catalog)).NotTo(BeNil())
+	})
+}
@@ -0,0 +1,220 @@
+package spec
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type ValidationError struct {
+	Stage string
+	Msg   string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Stage == "" {
+		return e.Msg
+	}
+	return fmt.Sprintf("stage %s: %s", e.Stage, e.Msg)
+}
+
+type ValidationErrors []*ValidationError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i := range errs {
+		msgs[i] = errs[i].Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Validate checks the stages of pipeline forms a valid DAG from Starts to Ends,
+// stages depend on Ends are unreachable, since task finished at Ends.
+// All problems found are returned as ValidationErrors.
+func (o Pipeline) Validate() error {
+	errs := ValidationErrors{}
+
+	report := func(stage string, format string, args ...interface{}) {
+		errs = append(errs, &ValidationError{Stage: stage, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	if len(o.Stages) == 0 {
+		report("", "missing stages")
+		return errs
+	}
+
+	names := make([]string, 0, len(o.Stages))
+	for name := range o.Stages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if o.Starts == "" {
+		report("", "missing starts")
+	} else if _, ok := o.Stages[o.Starts]; !ok {
+		report(o.Starts, "starts not found in stages")
+	} else if len(o.Stages[o.Starts].Deps) > 0 {
+		report(o.Starts, "starts should not have deps")
+	}
+
+	if o.Ends == "" {
+		report("", "missing ends")
+	} else if _, ok := o.Stages[o.Ends]; !ok {
+		report(o.Ends, "ends not found in stages")
+	}
+
+	// stage to stages depends on it
+	downstreams := map[string][]string{}
+
+	for _, name := range names {
+		if retry := o.Stages[name].Retry; retry != nil {
+			if retry.MaxAttempts < 1 {
+				report(name, "retry.maxAttempts should be greater than 0")
+			}
+			for _, pattern := range retry.RetryOn {
+				if _, err := regexp.Compile(pattern); err != nil {
+					report(name, "retry.retryOn has invalid pattern %s", pattern)
+				}
+			}
+		}
+
+		if o.Stages[name].Timeout < 0 {
+			report(name, "timeout should not be negative")
+		}
+
+		if cache := o.Stages[name].Cache; cache != nil && cache.TTL < 0 {
+			report(name, "cache.ttl should not be negative")
+		}
+
+		if endpoint := o.Stages[name].HTTP; endpoint != nil {
+			if u, err := url.Parse(endpoint.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				report(name, "http.url should be absolute http(s) url, but got %q", endpoint.URL)
+			}
+		}
+
+		if r := o.Stages[name].Scaling; r.Replicas < 0 || r.MinReplicas < 0 || r.MaxReplicas < 0 {
+			report(name, "replicas should not be negative")
+		} else if r.MaxReplicas > 0 {
+			if r.MinReplicas > r.MaxReplicas {
+				report(name, "minReplicas %d should not be greater than maxReplicas %d", r.MinReplicas, r.MaxReplicas)
+			}
+			if r.Replicas > r.MaxReplicas || (r.Replicas > 0 && r.Replicas < r.MinReplicas) {
+				report(name, "replicas %d should be in range [%d, %d]", r.Replicas, r.MinReplicas, r.MaxReplicas)
+			}
+		}
+
+		seen := map[string]bool{}
+
+		for _, dep := range o.Stages[name].Deps {
+			if seen[dep] {
+				report(name, "duplicated dep %s", dep)
+				continue
+			}
+			seen[dep] = true
+
+			if _, ok := o.Stages[dep]; !ok {
+				report(name, "unknown dep %s", dep)
+				continue
+			}
+
+			downstreams[dep] = append(downstreams[dep], name)
+		}
+	}
+
+	for _, cycle := range cycles(names, downstreams) {
+		report(cycle[0], "cycle found %s", strings.Join(cycle, " -> "))
+	}
+
+	if _, ok := o.Stages[o.Starts]; ok {
+		walk := func(from string, reached map[string]bool) {
+			var next func(name string)
+			next = func(name string) {
+				if reached[name] {
+					return
+				}
+				reached[name] = true
+				// task finished at ends, stages after never run
+				if name == o.Ends {
+					return
+				}
+				for _, n := range downstreams[name] {
+					next(n)
+				}
+			}
+			next(from)
+		}
+
+		reached := map[string]bool{}
+		walk(o.Starts, reached)
+
+		afterEnds := map[string]bool{}
+		for _, n := range downstreams[o.Ends] {
+			walk(n, afterEnds)
+		}
+
+		for _, name := range names {
+			switch {
+			case afterEnds[name]:
+				// joins wait for all deps, so reached from other deps not helps
+				report(name, "unreachable from starts %s, since depends on ends %s", o.Starts, o.Ends)
+			case reached[name]:
+			case name == o.Ends:
+				report(name, "ends unreachable from starts %s", o.Starts)
+			default:
+				report(name, "unreachable from starts %s", o.Starts)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func cycles(names []string, downstreams map[string][]string) [][]string {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+
+	states := map[string]int{}
+	path := make([]string, 0)
+	found := make([][]string, 0)
+
+	var visit func(name string)
+	visit = func(name string) {
+		switch states[name] {
+		case visited:
+			return
+		case visiting:
+			for i := range path {
+				if path[i] == name {
+					cycle := append(append([]string{}, path[i:]...), name)
+					found = append(found, cycle)
+					break
+				}
+			}
+			return
+		}
+
+		states[name] = visiting
+		path = append(path, name)
+
+		for _, next := range downstreams[name] {
+			visit(next)
+		}
+
+		path = path[:len(path)-1]
+		states[name] = visited
+	}
+
+	for _, name := range names {
+		visit(name)
+	}
+
+	return found
+}
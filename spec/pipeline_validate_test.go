@@ -0,0 +1,114 @@
+package spec
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestPipelineValidate(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		p := Pipeline{
+			PipelineFlow: PipelineFlow{
+				Starts: "a",
+				Ends:   "d",
+				Stages: map[string]Stage{
+					"a": {},
+					"b": {Deps: []string{"a"}},
+					"c": {Deps: []string{"a"}},
+					"d": {Deps: []string{"b", "c"}},
+				},
+			},
+		}
+
+		NewWithT(t).Expect(p.Validate()).To(BeNil())
+	})
+
+	t.Run("missing stages", func(t *testing.T) {
+		err := Pipeline{}.Validate()
+
+		NewWithT(t).Expect(err).To(Equal(ValidationErrors{{Msg: "missing stages"}}))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		p := Pipeline{
+			PipelineFlow: PipelineFlow{
+				Starts: "x",
+				Ends:   "y",
+				Stages: map[string]Stage{
+					"a": {Deps: []string{"z"}},
+				},
+			},
+		}
+
+		NewWithT(t).Expect(p.Validate()).To(Equal(ValidationErrors{
+			{Stage: "x", Msg: "starts not found in stages"},
+			{Stage: "y", Msg: "ends not found in stages"},
+			{Stage: "a", Msg: "unknown dep z"},
+		}))
+	})
+
+	t.Run("cycle and unreachable", func(t *testing.T) {
+		p := Pipeline{
+			PipelineFlow: PipelineFlow{
+				Starts: "a",
+				Ends:   "e",
+				Stages: map[string]Stage{
+					"a": {},
+					"b": {Deps: []string{"a", "c"}},
+					"c": {Deps: []string{"b", "b"}},
+					"d": {},
+					"e": {Deps: []string{"d"}},
+				},
+			},
+		}
+
+		err := p.Validate()
+
+		NewWithT(t).Expect(err).To(Equal(ValidationErrors{
+			{Stage: "c", Msg: "duplicated dep b"},
+			{Stage: "b", Msg: "cycle found b -> c -> b"},
+			{Stage: "d", Msg: "unreachable from starts a"},
+			{Stage: "e", Msg: "ends unreachable from starts a"},
+		}))
+
+		NewWithT(t).Expect(err.Error()).To(ContainSubstring("stage b: cycle found b -> c -> b"))
+	})
+
+	t.Run("after ends", func(t *testing.T) {
+		p := Pipeline{
+			PipelineFlow: PipelineFlow{
+				Starts: "a",
+				Ends:   "b",
+				Stages: map[string]Stage{
+					"a": {},
+					"b": {Deps: []string{"a"}},
+					"c": {Deps: []string{"b"}},
+					"d": {Deps: []string{"a", "c"}},
+				},
+			},
+		}
+
+		NewWithT(t).Expect(p.Validate()).To(Equal(ValidationErrors{
+			{Stage: "c", Msg: "unreachable from starts a, since depends on ends b"},
+			{Stage: "d", Msg: "unreachable from starts a, since depends on ends b"},
+		}))
+	})
+
+	t.Run("invalid http endpoint", func(t *testing.T) {
+		p := Pipeline{
+			PipelineFlow: PipelineFlow{
+				Starts: "a",
+				Ends:   "b",
+				Stages: map[string]Stage{
+					"a": {HTTP: &HTTPEndpoint{URL: "http://operator/a"}},
+					"b": {Deps: []string{"a"}, HTTP: &HTTPEndpoint{URL: "/b"}},
+				},
+			},
+		}
+
+		NewWithT(t).Expect(p.Validate()).To(Equal(ValidationErrors{
+			{Stage: "b", Msg: `http.url should be absolute http(s) url, but got "/b"`},
+		}))
+	})
+}
@@ -0,0 +1,45 @@
+package spec
+
+import (
+	"math"
+	"regexp"
+	"time"
+)
+
+type RetryPolicy struct {
+	// max attempts including the first one
+	MaxAttempts    int      `json:"maxAttempts" yaml:"maxAttempts"`
+	InitialBackoff Duration `json:"initialBackoff,omitempty" yaml:"initialBackoff,omitempty"`
+	// backoff grows by multiplier for each attempt, keep constant when not set.
+	Multiplier float64 `json:"multiplier,omitempty" yaml:"multiplier,omitempty"`
+	// patterns in regexp of error messages which could be retried, all errors could be retried when empty.
+	RetryOn []string `json:"retryOn,omitempty" yaml:"retryOn,omitempty"`
+}
+
+// ShouldRetry returns whether err of the attempt (starts from 0) could be retried.
+func (p RetryPolicy) ShouldRetry(attempt int, err error) bool {
+	if err == nil || attempt+1 >= p.MaxAttempts {
+		return false
+	}
+
+	if len(p.RetryOn) == 0 {
+		return true
+	}
+
+	for _, pattern := range p.RetryOn {
+		if matched, _ := regexp.MatchString(pattern, err.Error()); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Backoff returns the duration to wait before the next attempt of the attempt (starts from 0) failed.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = 1
+	}
+	return time.Duration(float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt)))
+}
@@ -0,0 +1,42 @@
+package spec
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v2"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	stage := Stage{}
+
+	err := yaml.Unmarshal([]byte(`
+uses: sys/fetch:1.0.0
+retry:
+  maxAttempts: 3
+  initialBackoff: 100ms
+  multiplier: 2
+  retryOn: ["timeout$", "^connection"]
+`), &stage)
+	NewWithT(t).Expect(err).To(BeNil())
+
+	p := *stage.Retry
+
+	NewWithT(t).Expect(p.InitialBackoff).To(Equal(Duration(100 * time.Millisecond)))
+
+	t.Run("should retry", func(t *testing.T) {
+		NewWithT(t).Expect(p.ShouldRetry(0, errors.New("connection refused"))).To(BeTrue())
+		NewWithT(t).Expect(p.ShouldRetry(1, errors.New("read timeout"))).To(BeTrue())
+		NewWithT(t).Expect(p.ShouldRetry(2, errors.New("read timeout"))).To(BeFalse())
+		NewWithT(t).Expect(p.ShouldRetry(0, errors.New("invalid input"))).To(BeFalse())
+		NewWithT(t).Expect(p.ShouldRetry(0, nil)).To(BeFalse())
+	})
+
+	t.Run("backoff", func(t *testing.T) {
+		NewWithT(t).Expect(p.Backoff(0)).To(Equal(100 * time.Millisecond))
+		NewWithT(t).Expect(p.Backoff(1)).To(Equal(200 * time.Millisecond))
+		NewWithT(t).Expect(RetryPolicy{InitialBackoff: Duration(time.Second)}.Backoff(3)).To(Equal(time.Second))
+	})
+}
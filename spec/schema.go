@@ -1,18 +1,267 @@
 package spec
 
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
 type Schema struct {
-	Description string `json:"description,omitempty"`
+	// $ref to definitions of root schema, like #/definitions/Person
+	Ref         string `json:"$ref,omitempty" yaml:"$ref,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
 	Type        string `json:"type,omitempty" yaml:"type,omitempty"`
+	// null allowed besides Type
+	Nullable bool        `json:"nullable,omitempty" yaml:"nullable,omitempty"`
+	Default  interface{} `json:"default,omitempty" yaml:"default,omitempty"`
 
 	Format string `json:"format,omitempty" yaml:"format,omitempty"`
 
-	Items *Schema `json:"items,omitempty" yaml:"items,omitempty"`
+	Enum  []interface{} `json:"enum,omitempty" yaml:"enum,omitempty"`
+	Const interface{}   `json:"const,omitempty" yaml:"const,omitempty"`
+
+	// number
+	Minimum          *float64 `json:"minimum,omitempty" yaml:"minimum,omitempty"`
+	Maximum          *float64 `json:"maximum,omitempty" yaml:"maximum,omitempty"`
+	ExclusiveMinimum *float64 `json:"exclusiveMinimum,omitempty" yaml:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum *float64 `json:"exclusiveMaximum,omitempty" yaml:"exclusiveMaximum,omitempty"`
+
+	// string
+	MinLength *uint64 `json:"minLength,omitempty" yaml:"minLength,omitempty"`
+	MaxLength *uint64 `json:"maxLength,omitempty" yaml:"maxLength,omitempty"`
+	Pattern   string  `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+
+	// array
+	Items    *Schema `json:"items,omitempty" yaml:"items,omitempty"`
+	MinItems *uint64 `json:"minItems,omitempty" yaml:"minItems,omitempty"`
+	MaxItems *uint64 `json:"maxItems,omitempty" yaml:"maxItems,omitempty"`
 
+	// object
 	Required             []string           `json:"required,omitempty" yaml:"required,omitempty"`
 	Properties           map[string]*Schema `json:"properties,omitempty" yaml:"properties,omitempty"`
 	PropertyNames        *Schema            `json:"propertyNames,omitempty" yaml:"propertyNames,omitempty"`
 	AdditionalProperties *Schema            `json:"additionalProperties,omitempty" yaml:"additionalProperties,omitempty"`
 
-	AllOf []*Schema `json:"allOf,omitempty"`
-	OneOf []*Schema `json:"oneOf,omitempty"`
+	AllOf []*Schema `json:"allOf,omitempty" yaml:"allOf,omitempty"`
+	AnyOf []*Schema `json:"anyOf,omitempty" yaml:"anyOf,omitempty"`
+	OneOf []*Schema `json:"oneOf,omitempty" yaml:"oneOf,omitempty"`
+	Not   *Schema   `json:"not,omitempty" yaml:"not,omitempty"`
+
+	Definitions map[string]*Schema `json:"definitions,omitempty" yaml:"definitions,omitempty"`
+}
+
+// ParseJSONSchema parses draft-07 JSON Schema document,
+// boolean schemas and type arrays with null are converted too.
+func ParseJSONSchema(data []byte) (*Schema, error) {
+	s := &Schema{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// JSONSchema returns draft-07 JSON Schema document of the schema,
+// Nullable is converted to type array with null.
+func (s Schema) JSONSchema() ([]byte, error) {
+	data, err := json.Marshal(toJSONSchema(&s))
+	if err != nil {
+		return nil, err
+	}
+
+	// $schema as the first key
+	return append([]byte(`{"$schema":"http://json-schema.org/draft-07/schema#",`), bytes.TrimPrefix(data, []byte("{"))...), nil
+}
+
+func toJSONSchema(s *Schema) interface{} {
+	if s == nil {
+		return nil
+	}
+
+	if s.isFalse() {
+		return false
+	}
+
+	m := map[string]interface{}{}
+
+	data, _ := json.Marshal(s.shallow())
+	_ = json.Unmarshal(data, &m)
+
+	if s.Nullable {
+		delete(m, "nullable")
+		if s.Type != "" {
+			m["type"] = []string{s.Type, "null"}
+		}
+	}
+
+	sub := func(key string, s *Schema) {
+		if s != nil {
+			m[key] = toJSONSchema(s)
+		}
+	}
+
+	subs := func(key string, list []*Schema) {
+		if len(list) > 0 {
+			values := make([]interface{}, len(list))
+			for i := range list {
+				values[i] = toJSONSchema(list[i])
+			}
+			m[key] = values
+		}
+	}
+
+	subMap := func(key string, schemas map[string]*Schema) {
+		if len(schemas) > 0 {
+			values := map[string]interface{}{}
+			for k := range schemas {
+				values[k] = toJSONSchema(schemas[k])
+			}
+			m[key] = values
+		}
+	}
+
+	sub("items", s.Items)
+	sub("propertyNames", s.PropertyNames)
+	sub("additionalProperties", s.AdditionalProperties)
+	sub("not", s.Not)
+	subs("allOf", s.AllOf)
+	subs("anyOf", s.AnyOf)
+	subs("oneOf", s.OneOf)
+	subMap("properties", s.Properties)
+	subMap("definitions", s.Definitions)
+
+	// type array converted to anyOf when parsing
+	if s.Nullable && s.Type == "" && len(s.AnyOf) > 0 {
+		m["anyOf"] = append(m["anyOf"].([]interface{}), map[string]interface{}{"type": "null"})
+	}
+
+	return m
+}
+
+// shallow copy without sub schemas
+func (s *Schema) shallow() *Schema {
+	c := *s
+	c.Items, c.PropertyNames, c.AdditionalProperties, c.Not = nil, nil, nil, nil
+	c.AllOf, c.AnyOf, c.OneOf = nil, nil, nil
+	c.Properties, c.Definitions = nil, nil
+	return &c
+}
+
+// false schema allows nothing
+func (s Schema) isFalse() bool {
+	return reflect.DeepEqual(s, Schema{Not: &Schema{}})
+}
+
+func (s Schema) MarshalJSON() ([]byte, error) {
+	if s.isFalse() {
+		return []byte("false"), nil
+	}
+
+	type schema Schema
+	return json.Marshal(schema(s))
+}
+
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	switch string(bytes.TrimSpace(data)) {
+	case "true":
+		*s = Schema{}
+		return nil
+	case "false":
+		*s = Schema{Not: &Schema{}}
+		return nil
+	}
+
+	type schema Schema
+
+	v := &struct {
+		*schema
+		Type json.RawMessage `json:"type,omitempty"`
+	}{
+		schema: (*schema)(s),
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+
+	if len(v.Type) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(v.Type, &s.Type); err == nil {
+		return nil
+	}
+
+	types := make([]string, 0)
+	if err := json.Unmarshal(v.Type, &types); err != nil {
+		return fmt.Errorf("invalid type %s", v.Type)
+	}
+
+	others := make([]string, 0, len(types))
+	for _, typ := range types {
+		if typ == "null" {
+			s.Nullable = true
+			continue
+		}
+		others = append(others, typ)
+	}
+
+	switch len(others) {
+	case 0:
+		if s.Nullable {
+			s.Type, s.Nullable = "null", false
+		}
+	case 1:
+		s.Type = others[0]
+	default:
+		for _, typ := range others {
+			s.AnyOf = append(s.AnyOf, &Schema{Type: typ})
+		}
+	}
+
+	return nil
+}
+
+func (s Schema) MarshalYAML() (interface{}, error) {
+	if s.isFalse() {
+		return false, nil
+	}
+
+	type schema Schema
+	return schema(s), nil
+}
+
+// UnmarshalYAML converts yaml to json and parses as UnmarshalJSON does,
+// so boolean schemas and type arrays are same in both.
+func (s *Schema) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var v interface{}
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(fromYAMLValue(v))
+	if err != nil {
+		return err
+	}
+
+	return s.UnmarshalJSON(data)
+}
+
+// yaml.v2 decodes mappings as map[interface{}]interface{}, which encoding/json not supports
+func fromYAMLValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k := range x {
+			m[fmt.Sprint(k)] = fromYAMLValue(x[k])
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(x))
+		for i := range x {
+			list[i] = fromYAMLValue(x[i])
+		}
+		return list
+	}
+	return v
 }
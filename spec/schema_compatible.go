@@ -0,0 +1,134 @@
+package spec
+
+import (
+	"fmt"
+	"sort"
+)
+
+// CompatibleWith checks whether values valid against the schema could be accepted by the downstream schema,
+// returns reasons of incompatibility with paths, like $.items[0].name.
+// Only definite conflicts are reported, parts not declared in either schema are treated as compatible.
+func (s *Schema) CompatibleWith(downstream *Schema) []string {
+	reasons := make([]string, 0)
+
+	c := &schemaCompatibility{
+		root:           s,
+		downstreamRoot: downstream,
+		visited:        map[[2]*Schema]bool{},
+		report: func(path string, format string, args ...interface{}) {
+			reasons = append(reasons, fmt.Sprintf("%s: %s", path, fmt.Sprintf(format, args...)))
+		},
+	}
+
+	c.compatibleWith("$", s, downstream)
+
+	return reasons
+}
+
+type schemaCompatibility struct {
+	// $ref of each side resolved in its own root
+	root           *Schema
+	downstreamRoot *Schema
+	// pairs checked, to stop on recursive $ref
+	visited map[[2]*Schema]bool
+	report  reportSchemaError
+}
+
+func (c *schemaCompatibility) compatibleWith(path string, s *Schema, downstream *Schema) {
+	s, downstream = resolveRefs(c.root, s), resolveRefs(c.downstreamRoot, downstream)
+
+	if s == nil || downstream == nil {
+		return
+	}
+
+	pair := [2]*Schema{s, downstream}
+	if c.visited[pair] {
+		return
+	}
+	c.visited[pair] = true
+
+	if s.Type != "" && downstream.Type != "" && !compatibleType(s.Type, downstream.Type) {
+		c.report(path, "%s produced, but %s required", s.Type, downstream.Type)
+		return
+	}
+
+	if s.Nullable && downstream.Type != "" && !downstream.Nullable {
+		c.report(path, "nullable, but null not allowed")
+	}
+
+	for _, sub := range downstream.AllOf {
+		c.compatibleWith(path, s, sub)
+	}
+
+	if s.Items != nil {
+		c.compatibleWith(path+"[*]", s.Items, downstream.Items)
+	}
+
+	// object declared in upstream
+	if s.Type == "object" || len(s.Properties) > 0 {
+		required := map[string]bool{}
+		for _, key := range s.Required {
+			required[key] = true
+		}
+
+		for _, key := range downstream.Required {
+			if required[key] {
+				continue
+			}
+			if _, ok := s.Properties[key]; ok {
+				c.report(propPath(path, key), "optional, but required")
+			} else if s.AdditionalProperties == nil {
+				c.report(propPath(path, key), "not produced, but required")
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(s.Properties))
+	for key := range s.Properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if prop, ok := downstream.Properties[key]; ok {
+			c.compatibleWith(propPath(path, key), s.Properties[key], prop)
+			continue
+		}
+		c.compatibleWith(propPath(path, key), s.Properties[key], downstream.AdditionalProperties)
+	}
+
+	if s.AdditionalProperties != nil {
+		c.compatibleWith(propPath(path, "*"), s.AdditionalProperties, downstream.AdditionalProperties)
+	}
+}
+
+// resolveRefs returns nil when $ref unresolvable, which treated as compatible
+func resolveRefs(root *Schema, s *Schema) *Schema {
+	for i := 0; s != nil && s.Ref != ""; i++ {
+		if i > maxRefDepth {
+			return nil
+		}
+		s = resolveRef(root, s.Ref)
+	}
+	return s
+}
+
+func compatibleType(typ string, downstreamType string) bool {
+	return typ == downstreamType || (typ == "integer" && downstreamType == "number")
+}
+
+// CompatibleWith checks content type and schema of outputs against inputs of downstream.
+func (d DataSchema) CompatibleWith(downstream DataSchema) []string {
+	reasons := make([]string, 0)
+
+	if d.ContentType != "" && !downstream.MatchContentType(d.ContentType) {
+		reasons = append(reasons, fmt.Sprintf("content type %s produced, but %s required", d.ContentType, downstream.ContentType))
+		return reasons
+	}
+
+	if d.IsJSON() || downstream.IsJSON() {
+		reasons = append(reasons, d.Schema.CompatibleWith(&downstream.Schema)...)
+	}
+
+	return reasons
+}
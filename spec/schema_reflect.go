@@ -0,0 +1,287 @@
+package spec
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SchemaOf derives schema of values of v encoded by encoding/json.
+//
+// Fields are named by json tags, and required unless tagged with omitempty,
+// pointers are nullable, description could be set by tag description,
+// and named structs are kept in definitions and referred by $ref, so recursive types are supported.
+func SchemaOf(v interface{}) (*Schema, error) {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return &Schema{}, nil
+	}
+
+	r := &schemaReflector{root: indirectType(t), names: map[reflect.Type]string{}, definitions: map[string]*Schema{}}
+
+	s, err := r.schemaOf(indirectType(t), true)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(r.definitions) > 0 {
+		s.Definitions = r.definitions
+	}
+
+	return s, nil
+}
+
+// MustSchemaOf panics when v could not be described, like channels or funcs in it.
+func MustSchemaOf(v interface{}) *Schema {
+	s, err := SchemaOf(v)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// JSONDataSchemaOf returns json DataSchema with schema of v.
+func JSONDataSchemaOf(v interface{}) (DataSchema, error) {
+	s, err := SchemaOf(v)
+	if err != nil {
+		return DataSchema{}, err
+	}
+	return DataSchema{ContentType: "application/json", Schema: *s}, nil
+}
+
+var (
+	typeTime            = reflect.TypeOf(time.Time{})
+	typeRawMessage      = reflect.TypeOf(json.RawMessage{})
+	typeJSONMarshaler   = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	typeTextMarshaler   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	typeTextUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+type schemaReflector struct {
+	root        reflect.Type
+	names       map[reflect.Type]string
+	definitions map[string]*Schema
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+func (r *schemaReflector) schemaOf(t reflect.Type, root bool) (*Schema, error) {
+	switch t {
+	case typeTime:
+		return &Schema{Type: "string", Format: "date-time"}, nil
+	case typeRawMessage:
+		return &Schema{}, nil
+	}
+
+	if t.Implements(typeJSONMarshaler) || reflect.PtrTo(t).Implements(typeJSONMarshaler) {
+		// encoded by itself, could be anything
+		return &Schema{}, nil
+	}
+
+	if t.Implements(typeTextMarshaler) || reflect.PtrTo(t).Implements(typeTextUnmarshaler) {
+		return &Schema{Type: "string"}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		s, err := r.schemaOf(indirectType(t), false)
+		if err != nil {
+			return nil, err
+		}
+		return nullable(s), nil
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: "integer"}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		min := float64(0)
+		return &Schema{Type: "integer", Minimum: &min}, nil
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}, nil
+	case reflect.String:
+		return &Schema{Type: "string"}, nil
+	case reflect.Interface:
+		return &Schema{}, nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 && t.Kind() == reflect.Slice {
+			// base64 encoded
+			return &Schema{Type: "string", Format: "byte"}, nil
+		}
+
+		items, err := r.schemaOf(t.Elem(), false)
+		if err != nil {
+			return nil, err
+		}
+
+		s := &Schema{Type: "array", Items: items}
+
+		if t.Kind() == reflect.Array {
+			n := uint64(t.Len())
+			s.MinItems, s.MaxItems = &n, &n
+		}
+
+		return s, nil
+	case reflect.Map:
+		switch t.Key().Kind() {
+		case reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		default:
+			if !t.Key().Implements(typeTextMarshaler) {
+				return nil, fmt.Errorf("unsupported map key %s", t.Key())
+			}
+		}
+
+		values, err := r.schemaOf(t.Elem(), false)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Schema{Type: "object", AdditionalProperties: values}, nil
+	case reflect.Struct:
+		if root || t.Name() == "" {
+			if t == r.root {
+				r.names[t] = ""
+			}
+			return r.structSchemaOf(t)
+		}
+		return r.refOf(t)
+	}
+
+	return nil, fmt.Errorf("unsupported type %s", t)
+}
+
+func nullable(s *Schema) *Schema {
+	// siblings of $ref ignored
+	if s.Ref != "" {
+		return &Schema{AnyOf: []*Schema{s, {Type: "null"}}}
+	}
+	if s.Type == "" {
+		return s
+	}
+	s.Nullable = true
+	return s
+}
+
+// refOf returns $ref of named struct, with definition added
+func (r *schemaReflector) refOf(t reflect.Type) (*Schema, error) {
+	if name, ok := r.names[t]; ok {
+		if name == "" {
+			return &Schema{Ref: "#"}, nil
+		}
+		return &Schema{Ref: "#/definitions/" + name}, nil
+	}
+
+	name := t.Name()
+	for i := 2; ; i++ {
+		if _, ok := r.definitions[name]; !ok {
+			break
+		}
+		name = t.Name() + strconv.Itoa(i)
+	}
+
+	r.names[t] = name
+	// placeholder for recursive types
+	r.definitions[name] = &Schema{}
+
+	s, err := r.structSchemaOf(t)
+	if err != nil {
+		return nil, err
+	}
+
+	r.definitions[name] = s
+
+	return &Schema{Ref: "#/definitions/" + name}, nil
+}
+
+func (r *schemaReflector) structSchemaOf(t reflect.Type) (*Schema, error) {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+
+	if err := r.addFields(s, t); err != nil {
+		return nil, err
+	}
+
+	if len(s.Properties) == 0 {
+		s.Properties = nil
+	}
+
+	sort.Strings(s.Required)
+
+	return s, nil
+}
+
+func (r *schemaReflector) addFields(s *Schema, t reflect.Type) error {
+	fields := make([]reflect.StructField, 0, t.NumField())
+	embedded := make([]reflect.StructField, 0)
+
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.Anonymous {
+			embedded = append(embedded, f)
+		} else {
+			fields = append(fields, f)
+		}
+	}
+
+	// fields of struct shadow promoted ones
+	for _, f := range append(fields, embedded...) {
+		tag, hasTag := f.Tag.Lookup("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, flags := tag, ""
+		if idx := strings.Index(tag, ","); idx >= 0 {
+			name, flags = tag[:idx], tag[idx:]
+		}
+
+		// fields of embedded struct are promoted
+		if f.Anonymous && name == "" && indirectType(f.Type).Kind() == reflect.Struct {
+			if err := r.addFields(s, indirectType(f.Type)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if f.PkgPath != "" {
+			continue
+		}
+
+		if !hasTag || name == "" {
+			name = f.Name
+		}
+
+		if _, ok := s.Properties[name]; ok {
+			continue
+		}
+
+		prop, err := r.schemaOf(f.Type, false)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %s", t, f.Name, err)
+		}
+
+		if strings.Contains(flags, ",string") && prop.Type != "" && prop.Type != "string" {
+			prop = &Schema{Type: "string"}
+		}
+
+		if description, ok := f.Tag.Lookup("description"); ok {
+			prop.Description = description
+		}
+
+		s.Properties[name] = prop
+
+		if !strings.Contains(flags, ",omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+
+	return nil
+}
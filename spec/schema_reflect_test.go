@@ -0,0 +1,96 @@
+package spec
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/go-courier/semver"
+
+	. "github.com/onsi/gomega"
+)
+
+type reflectPerson struct {
+	Name    string           `json:"name" description:"name of person"`
+	Friends []*reflectPerson `json:"friends,omitempty"`
+}
+
+type reflectBase struct {
+	ID   uint64 `json:"id"`
+	Kind string `json:"kind"`
+}
+
+type reflectInput struct {
+	reflectBase
+	Kind      int                `json:"kind"`
+	Owner     *reflectPerson     `json:"owner,omitempty"`
+	Data      []byte             `json:"data,omitempty"`
+	CreatedAt time.Time          `json:"createdAt"`
+	Labels    map[string]float64 `json:"labels,omitempty"`
+	Any       interface{}        `json:"any,omitempty"`
+	Version   Ref                `json:"version"`
+	Ignored   string             `json:"-"`
+	unexposed string
+}
+
+func TestSchemaOf(t *testing.T) {
+	s, err := SchemaOf(&reflectInput{})
+	NewWithT(t).Expect(err).To(BeNil())
+
+	min := float64(0)
+
+	NewWithT(t).Expect(s).To(Equal(&Schema{
+		Type:     "object",
+		Required: []string{"createdAt", "id", "kind", "version"},
+		Properties: map[string]*Schema{
+			"id":        {Type: "integer", Minimum: &min},
+			"kind":      {Type: "integer"},
+			"owner":     {AnyOf: []*Schema{{Ref: "#/definitions/reflectPerson"}, {Type: "null"}}},
+			"data":      {Type: "string", Format: "byte"},
+			"createdAt": {Type: "string", Format: "date-time"},
+			"labels":    {Type: "object", AdditionalProperties: &Schema{Type: "number"}},
+			"any":       {},
+			"version":   {Type: "string"},
+		},
+		Definitions: map[string]*Schema{
+			"reflectPerson": {
+				Type:     "object",
+				Required: []string{"name"},
+				Properties: map[string]*Schema{
+					"name": {Type: "string", Description: "name of person"},
+					"friends": {Type: "array", Items: &Schema{
+						AnyOf: []*Schema{{Ref: "#/definitions/reflectPerson"}, {Type: "null"}},
+					}},
+				},
+			},
+		},
+	}))
+
+	t.Run("values valid", func(t *testing.T) {
+		data, _ := json.Marshal(&reflectInput{
+			Owner:   &reflectPerson{Name: "x", Friends: []*reflectPerson{{Name: "y"}}},
+			Data:    []byte("data"),
+			Version: *NewRefOperator("x", *semver.MustParseVersion("1.0.0")),
+		})
+
+		var v interface{}
+		_ = json.Unmarshal(data, &v)
+
+		NewWithT(t).Expect(s.Validate(v)).To(BeNil())
+	})
+
+	t.Run("recursive root", func(t *testing.T) {
+		s := MustSchemaOf(reflectPerson{})
+
+		NewWithT(t).Expect(s.Properties["friends"].Items).To(Equal(&Schema{
+			AnyOf: []*Schema{{Ref: "#"}, {Type: "null"}},
+		}))
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := SchemaOf(struct {
+			C chan int `json:"c"`
+		}{})
+		NewWithT(t).Expect(err).NotTo(BeNil())
+	})
+}
@@ -0,0 +1,118 @@
+package spec
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v2"
+)
+
+func TestParseJSONSchema(t *testing.T) {
+	s, err := ParseJSONSchema([]byte(`{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "type": "object",
+  "properties": {
+    "name": { "type": ["string", "null"], "default": "x" },
+    "id": { "type": ["string", "integer"] },
+    "owner": { "$ref": "#/definitions/Person" }
+  },
+  "additionalProperties": false,
+  "definitions": {
+    "Person": { "type": "object", "required": ["name"], "additionalProperties": true }
+  }
+}`))
+	NewWithT(t).Expect(err).To(BeNil())
+
+	NewWithT(t).Expect(s).To(Equal(&Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"name":  {Type: "string", Nullable: true, Default: "x"},
+			"id":    {AnyOf: []*Schema{{Type: "string"}, {Type: "integer"}}},
+			"owner": {Ref: "#/definitions/Person"},
+		},
+		AdditionalProperties: &Schema{Not: &Schema{}},
+		Definitions: map[string]*Schema{
+			"Person": {Type: "object", Required: []string{"name"}, AdditionalProperties: &Schema{}},
+		},
+	}))
+
+	t.Run("round trip", func(t *testing.T) {
+		data, err := s.JSONSchema()
+		NewWithT(t).Expect(err).To(BeNil())
+
+		NewWithT(t).Expect(string(data)).To(Equal(`{"$schema":"http://json-schema.org/draft-07/schema#",` +
+			`"additionalProperties":false,` +
+			`"definitions":{"Person":{"additionalProperties":{},"required":["name"],"type":"object"}},` +
+			`"properties":{"id":{"anyOf":[{"type":"string"},{"type":"integer"}]},"name":{"default":"x","type":["string","null"]},"owner":{"$ref":"#/definitions/Person"}},` +
+			`"type":"object"}`))
+
+		parsed, err := ParseJSONSchema(data)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(parsed).To(Equal(s))
+	})
+
+	t.Run("invalid type", func(t *testing.T) {
+		_, err := ParseJSONSchema([]byte(`{"type":1}`))
+		NewWithT(t).Expect(err).NotTo(BeNil())
+	})
+}
+
+func TestUnmarshalYAMLSchema(t *testing.T) {
+	meta := OperatorMeta{}
+
+	err := yaml.Unmarshal([]byte(`
+inputs:
+  contentType: application/json
+  schema:
+    type: object
+    description: person
+    properties:
+      name: { type: [string, "null"], default: x }
+      id: { type: [string, integer] }
+      age: { anyOf: [{ type: integer }, { type: "null" }] }
+      tag: { oneOf: [{ type: string }, { const: 1 }] }
+      nick: { allOf: [{ type: string }, { not: { const: "" } }] }
+    additionalProperties: false
+outputs:
+  contentType: application/json
+  schema: true
+`), &meta)
+	NewWithT(t).Expect(err).To(BeNil())
+
+	s := meta.Inputs.Schema
+
+	NewWithT(t).Expect(s).To(Equal(Schema{
+		Type:        "object",
+		Description: "person",
+		Properties: map[string]*Schema{
+			"name": {Type: "string", Nullable: true, Default: "x"},
+			"id":   {AnyOf: []*Schema{{Type: "string"}, {Type: "integer"}}},
+			"age":  {AnyOf: []*Schema{{Type: "integer"}, {Type: "null"}}},
+			"tag":  {OneOf: []*Schema{{Type: "string"}, {Const: float64(1)}}},
+			"nick": {AllOf: []*Schema{{Type: "string"}, {Not: &Schema{Const: ""}}}},
+		},
+		AdditionalProperties: &Schema{Not: &Schema{}},
+	}))
+	NewWithT(t).Expect(meta.Outputs.Schema).To(Equal(Schema{}))
+
+	t.Run("validate", func(t *testing.T) {
+		NewWithT(t).Expect(s.Validate(map[string]interface{}{"name": nil, "age": float64(1), "tag": "a", "nick": "n"})).To(BeNil())
+		NewWithT(t).Expect(s.Validate(map[string]interface{}{"age": "1"})).NotTo(BeNil())
+		NewWithT(t).Expect(s.Validate(map[string]interface{}{"tag": true})).NotTo(BeNil())
+		NewWithT(t).Expect(s.Validate(map[string]interface{}{"nick": ""})).NotTo(BeNil())
+		NewWithT(t).Expect(s.Validate(map[string]interface{}{"other": 1})).NotTo(BeNil())
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		data, err := yaml.Marshal(s)
+		NewWithT(t).Expect(err).To(BeNil())
+
+		parsed := Schema{}
+		NewWithT(t).Expect(yaml.Unmarshal(data, &parsed)).To(BeNil())
+		NewWithT(t).Expect(parsed).To(Equal(s))
+	})
+
+	t.Run("invalid type", func(t *testing.T) {
+		NewWithT(t).Expect(yaml.Unmarshal([]byte(`type: 1`), &Schema{})).NotTo(BeNil())
+	})
+}
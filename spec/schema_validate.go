@@ -0,0 +1,336 @@
+package spec
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+type SchemaError struct {
+	// path of value, like $.items[0].name
+	Path string
+	Msg  string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Msg)
+}
+
+type SchemaErrors []*SchemaError
+
+func (errs SchemaErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i := range errs {
+		msgs[i] = errs[i].Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Validate checks value decoded from json by encoding/json against the schema,
+// $ref resolved in definitions of the schema.
+// All violations found are returned as SchemaErrors.
+func (s *Schema) Validate(value interface{}) error {
+	return s.validateIn(s, value)
+}
+
+func (s *Schema) validateIn(root *Schema, value interface{}) error {
+	errs := SchemaErrors{}
+
+	v := &schemaValidator{root: root, report: func(path string, format string, args ...interface{}) {
+		errs = append(errs, &SchemaError{Path: path, Msg: fmt.Sprintf(format, args...)})
+	}}
+
+	v.validate("$", s, value)
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+type reportSchemaError = func(path string, format string, args ...interface{})
+
+type schemaValidator struct {
+	root   *Schema
+	report reportSchemaError
+}
+
+// max hops of $ref to $ref, to stop on cycles like a -> b -> a
+const maxRefDepth = 32
+
+// resolve $ref like #/definitions/Person in root
+func (v *schemaValidator) resolve(path string, s *Schema) *Schema {
+	for i := 0; s != nil && s.Ref != ""; i++ {
+		resolved := resolveRef(v.root, s.Ref)
+		if resolved == nil || i > maxRefDepth {
+			v.report(path, "unresolvable $ref %s", s.Ref)
+			return nil
+		}
+		s = resolved
+	}
+	return s
+}
+
+func resolveRef(root *Schema, ref string) *Schema {
+	if ref == "#" {
+		return root
+	}
+	if name := strings.TrimPrefix(ref, "#/definitions/"); name != ref && root != nil {
+		return root.Definitions[name]
+	}
+	return nil
+}
+
+func (v *schemaValidator) matches(s *Schema, value interface{}) bool {
+	return s.validateIn(v.root, value) == nil
+}
+
+func (v *schemaValidator) validate(path string, s *Schema, value interface{}) {
+	s = v.resolve(path, s)
+	if s == nil {
+		return
+	}
+
+	if value == nil && s.Nullable {
+		return
+	}
+
+	if s.Type != "" && !matchType(s.Type, value) {
+		v.report(path, "should be %s, but got %s", s.Type, typeOf(value))
+		return
+	}
+
+	if len(s.Enum) > 0 {
+		matched := false
+		for _, e := range s.Enum {
+			if equalJSON(e, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			v.report(path, "should be one of %s", toJSON(s.Enum))
+		}
+	}
+
+	if s.Const != nil && !equalJSON(s.Const, value) {
+		v.report(path, "should be %s", toJSON(s.Const))
+	}
+
+	switch x := value.(type) {
+	case float64:
+		v.validateNumber(path, s, x)
+	case string:
+		v.validateString(path, s, x)
+	case []interface{}:
+		v.validateArray(path, s, x)
+	case map[string]interface{}:
+		v.validateObject(path, s, x)
+	}
+
+	for _, sub := range s.AllOf {
+		v.validate(path, sub, value)
+	}
+
+	if len(s.AnyOf) > 0 {
+		matched := false
+		for _, sub := range s.AnyOf {
+			if v.matches(sub, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			v.report(path, "should match any of anyOf")
+		}
+	}
+
+	if len(s.OneOf) > 0 {
+		matched := 0
+		for _, sub := range s.OneOf {
+			if v.matches(sub, value) {
+				matched++
+			}
+		}
+		if matched != 1 {
+			v.report(path, "should match exactly one of oneOf, but matched %d", matched)
+		}
+	}
+
+	if s.Not != nil && v.matches(s.Not, value) {
+		if s.isFalse() {
+			v.report(path, "not allowed")
+		} else {
+			v.report(path, "should not match not")
+		}
+	}
+}
+
+func (v *schemaValidator) validateNumber(path string, s *Schema, n float64) {
+	if s.Minimum != nil && n < *s.Minimum {
+		v.report(path, "should be >= %v", *s.Minimum)
+	}
+	if s.Maximum != nil && n > *s.Maximum {
+		v.report(path, "should be <= %v", *s.Maximum)
+	}
+	if s.ExclusiveMinimum != nil && n <= *s.ExclusiveMinimum {
+		v.report(path, "should be > %v", *s.ExclusiveMinimum)
+	}
+	if s.ExclusiveMaximum != nil && n >= *s.ExclusiveMaximum {
+		v.report(path, "should be < %v", *s.ExclusiveMaximum)
+	}
+}
+
+func (v *schemaValidator) validateString(path string, s *Schema, str string) {
+	n := uint64(utf8.RuneCountInString(str))
+
+	if s.MinLength != nil && n < *s.MinLength {
+		v.report(path, "length should be >= %d", *s.MinLength)
+	}
+	if s.MaxLength != nil && n > *s.MaxLength {
+		v.report(path, "length should be <= %d", *s.MaxLength)
+	}
+
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			v.report(path, "invalid pattern %s", s.Pattern)
+		} else if !re.MatchString(str) {
+			v.report(path, "should match pattern %s", s.Pattern)
+		}
+	}
+}
+
+func (v *schemaValidator) validateArray(path string, s *Schema, list []interface{}) {
+	n := uint64(len(list))
+
+	if s.MinItems != nil && n < *s.MinItems {
+		v.report(path, "items should be >= %d", *s.MinItems)
+	}
+	if s.MaxItems != nil && n > *s.MaxItems {
+		v.report(path, "items should be <= %d", *s.MaxItems)
+	}
+
+	if s.Items != nil {
+		for i := range list {
+			v.validate(fmt.Sprintf("%s[%d]", path, i), s.Items, list[i])
+		}
+	}
+}
+
+func (v *schemaValidator) validateObject(path string, s *Schema, obj map[string]interface{}) {
+	for _, key := range s.Required {
+		if _, ok := obj[key]; !ok {
+			v.report(propPath(path, key), "required")
+		}
+	}
+
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if s.PropertyNames != nil {
+			v.validate(propPath(path, key), s.PropertyNames, key)
+		}
+
+		if prop, ok := s.Properties[key]; ok {
+			v.validate(propPath(path, key), prop, obj[key])
+			continue
+		}
+
+		if s.AdditionalProperties != nil {
+			v.validate(propPath(path, key), s.AdditionalProperties, obj[key])
+		}
+	}
+}
+
+func propPath(path string, key string) string {
+	return path + "." + key
+}
+
+func matchType(typ string, value interface{}) bool {
+	switch typ {
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	default:
+		return typeOf(value) == typ
+	}
+}
+
+func typeOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// equalJSON compares values in json, since values in schema may be declared in go types, like int.
+func equalJSON(a interface{}, b interface{}) bool {
+	var x, y interface{}
+	_ = json.Unmarshal([]byte(toJSON(a)), &x)
+	_ = json.Unmarshal([]byte(toJSON(b)), &y)
+	return reflect.DeepEqual(x, y)
+}
+
+func toJSON(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// Declared returns whether content type or schema declared
+func (d DataSchema) Declared() bool {
+	return d.ContentType != "" || !reflect.DeepEqual(d.Schema, Schema{})
+}
+
+// MatchContentType checks media type of contentType with the declared one,
+// wildcards like */* or image/* supported, and parameters are ignored.
+func (d DataSchema) MatchContentType(contentType string) bool {
+	if d.ContentType == "" {
+		return true
+	}
+
+	declared, actual := mediaType(d.ContentType), mediaType(contentType)
+
+	if declared == "*/*" || declared == actual {
+		return true
+	}
+
+	if strings.HasSuffix(declared, "/*") {
+		return strings.HasPrefix(actual, strings.TrimSuffix(declared, "*"))
+	}
+
+	return false
+}
+
+// IsJSON returns whether payload declared as json, like application/json or application/ld+json
+func (d DataSchema) IsJSON() bool {
+	t := mediaType(d.ContentType)
+	return t == "application/json" || strings.HasSuffix(t, "+json")
+}
+
+func mediaType(contentType string) string {
+	return strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+}
@@ -0,0 +1,148 @@
+package spec
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	s := &Schema{
+		Type:     "object",
+		Required: []string{"name", "tags"},
+		Properties: map[string]*Schema{
+			"name": {Type: "string"},
+			"age":  {Type: "integer"},
+			"tags": {Type: "array", Items: &Schema{Type: "string"}},
+		},
+		AdditionalProperties: &Schema{Type: "boolean"},
+	}
+
+	validate := func(data string) error {
+		var v interface{}
+		if err := json.Unmarshal([]byte(data), &v); err != nil {
+			panic(err)
+		}
+		return s.Validate(v)
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		NewWithT(t).Expect(validate(`{"name":"x","age":1,"tags":["a"],"ok":true}`)).To(BeNil())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		err := validate(`{"age":1.5,"tags":["a",1],"ok":"yes"}`)
+
+		NewWithT(t).Expect(err).To(Equal(SchemaErrors{
+			{Path: "$.name", Msg: "required"},
+			{Path: "$.age", Msg: "should be integer, but got number"},
+			{Path: "$.ok", Msg: "should be boolean, but got string"},
+			{Path: "$.tags[1]", Msg: "should be string, but got number"},
+		}))
+	})
+
+	t.Run("oneOf", func(t *testing.T) {
+		s := &Schema{OneOf: []*Schema{{Type: "string"}, {Type: "number"}}}
+
+		NewWithT(t).Expect(s.Validate("x")).To(BeNil())
+		NewWithT(t).Expect(s.Validate(true)).NotTo(BeNil())
+	})
+}
+
+func TestSchemaValidateKeywords(t *testing.T) {
+	min, exclusiveMax := float64(0), float64(150)
+	minLength, maxItems := uint64(1), uint64(2)
+
+	s := &Schema{
+		Type:     "object",
+		Required: []string{"name"},
+		Properties: map[string]*Schema{
+			"name":   {Type: "string", MinLength: &minLength, Pattern: "^[a-z]+$"},
+			"age":    {Type: "integer", Minimum: &min, ExclusiveMaximum: &exclusiveMax},
+			"kind":   {Enum: []interface{}{"cat", "dog"}},
+			"v":      {Const: 1},
+			"nick":   {Type: "string", Nullable: true},
+			"id":     {AnyOf: []*Schema{{Type: "string"}, {Type: "integer"}}},
+			"owner":  {Ref: "#/definitions/Person"},
+			"tags":   {Type: "array", MaxItems: &maxItems, Items: &Schema{Not: &Schema{Type: "number"}}},
+			"secret": {Not: &Schema{}},
+		},
+		Definitions: map[string]*Schema{
+			"Person": {Type: "object", Required: []string{"name"}},
+		},
+	}
+
+	validate := func(data string) error {
+		var v interface{}
+		if err := json.Unmarshal([]byte(data), &v); err != nil {
+			panic(err)
+		}
+		return s.Validate(v)
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		NewWithT(t).Expect(validate(`{"name":"x","age":0,"kind":"cat","v":1,"nick":null,"id":1,"owner":{"name":"y"},"tags":["a"]}`)).To(BeNil())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		err := validate(`{"name":"X","age":150,"kind":"fish","v":2,"nick":1,"id":true,"owner":{},"tags":["a",1,"b"],"secret":"s"}`)
+
+		NewWithT(t).Expect(err).To(Equal(SchemaErrors{
+			{Path: "$.age", Msg: "should be < 150"},
+			{Path: "$.id", Msg: "should match any of anyOf"},
+			{Path: "$.kind", Msg: `should be one of ["cat","dog"]`},
+			{Path: "$.name", Msg: "should match pattern ^[a-z]+$"},
+			{Path: "$.nick", Msg: "should be string, but got number"},
+			{Path: "$.owner.name", Msg: "required"},
+			{Path: "$.secret", Msg: "not allowed"},
+			{Path: "$.tags", Msg: "items should be <= 2"},
+			{Path: "$.tags[1]", Msg: "should not match not"},
+			{Path: "$.v", Msg: "should be 1"},
+		}))
+	})
+
+	t.Run("unresolvable $ref", func(t *testing.T) {
+		s := &Schema{Ref: "#/definitions/Missing"}
+
+		NewWithT(t).Expect(s.Validate("x")).To(Equal(SchemaErrors{
+			{Path: "$", Msg: "unresolvable $ref #/definitions/Missing"},
+		}))
+	})
+}
+
+func TestDataSchemaMatchContentType(t *testing.T) {
+	NewWithT(t).Expect(DataSchema{}.MatchContentType("text/plain")).To(BeTrue())
+	NewWithT(t).Expect(DataSchema{ContentType: "application/json"}.MatchContentType("application/json; charset=utf-8")).To(BeTrue())
+	NewWithT(t).Expect(DataSchema{ContentType: "image/*"}.MatchContentType("image/png")).To(BeTrue())
+	NewWithT(t).Expect(DataSchema{ContentType: "image/*"}.MatchContentType("text/plain")).To(BeFalse())
+	NewWithT(t).Expect(DataSchema{ContentType: "application/ld+json"}.IsJSON()).To(BeTrue())
+}
+
+func TestSchemaCompatibleWith(t *testing.T) {
+	upstream := &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"owner": {Ref: "#/definitions/Person"},
+		},
+		Required: []string{"owner"},
+		Definitions: map[string]*Schema{
+			"Person": {Type: "object", Properties: map[string]*Schema{"name": {Type: "string", Nullable: true}}},
+		},
+	}
+
+	downstream := &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"owner": {Ref: "#/definitions/Owner"},
+		},
+		Definitions: map[string]*Schema{
+			"Owner": {Type: "object", Required: []string{"name"}, Properties: map[string]*Schema{"name": {Type: "string"}}},
+		},
+	}
+
+	NewWithT(t).Expect(upstream.CompatibleWith(downstream)).To(Equal([]string{
+		"$.owner.name: optional, but required",
+		"$.owner.name: nullable, but null not allowed",
+	}))
+}